@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -26,10 +29,16 @@ func runTestServer() {
 
 	r := gin.Default()
 	v1 := r.Group("/api/v1")
-	api.SetupRouter(v1, testCfg.TableRegion, testCfg.TableName)
+	api.SetupRouter(v1, newTestStore(), api.Options{})
+
+	// Listen before serving so that tests do not race with server startup.
+	ln, err := net.Listen("tcp", apiEndPoint)
+	if err != nil {
+		log.Fatalf("Fail to listen %s: %s", apiEndPoint, err)
+	}
 
 	go func() {
-		r.Run(apiEndPoint)
+		http.Serve(ln, r)
 	}()
 }
 
@@ -144,6 +153,203 @@ func TestReportAPI(t *testing.T) {
 	assert.Equal(t, "", resp5.Error)
 }
 
+func TestReportNotesAPI(t *testing.T) {
+	type Report struct {
+		Results api.Report `json:"results,omitempty"`
+	}
+	type Task struct {
+		Results api.Task `json:"results,omitempty"`
+	}
+	type Hits struct {
+		Results []api.SearchHit `json:"results,omitempty"`
+	}
+	var (
+		code   int
+		err    error
+		report Report
+		task   Task
+		hits   Hits
+	)
+	uid := strings.Replace(uuid.New().String(), "-", "", -1)
+
+	getRaw := func(path string) (int, string, string) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/%s", apiEndPoint, path))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+	}
+
+	// Rendering does not create a report.
+	code, _, _ = getRaw(uid + "/2019-04-01/report.md")
+	assert.Equal(t, 404, code)
+
+	code, err = httpRequest("GET", uid+"/2019-04-01", nil, &report)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Nil(t, report.Results.Notes)
+
+	notes := map[string]interface{}{
+		"notes": map[string]string{
+			"summary":   "Released the new parser",
+			"blockers":  "",
+			"learnings": "Benchmark <b>before</b> optimizing",
+		},
+	}
+	code, err = httpRequest("PUT", uid+"/2019-04-01", notes, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, err = httpRequest("GET", uid+"/2019-04-01", nil, &report)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.NotNil(t, report.Results.Notes)
+	assert.Equal(t, "Released the new parser", report.Results.Notes.Summary)
+	// Status is kept if not given.
+	assert.Equal(t, "edit", string(report.Results.Status))
+
+	// Notes are kept if not given.
+	code, err = httpRequest("PUT", uid+"/2019-04-01", map[string]string{"Status": "work"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", uid+"/2019-04-01", nil, &report)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, "work", string(report.Results.Status))
+	require.NotNil(t, report.Results.Notes)
+	assert.Equal(t, "Released the new parser", report.Results.Notes.Summary)
+
+	code, err = httpRequest("POST", uid+"/2019-04-01/task", map[string]string{"title": "parser"}, &task)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("PUT", uid+"/2019-04-01/task/"+task.Results.TaskID, map[string]interface{}{"title": "parser", "tomato_num": 2, "status": "done"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("POST", uid+"/2019-04-01/task", map[string]string{"title": "review"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("POST", uid+"/2019-04-01/chore", map[string]string{"title": "mail"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, contentType, md := getRaw(uid + "/2019-04-01/report.md")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, contentType, "text/markdown")
+	assert.Contains(t, md, "# Daily report 2019-04-01")
+	assert.Contains(t, md, "## Summary\n\nReleased the new parser")
+	assert.Contains(t, md, "## Completed tasks\n\n- parser (0/2 pomodoros)")
+	assert.Contains(t, md, "## Remaining tasks\n\n- review (todo, 0/1 pomodoros)")
+	assert.Contains(t, md, "- [ ] mail")
+	assert.Contains(t, md, "- Finished: 0")
+	assert.NotContains(t, md, "## Blockers")
+	assert.Contains(t, md, "## Learnings")
+
+	code, contentType, html := getRaw(uid + "/2019-04-01/report.html")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, contentType, "text/html")
+	assert.Contains(t, html, "<h1>Daily report 2019-04-01</h1>")
+	assert.Contains(t, html, "<li>parser (0/2 pomodoros)</li>")
+	assert.NotContains(t, html, "<b>before</b>")
+
+	code, err = httpRequest("GET", uid+"/search?q=parser&type=report", nil, &hits)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.Equal(t, 1, len(hits.Results))
+	assert.Equal(t, "20190401", hits.Results[0].ID)
+	assert.Equal(t, "Released the new parser", hits.Results[0].Title)
+
+	code, err = httpRequest("DELETE", uid+"/2019-04-01", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", uid+"/search?q=parser&type=report", nil, &hits)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, 0, len(hits.Results))
+}
+
+func TestReportTemplateAPI(t *testing.T) {
+	type Template struct {
+		Results api.ReportTemplate `json:"results,omitempty"`
+	}
+	type Templates struct {
+		Results []api.ReportTemplate `json:"results,omitempty"`
+	}
+	var (
+		code      int
+		err       error
+		tmpl      Template
+		templates Templates
+	)
+	uid := strings.Replace(uuid.New().String(), "-", "", -1)
+
+	getRaw := func(path string) (int, string) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/%s", apiEndPoint, path))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, err = httpRequest("POST", uid+"/report-templates", map[string]interface{}{"name": "bad", "body": "{{ .Days"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, code)
+
+	req := map[string]interface{}{
+		"name": "weekly",
+		"body": "{{ .Begin }}..{{ .End }}:{{ range .Days }}{{ range .CompletedTasks }} {{ .Title }}{{ end }}{{ end }}",
+	}
+	code, err = httpRequest("POST", uid+"/report-templates", req, &tmpl)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, "weekly", tmpl.Results.Name)
+	templateID := tmpl.Results.TemplateID
+
+	code, err = httpRequest("GET", uid+"/report-templates", nil, &templates)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, 1, len(templates.Results))
+
+	code, err = httpRequest("POST", uid+"/2019-04-02/task", map[string]string{"title": "parser"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	var task struct {
+		Results api.Task `json:"results,omitempty"`
+	}
+	code, err = httpRequest("POST", uid+"/2019-04-03/task", map[string]string{"title": "docs"}, &task)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("PUT", uid+"/2019-04-03/task/"+task.Results.TaskID, map[string]interface{}{"title": "docs", "status": "done"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, body := getRaw(uid + "/report-templates/" + templateID + "/render?begin=2019-04-01&end=2019-04-07")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "2019-04-01..2019-04-07: docs", body)
+
+	code, body = getRaw(uid + "/report-templates/" + templateID + "/render?date=2019-04-02")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "2019-04-02..2019-04-02:", body)
+
+	code, _ = getRaw(uid + "/report-templates/" + templateID + "/render?begin=2019-04-01&end=2019-06-01")
+	assert.Equal(t, 400, code)
+
+	req["body"] = "{{ .Unknown }}"
+	code, err = httpRequest("PUT", uid+"/report-templates/"+templateID, req, &tmpl)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, _ = getRaw(uid + "/report-templates/" + templateID + "/render?date=2019-04-02")
+	assert.Equal(t, 400, code)
+
+	code, err = httpRequest("DELETE", uid+"/report-templates/"+templateID, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", uid+"/report-templates/"+templateID, nil, &tmpl)
+	require.NoError(t, err)
+	assert.Equal(t, 404, code)
+}
+
 func TestTaskAPI(t *testing.T) {
 	type Task struct {
 		Results api.Task `json:"results,omitempty"`
@@ -162,6 +368,89 @@ func TestTaskAPI(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 200, code)
 	assert.Equal(t, 0, len(tasks.Results))
+
+	var t1, t2 Task
+	code, err = httpRequest("POST", uid+"/2018-03-22/task", map[string]string{"title": "one"}, &t1)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, api.TaskTodo, t1.Results.Status)
+	code, err = httpRequest("POST", uid+"/2018-03-22/task", map[string]string{"title": "two"}, &t2)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	t1.Results.Status = api.TaskDone
+	code, err = httpRequest("PUT", uid+"/2018-03-22/task/"+t1.Results.TaskID, t1.Results, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, code)
+
+	t1.Results.Status = api.TaskDropped
+	code, err = httpRequest("PUT", uid+"/2018-03-22/task/"+t1.Results.TaskID, t1.Results, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 409, code)
+
+	code, err = httpRequest("GET", uid+"/2018-03-22/task?status=done", nil, &tasks)
+	require.NoError(t, err)
+	assert.Equal(t, 200, code)
+	require.Equal(t, 1, len(tasks.Results))
+	assert.Equal(t, t1.Results.TaskID, tasks.Results[0].TaskID)
+	assert.False(t, tasks.Results[0].CompletedAt.IsZero())
+
+	code, err = httpRequest("GET", uid+"/2018-03-22/task?status=todo,in-progress", nil, &tasks)
+	require.NoError(t, err)
+	assert.Equal(t, 200, code)
+	require.Equal(t, 1, len(tasks.Results))
+	assert.Equal(t, t2.Results.TaskID, tasks.Results[0].TaskID)
+
+	code, err = httpRequest("GET", uid+"/2018-03-22/task?status=finished", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, code)
+
+	// Done report has summary of tasks.
+	var report struct {
+		Results api.Report `json:"results,omitempty"`
+	}
+	code, err = httpRequest("GET", uid+"/2018-03-22", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("PUT", uid+"/2018-03-22", api.Report{Status: api.ReportDone}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", uid+"/2018-03-22", nil, &report)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.NotNil(t, report.Results.Summary)
+	assert.Equal(t, api.TaskSummary{Planned: 2, Completed: 1}, *report.Results.Summary)
+
+	code, err = httpRequest("DELETE", uid+"/2018-03-22/task/"+t2.Results.TaskID, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", uid+"/2018-03-22", nil, &report)
+	require.NoError(t, err)
+	require.NotNil(t, report.Results.Summary)
+	assert.Equal(t, api.TaskSummary{Planned: 1, Completed: 1}, *report.Results.Summary)
+
+	// Report is not saved if the summary is not changed.
+	version := report.Results.Version
+	code, err = httpRequest("PUT", uid+"/2018-03-22/task/"+t1.Results.TaskID, map[string]interface{}{"title": "one again", "tomato_num": 1}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", uid+"/2018-03-22", nil, &report)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, version, report.Results.Version)
+
+	var hits struct {
+		Results []api.SearchHit `json:"results,omitempty"`
+	}
+	code, err = httpRequest("GET", uid+"/search?q=one&type=task&begin=2018-03-01&end=2018-03-31", nil, &hits)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.Equal(t, 1, len(hits.Results))
+	assert.Equal(t, t1.Results.TaskID, hits.Results[0].ID)
+
+	code, err = httpRequest("GET", uid+"/search?q=one&limit=x", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, code)
 }
 
 func TestPomodoroAPI(t *testing.T) {
@@ -207,6 +496,60 @@ func TestPomodoroAPI(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 404, code)
 
+	// Breaks are not listed as pomodoros.
+	var next struct {
+		Results api.NextStep `json:"results"`
+	}
+	code, err = httpRequest("GET", uid+"/1983-04-20/next", nil, &next)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, api.StepWork, next.Results.Step)
+	assert.True(t, next.Results.InProgress)
+
+	code, err = httpRequest("POST", uid+"/1983-04-20/break", map[string]string{"kind": "short"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", uid+"/1983-04-20/pomodoro", nil, &pomodoros)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, 1, len(pomodoros.Results))
+
+	// Interruptions
+	code, err = httpRequest("POST", uid+"/1983-04-20/pomodoro/"+task.Results.TaskID+"/"+pomodoro.Results.PomodoroID+"/interruptions",
+		map[string]interface{}{"type": "internal", "note": "idea"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("POST", uid+"/1983-04-20/pomodoro/"+task.Results.TaskID+"/"+pomodoro.Results.PomodoroID+"/interruptions",
+		map[string]interface{}{"type": "external", "note": "phone call", "abandon": true}, &pomodoro)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, api.PomodoroAbandoned, pomodoro.Results.Status)
+	assert.Equal(t, 2, len(pomodoro.Results.Interruptions))
+
+	code, err = httpRequest("POST", uid+"/1983-04-20/pomodoro/"+task.Results.TaskID+"/"+pomodoro.Results.PomodoroID+"/interruptions",
+		map[string]interface{}{"type": "external"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 409, code)
+
+	var tasks Tasks
+	code, err = httpRequest("GET", uid+"/1983-04-20/task", nil, &tasks)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.Equal(t, 1, len(tasks.Results))
+	require.NotNil(t, tasks.Results[0].Focus)
+	assert.Equal(t, api.FocusStats{Pomodoros: 1, Abandoned: 1, InternalInterruptions: 1, ExternalInterruptions: 1}, *tasks.Results[0].Focus)
+	require.NotNil(t, tasks.Results[0].Estimate)
+	assert.Equal(t, api.Estimate{Estimated: 1, Actual: 0, Diff: -1, Result: "over"}, *tasks.Results[0].Estimate)
+
+	var report struct {
+		Results api.Report `json:"results"`
+	}
+	code, err = httpRequest("GET", uid+"/1983-04-20", nil, &report)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.NotNil(t, report.Results.Focus)
+	assert.Equal(t, 1, report.Results.Focus.Abandoned)
+
 	// Remote task
 	code, err = httpRequest("DELETE", uid+"/1983-04-20/task/"+task.Results.TaskID, nil, nil)
 	require.NoError(t, err)
@@ -217,3 +560,491 @@ func TestPomodoroAPI(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 404, code)
 }
+
+func TestAutoCarryOver(t *testing.T) {
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{CarryOver: api.CarryOverMove})
+
+	request := func(method, path string, body interface{}, out interface{}) int {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req := httptest.NewRequest(method, "/api/v1/"+path, &buf)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if out != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		}
+		return w.Code
+	}
+
+	var task struct {
+		Results api.Task `json:"results"`
+	}
+	require.Equal(t, 200, request("GET", "orange/2019-04-01", nil, nil))
+	require.Equal(t, 200, request("POST", "orange/2019-04-01/task", map[string]string{"title": "five"}, &task))
+	require.Equal(t, 200, request("PUT", "orange/2019-04-01", api.Report{Status: api.ReportDone}, nil))
+
+	var tasks struct {
+		Results []api.Task `json:"results"`
+	}
+	require.Equal(t, 200, request("GET", "orange/2019-04-02/task", nil, &tasks))
+	require.Equal(t, 1, len(tasks.Results))
+	assert.Equal(t, "five", tasks.Results[0].Title)
+	assert.Equal(t, task.Results.TaskID, tasks.Results[0].CarriedFromTaskID)
+
+	var history struct {
+		Results []api.Task `json:"results"`
+	}
+	// The original task has been moved.
+	require.Equal(t, 200, request("GET", "orange/2019-04-02/task/"+tasks.Results[0].TaskID+"/history", nil, &history))
+	assert.Equal(t, 1, len(history.Results))
+
+	// Carry over by endpoint, copy by default.
+	require.Equal(t, 200, request("POST", "orange/2019-04-02/carryover", map[string]string{"to": "2019-04-05"}, &tasks))
+	require.Equal(t, 1, len(tasks.Results))
+	require.Equal(t, 200, request("GET", "orange/2019-04-05/task/"+tasks.Results[0].TaskID+"/history", nil, &history))
+	assert.Equal(t, 2, len(history.Results))
+	assert.Equal(t, 400, request("POST", "orange/2019-04-02/carryover", map[string]string{"mode": "keep"}, nil))
+}
+
+func TestStatsAPI(t *testing.T) {
+	type Task struct {
+		Results api.Task `json:"results,omitempty"`
+	}
+	type Pomodoro struct {
+		Results api.Pomodoro `json:"results,omitempty"`
+	}
+	type Stats struct {
+		Results api.Stats `json:"results,omitempty"`
+	}
+	var (
+		code     int
+		err      error
+		task     Task
+		pomodoro Pomodoro
+		stats    Stats
+	)
+	uid := strings.Replace(uuid.New().String(), "-", "", -1)
+
+	code, err = httpRequest("POST", uid+"/2019-04-01/task", map[string]string{"title": "one"}, &task)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("PUT", uid+"/2019-04-01/task/"+task.Results.TaskID, map[string]interface{}{"title": "one", "tomato_num": 1, "status": "done"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, err = httpRequest("POST", uid+"/2019-04-01/pomodoro/"+task.Results.TaskID, nil, &pomodoro)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("PUT", uid+"/2019-04-01/pomodoro/"+task.Results.TaskID+"/"+pomodoro.Results.PomodoroID, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, err = httpRequest("POST", uid+"/2019-04-01/task", map[string]string{"title": "two"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("POST", uid+"/2019-04-01/chore", map[string]string{"title": "mail"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, err = httpRequest("POST", uid+"/2019-04-02/task", map[string]string{"title": "three"}, &task)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("PUT", uid+"/2019-04-02/task/"+task.Results.TaskID, map[string]interface{}{"title": "three", "status": "done"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, err = httpRequest("GET", uid+"/stats?begin=2019-04-01&end=2019-04-03", nil, &stats)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.Equal(t, 3, len(stats.Results.Days))
+	assert.Equal(t, "2019-04-01", stats.Results.Days[0].Date)
+	assert.Equal(t, 2, stats.Results.Days[0].Tasks)
+	assert.Equal(t, 0, stats.Results.Days[2].Tasks)
+	assert.Equal(t, 3, stats.Results.Tasks)
+	assert.Equal(t, 2, stats.Results.TasksDone)
+	assert.Equal(t, 1, stats.Results.Chores)
+	assert.Equal(t, 1, stats.Results.Pomodoros)
+	assert.Equal(t, 1, stats.Results.PomodorosFinished)
+	assert.InDelta(t, 2.0/3.0, stats.Results.TaskCompletionRate, 0.001)
+	require.NotNil(t, stats.Results.BusiestHour)
+	assert.Equal(t, pomodoro.Results.StartedAt.UTC().Hour(), *stats.Results.BusiestHour)
+	assert.Equal(t, 2, stats.Results.LongestStreak)
+	assert.Equal(t, 0, stats.Results.CurrentStreak)
+
+	code, err = httpRequest("GET", uid+"/stats?begin=2019-04-03&end=2019-04-01", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, code)
+}
+
+func TestConditionalUpdateAPI(t *testing.T) {
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{})
+
+	request := func(method, path, ifMatch string, body interface{}, out interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req := httptest.NewRequest(method, "/api/v1/"+path, &buf)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if out != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		}
+		return w
+	}
+
+	var task struct {
+		Results api.Task `json:"results"`
+	}
+	w := request("POST", "blue/2019-04-01/task", "", map[string]string{"title": "five"}, &task)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, 1, task.Results.Version)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	path := "blue/2019-04-01/task/" + task.Results.TaskID
+
+	w = request("PUT", path, `"1"`, map[string]string{"title": "six"}, nil)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	// Stale update is rejected with current state.
+	var stale struct {
+		Detail  string   `json:"detail"`
+		Current api.Task `json:"current"`
+	}
+	w = request("PUT", path, `"1"`, map[string]string{"title": "seven"}, &stale)
+	require.Equal(t, 412, w.Code)
+	assert.NotEmpty(t, stale.Detail)
+	assert.Equal(t, "six", stale.Current.Title)
+	assert.Equal(t, 2, stale.Current.Version)
+
+	w = request("DELETE", path, `"1", W/"3"`, nil, nil)
+	assert.Equal(t, 412, w.Code)
+
+	var report struct {
+		Results api.Report `json:"results"`
+	}
+	w = request("GET", "blue/2019-04-01", "", nil, &report)
+	require.Equal(t, 200, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, fmt.Sprintf(`"%d"`, report.Results.Version), etag)
+	w = request("PUT", "blue/2019-04-01", etag, map[string]string{"status": "work"}, nil)
+	require.Equal(t, 200, w.Code)
+	w = request("PUT", "blue/2019-04-01", etag, map[string]string{"status": "done"}, nil)
+	assert.Equal(t, 412, w.Code)
+
+	w = request("DELETE", path, "*", nil, nil)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestPatchAPI(t *testing.T) {
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{})
+
+	request := func(method, path, contentType string, body string, out interface{}) int {
+		req := httptest.NewRequest(method, "/api/v1/"+path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if out != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		}
+		return w.Code
+	}
+	const mergePatch = "application/merge-patch+json"
+	const jsonPatch = "application/json-patch+json"
+
+	var task struct {
+		Results api.Task `json:"results"`
+	}
+	require.Equal(t, 200, request("POST", "blue/2019-04-01/task", "", `{"title": "five"}`, &task))
+	path := "blue/2019-04-01/task/" + task.Results.TaskID
+	require.Equal(t, 200, request("PUT", path, "", `{"title": "five", "tomato_num": 3, "description": "desc"}`, nil))
+
+	// Only given fields are changed.
+	require.Equal(t, 200, request("PATCH", path, mergePatch, `{"title": "six"}`, &task))
+	assert.Equal(t, "six", task.Results.Title)
+	assert.Equal(t, int64(3), task.Results.TomatoNum)
+	assert.Equal(t, "desc", task.Results.Description)
+	assert.Equal(t, 3, task.Results.Version)
+
+	require.Equal(t, 200, request("PATCH", path, "application/json", `{"status": "done", "description": null}`, &task))
+	assert.Equal(t, api.TaskDone, task.Results.Status)
+	assert.False(t, task.Results.CompletedAt.IsZero())
+	assert.Equal(t, "", task.Results.Description)
+
+	require.Equal(t, 200, request("PATCH", path, jsonPatch, `[
+		{"op": "test", "path": "/title", "value": "six"},
+		{"op": "replace", "path": "/tomato_num", "value": 4},
+		{"op": "copy", "from": "/title", "path": "/description"}
+	]`, &task))
+	assert.Equal(t, int64(4), task.Results.TomatoNum)
+	assert.Equal(t, "six", task.Results.Description)
+
+	var tasks struct {
+		Results []api.Task `json:"results"`
+	}
+	require.Equal(t, 200, request("GET", "blue/2019-04-01/task", "", "", &tasks))
+	require.Equal(t, 1, len(tasks.Results))
+	assert.Equal(t, "six", tasks.Results[0].Title)
+	assert.Equal(t, int64(4), tasks.Results[0].TomatoNum)
+
+	// Invalid patches do not change the task.
+	assert.Equal(t, 409, request("PATCH", path, jsonPatch, `[{"op": "test", "path": "/title", "value": "five"}]`, nil))
+	assert.Equal(t, 400, request("PATCH", path, jsonPatch, `[{"op": "replace", "path": "/nothing", "value": 1}]`, nil))
+	assert.Equal(t, 400, request("PATCH", path, mergePatch, `{"title": ""}`, nil))
+	assert.Equal(t, 400, request("PATCH", path, mergePatch, `{"tomato_num": "many"}`, nil))
+	assert.Equal(t, 400, request("PATCH", path, mergePatch, `{"task_id": "other"}`, nil))
+	assert.Equal(t, 400, request("PATCH", path, mergePatch, `[]`, nil))
+	assert.Equal(t, 409, request("PATCH", path, mergePatch, `{"status": "dropped"}`, nil))
+	assert.Equal(t, 415, request("PATCH", path, "text/plain", `title`, nil))
+	assert.Equal(t, 404, request("PATCH", "blue/2019-04-01/task/nothing", mergePatch, `{"title": "x"}`, nil))
+
+	// Chore can be marked done.
+	var chore struct {
+		Results api.Chore `json:"results"`
+	}
+	require.Equal(t, 200, request("POST", "blue/2019-04-01/chore", "", `{"title": "mail"}`, &chore))
+	require.Equal(t, 200, request("PATCH", "blue/2019-04-01/chore/"+chore.Results.ChoreID, mergePatch, `{"done": true}`, &chore))
+	assert.True(t, chore.Results.Done)
+	assert.Equal(t, "mail", chore.Results.Title)
+
+	// Notes of report are merged.
+	var report struct {
+		Results api.Report `json:"results"`
+	}
+	require.Equal(t, 200, request("GET", "blue/2019-04-01", "", "", nil))
+	require.Equal(t, 200, request("PATCH", "blue/2019-04-01", mergePatch, `{"notes": {"summary": "good"}}`, &report))
+	require.Equal(t, 200, request("PATCH", "blue/2019-04-01", mergePatch, `{"notes": {"blockers": "none"}, "Status": "done"}`, &report))
+	require.NotNil(t, report.Results.Notes)
+	assert.Equal(t, "good", report.Results.Notes.Summary)
+	assert.Equal(t, "none", report.Results.Notes.Blockers)
+	assert.Equal(t, api.ReportStatus(api.ReportDone), report.Results.Status)
+	require.NotNil(t, report.Results.Summary)
+	assert.Equal(t, 1, report.Results.Summary.Completed)
+}
+
+func TestValidationAPI(t *testing.T) {
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{})
+
+	type Failure struct {
+		Detail string           `json:"detail"`
+		Errors []api.FieldError `json:"errors"`
+	}
+	request := func(method, path string, body string) (int, Failure) {
+		req := httptest.NewRequest(method, "/api/v1/"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var failure Failure
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &failure))
+		return w.Code, failure
+	}
+
+	var task struct {
+		Results api.Task `json:"results"`
+	}
+	req := httptest.NewRequest("POST", "/api/v1/blue/2019-04-01/task", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	path := "blue/2019-04-01/task/" + task.Results.TaskID
+
+	code, failure := request("PUT", path, `{"title": "`+strings.Repeat("x", 257)+`", "tomato_num": -1}`)
+	assert.Equal(t, 400, code)
+	assert.ElementsMatch(t, []api.FieldError{
+		{Field: "title", Rule: "max", Param: "256", Message: "title should be 256 characters or less"},
+		{Field: "tomato_num", Rule: "min", Param: "0", Message: "tomato_num should be at least 0"},
+	}, failure.Errors)
+
+	code, failure = request("PUT", path, `{"title": "five", "tomato_num": "many"}`)
+	assert.Equal(t, 400, code)
+	require.Equal(t, 1, len(failure.Errors))
+	assert.Equal(t, "tomato_num", failure.Errors[0].Field)
+	assert.Equal(t, "type", failure.Errors[0].Rule)
+	assert.Equal(t, "number", failure.Errors[0].Param)
+
+	// Errors of binding are not ignored.
+	code, failure = request("PUT", path, `{"title": `)
+	assert.Equal(t, 400, code)
+	assert.Empty(t, failure.Errors)
+
+	code, _ = request("GET", "blue/2019-04-01", "")
+	require.Equal(t, 200, code)
+	code, failure = request("PUT", "blue/2019-04-01", `{"Status": "closed", "notes": {"summary": "`+strings.Repeat("x", 10001)+`"}}`)
+	assert.Equal(t, 400, code)
+	require.Equal(t, 2, len(failure.Errors))
+	assert.ElementsMatch(t, []string{"Status/report_status", "notes.summary/max"}, []string{
+		failure.Errors[0].Field + "/" + failure.Errors[0].Rule,
+		failure.Errors[1].Field + "/" + failure.Errors[1].Rule,
+	})
+
+	code, failure = request("GET", "blue/tasks?begin=2019-04-02&end=2019-04-01", "")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, []api.FieldError{{Field: "end", Rule: "gtefield", Param: "begin", Message: "end should not be before begin"}}, failure.Errors)
+
+	code, failure = request("GET", "blue/stats?begin=2019-01-01&end=2021-01-01", "")
+	assert.Equal(t, 400, code)
+	require.Equal(t, 1, len(failure.Errors))
+	assert.Equal(t, "max_span", failure.Errors[0].Rule)
+	assert.Equal(t, "366", failure.Errors[0].Param)
+
+	code, failure = request("GET", "blue?end=2019-04-01", "")
+	assert.Equal(t, 400, code)
+	require.Equal(t, 1, len(failure.Errors))
+	assert.Equal(t, "begin", failure.Errors[0].Field)
+	assert.Equal(t, "required", failure.Errors[0].Rule)
+
+	var pomodoro struct {
+		Results api.Pomodoro `json:"results"`
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/"+strings.Replace(path, "/task/", "/pomodoro/", 1), nil))
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pomodoro))
+	pomodoroPath := strings.Replace(path, "/task/", "/pomodoro/", 1) + "/" + pomodoro.Results.PomodoroID
+
+	var team struct {
+		Results api.Team `json:"results"`
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/blue/teams", strings.NewReader(`{"name": "fruits"}`)))
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &team))
+
+	for _, tc := range []struct {
+		method, path, body string
+		field, rule        string
+	}{
+		{"PUT", "blue/settings/pomodoro", `{"work_minutes": 181}`, "work_minutes", "max"},
+		{"PUT", "blue/settings/pomodoro", `{"long_break_interval": 0}`, "long_break_interval", "min"},
+		{"POST", "blue/report-templates", `{"name": "daily", "body": "{{"}`, "body", "template"},
+		{"POST", "blue/webhooks", `{"url": "ftp://example.com", "events": ["*"]}`, "url", "webhook_url"},
+		{"POST", "blue/webhooks", `{"url": "https://example.com", "events": []}`, "events", "min"},
+		{"POST", "blue/webhooks", `{"url": "https://example.com", "events": ["task.eaten"]}`, "events[0]", "webhook_event"},
+		{"POST", "blue/tokens", `{"name": "ci"}`, "scopes", "required"},
+		{"POST", "blue/tokens", `{"scopes": ["kitchen:eat"]}`, "scopes[0]", "scope"},
+		{"POST", "blue/tokens", `{"scopes": ["tasks:write"], "expires_at": "2019-04-01T00:00:00Z"}`, "expires_at", "gt"},
+		{"PUT", "blue/teams/" + team.Results.TeamID + "/members/green", `{"role": "boss"}`, "role", "team_role"},
+		{"GET", "blue/2019-04-01/task?status=eaten", "", "status", "task_status"},
+		{"PUT", pomodoroPath, `{"status": "eaten"}`, "status", "oneof"},
+		{"POST", pomodoroPath + "/interruptions", `{"type": "alien"}`, "type", "oneof"},
+		{"POST", pomodoroPath + "/interruptions", `{"type": "external", "note": "` + strings.Repeat("x", 1001) + `"}`, "note", "max"},
+		{"GET", "blue/search?q=five&limit=many", "", "limit", "type"},
+		{"GET", "blue/search?q=five&limit=1000", "", "limit", "max"},
+	} {
+		code, failure = request(tc.method, tc.path, tc.body)
+		assert.Equal(t, 400, code, tc.path+" "+tc.body)
+		require.Equal(t, 1, len(failure.Errors), tc.path+" "+tc.body)
+		assert.Equal(t, tc.field, failure.Errors[0].Field, tc.path+" "+tc.body)
+		assert.Equal(t, tc.rule, failure.Errors[0].Rule, tc.path+" "+tc.body)
+	}
+
+	// A rejected POST does not create an item.
+	long := `{"title": "` + strings.Repeat("x", 257) + `"}`
+	code, _ = request("POST", "blue/2019-04-01/task", long)
+	assert.Equal(t, 400, code)
+	code, _ = request("POST", "blue/2019-04-01/chore", long)
+	assert.Equal(t, 400, code)
+
+	var tasks struct {
+		Results []api.Task `json:"results"`
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/blue/2019-04-01/task", nil))
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tasks))
+	require.Equal(t, 1, len(tasks.Results))
+	assert.Equal(t, task.Results.TaskID, tasks.Results[0].TaskID)
+
+	var chores struct {
+		Results []api.Chore `json:"results"`
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/blue/2019-04-01/chore", nil))
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &chores))
+	assert.Equal(t, 0, len(chores.Results))
+}
+
+func TestProblemResponse(t *testing.T) {
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{})
+
+	request := func(method, path string, header map[string]string, body string) (*httptest.ResponseRecorder, api.Problem) {
+		req := httptest.NewRequest(method, "/api/v1/"+path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var problem api.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		return w, problem
+	}
+
+	w, problem := request("DELETE", "blue/2019-04-01/task/nothing", nil, "")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "urn:task-kitchen:problem:not-found", problem.Type)
+	assert.Equal(t, 404, problem.Status)
+	assert.NotEmpty(t, problem.Title)
+	assert.Equal(t, "Task not found: nothing", problem.Detail)
+	assert.Equal(t, "/api/v1/blue/2019-04-01/task/nothing", problem.Instance)
+	assert.NotEmpty(t, problem.RequestID)
+
+	w, _ = request("POST", "blue/2019-04-01/task", nil, "")
+	require.Equal(t, 200, w.Code)
+	var created struct {
+		Results api.Task `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	path := "blue/2019-04-01/task/" + created.Results.TaskID
+
+	w, problem = request("PUT", path, nil, `{"title": 1}`)
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "urn:task-kitchen:problem:validation", problem.Type)
+	require.Equal(t, 1, len(problem.Errors))
+	assert.Equal(t, "title", problem.Errors[0].Field)
+
+	// A status out of the catalog has no type.
+	w, problem = request("DELETE", path, map[string]string{"If-Match": `"0"`}, "")
+	assert.Equal(t, 412, w.Code)
+	assert.Equal(t, "about:blank", problem.Type)
+	assert.Equal(t, "Precondition Failed", problem.Title)
+	assert.NotNil(t, problem.Current)
+}
+
+func TestCORS(t *testing.T) {
+	preflight := func(origins []string, origin string) http.Header {
+		r := gin.New()
+		r.Use(api.CORS(origins))
+		api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{})
+
+		req := httptest.NewRequest("OPTIONS", "/api/v1/blue/2019-04-01", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, 204, w.Code)
+		return w.Header()
+	}
+
+	h := preflight([]string{"http://localhost:8080"}, "http://localhost:8080")
+	assert.Equal(t, "http://localhost:8080", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", h.Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, h.Get("Access-Control-Allow-Headers"), "X-API-Key")
+
+	// Any origin is allowed without credentials.
+	h = preflight([]string{"*"}, "http://example.com")
+	assert.Equal(t, "*", h.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, h.Get("Access-Control-Allow-Credentials"))
+}
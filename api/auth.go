@@ -0,0 +1,293 @@
+package api
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// Scopes of API access.
+const (
+	// ScopeRead allows all GET requests.
+	ScopeRead = "read"
+	// ScopeWrite allows all write requests.
+	ScopeWrite = "write"
+
+	ScopeReportsWrite   = "reports:write"
+	ScopeTasksWrite     = "tasks:write"
+	ScopeChoresWrite    = "chores:write"
+	ScopePomodorosWrite = "pomodoros:write"
+	ScopeTokensWrite    = "tokens:write"
+	ScopeTeamsWrite     = "teams:write"
+	ScopeWebhooksWrite  = "webhooks:write"
+)
+
+var knownScopes = map[string]bool{
+	ScopeRead:           true,
+	ScopeWrite:          true,
+	ScopeReportsWrite:   true,
+	ScopeTasksWrite:     true,
+	ScopeChoresWrite:    true,
+	ScopePomodorosWrite: true,
+	ScopeTokensWrite:    true,
+	ScopeTeamsWrite:     true,
+	ScopeWebhooksWrite:  true,
+}
+
+// Principal is an authenticated client of API.
+type Principal struct {
+	UserID string
+	// Grants is a list of other users whose space the principal can access.
+	// "*" grants access to all users.
+	Grants []string
+	// Scopes restricts allowed operations. nil means no restriction.
+	Scopes []string
+
+	// token is set if authenticated by a personal token.
+	token *Token
+}
+
+// HasScope returns true if the principal is allowed the scope. ScopeWrite
+// covers all other write scopes.
+func (x *Principal) HasScope(scope string) bool {
+	if x.Scopes == nil {
+		return true
+	}
+
+	for _, s := range x.Scopes {
+		if s == scope || (s == ScopeWrite && strings.HasSuffix(scope, ":write")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccess returns true if the principal is allowed to access /:user space.
+func (x *Principal) CanAccess(user string) bool {
+	if x.UserID == user {
+		return true
+	}
+
+	for _, grant := range x.Grants {
+		if grant == user || grant == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticator resolves a principal from credentials of a request.
+type Authenticator interface {
+	// Authenticate returns nil principal and nil error if the request has no
+	// credential for the authenticator. It returns error if the credential is
+	// invalid.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// --------------------------------
+// JWT bearer token
+// --------------------------------
+
+type jwtClaims struct {
+	Grants []string `json:"grants,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type jwtAuthenticator struct {
+	method jwt.SigningMethod
+	key    interface{}
+	issuer string
+}
+
+// NewHMACAuthenticator creates an Authenticator of HS256 signed JWT. The user
+// is "sub" claim and additional users can be granted by "grants" claim. "iss"
+// claim is checked if issuer is not empty.
+func NewHMACAuthenticator(secret []byte, issuer string) Authenticator {
+	return &jwtAuthenticator{method: jwt.SigningMethodHS256, key: secret, issuer: issuer}
+}
+
+// NewRSAAuthenticator creates an Authenticator of RS256 signed JWT. Claims are
+// same as NewHMACAuthenticator.
+func NewRSAAuthenticator(key *rsa.PublicKey, issuer string) Authenticator {
+	return &jwtAuthenticator{method: jwt.SigningMethodRS256, key: key, issuer: issuer}
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	hdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(hdr, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(hdr[len(prefix):])
+}
+
+func (x *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	var claims jwtClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{x.method.Alg()}))
+	if _, err := parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return x.key, nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "Invalid bearer token")
+	}
+
+	if x.issuer != "" && !claims.VerifyIssuer(x.issuer, true) {
+		return nil, errors.Errorf("Invalid issuer of bearer token: '%s'", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("Bearer token has no sub claim")
+	}
+
+	return &Principal{UserID: claims.Subject, Grants: claims.Grants}, nil
+}
+
+// --------------------------------
+// API key
+// --------------------------------
+
+const apiKeyHeader = "X-API-Key"
+
+// HashAPIKey returns hex encoded SHA-256 digest of an API key. Only digests
+// are kept on server side.
+func HashAPIKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+type apiKeyAuthenticator struct {
+	keys map[string]Principal
+}
+
+// NewAPIKeyAuthenticator creates an Authenticator of X-API-Key header. keys
+// is a map of HashAPIKey digest to principal.
+func NewAPIKeyAuthenticator(keys map[string]Principal) Authenticator {
+	return &apiKeyAuthenticator{keys: keys}
+}
+
+func (x *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+
+	principal, ok := x.keys[HashAPIKey(key)]
+	if !ok {
+		return nil, errors.New("Invalid API key")
+	}
+
+	return &principal, nil
+}
+
+// --------------------------------
+// Middleware
+// --------------------------------
+
+const ctxPrincipal = "principal"
+
+// getPrincipal returns authenticated principal, or nil if authentication is
+// disabled.
+func getPrincipal(c *gin.Context) *Principal {
+	if v, ok := c.Get(ctxPrincipal); ok {
+		return v.(*Principal)
+	}
+	return nil
+}
+
+func authenticate(c *gin.Context, authenticators []Authenticator) (*Principal, error) {
+	for _, authn := range authenticators {
+		principal, err := authn.Authenticate(c.Request)
+		if err != nil {
+			return nil, newUserError(401, "Invalid credential").setCause(err)
+		}
+		if principal != nil {
+			return principal, nil
+		}
+	}
+
+	return nil, newUserError(401, "Authentication required")
+}
+
+// teamRoutes is a set of route patterns that teammates of the user can read.
+type teamRoutes map[string]bool
+
+// share registers relativePath of the group as readable by teammates and
+// returns relativePath as is.
+func (x teamRoutes) share(r *gin.RouterGroup, relativePath string) string {
+	x[path.Join(r.BasePath(), relativePath)] = true
+	return relativePath
+}
+
+// allowTeammate returns true if the principal can read the space of user as a
+// teammate. Only GET requests of shared routes are allowed.
+func allowTeammate(c *gin.Context, mgr *KitchenManager, shared teamRoutes, principal *Principal, user string) (bool, error) {
+	if c.Request.Method != http.MethodGet || !shared[c.FullPath()] {
+		return false, nil
+	}
+
+	return mgr.sharesTeam(principal.UserID, user)
+}
+
+func authMiddleware(authenticators []Authenticator, mgr *KitchenManager, shared teamRoutes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticate(c, authenticators)
+		if err != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortWithError(c, err)
+			return
+		}
+
+		user, err := getUser(c.Params)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		if !principal.CanAccess(user) {
+			ok, err := allowTeammate(c, mgr, shared, principal, user)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			if !ok {
+				abortWithError(c, newUserError(403, "Access to %s is not allowed", user))
+				return
+			}
+		}
+
+		c.Set(ctxPrincipal, principal)
+		c.Next()
+	}
+}
+
+// readOnlyAccess returns true if the principal reads the space of user only as
+// a teammate. Handlers must not create items of the space in that case.
+func readOnlyAccess(c *gin.Context, user string) bool {
+	principal := getPrincipal(c)
+	return principal != nil && !principal.CanAccess(user)
+}
+
+// requireScope is a middleware to check scope of the principal. It passes
+// all requests if authentication is disabled.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal := getPrincipal(c); principal != nil && !principal.HasScope(scope) {
+			abortWithError(c, newUserError(403, "Scope '%s' is required", scope))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,70 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m-mizutani/task-kitchen/api"
+)
+
+func signTestToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return token
+}
+
+func TestAuthentication(t *testing.T) {
+	const secret = "test-secret"
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{
+		Authenticators: []api.Authenticator{
+			api.NewHMACAuthenticator([]byte(secret), "test-issuer"),
+			api.NewAPIKeyAuthenticator(map[string]api.Principal{
+				api.HashAPIKey("blue-key"): {UserID: "blue"},
+			}),
+		},
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	request := func(path string, hdr map[string]string) int {
+		req := httptest.NewRequest("GET", "/api/v1/"+path, nil)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	bearer := func(claims jwt.MapClaims) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + signTestToken(t, secret, claims)}
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("orange/2019-04-01", nil))
+
+	orange := bearer(jwt.MapClaims{"sub": "orange", "iss": "test-issuer", "exp": exp})
+	assert.Equal(t, http.StatusOK, request("orange/2019-04-01", orange))
+	assert.Equal(t, http.StatusForbidden, request("blue/2019-04-01", orange))
+
+	granted := bearer(jwt.MapClaims{"sub": "orange", "iss": "test-issuer", "exp": exp, "grants": []string{"blue"}})
+	assert.Equal(t, http.StatusOK, request("blue/2019-04-01", granted))
+
+	expired := bearer(jwt.MapClaims{"sub": "orange", "iss": "test-issuer", "exp": time.Now().Add(-time.Hour).Unix()})
+	assert.Equal(t, http.StatusUnauthorized, request("orange/2019-04-01", expired))
+
+	wrongIssuer := bearer(jwt.MapClaims{"sub": "orange", "iss": "other", "exp": exp})
+	assert.Equal(t, http.StatusUnauthorized, request("orange/2019-04-01", wrongIssuer))
+
+	forged := map[string]string{"Authorization": "Bearer " + signTestToken(t, "wrong", jwt.MapClaims{"sub": "orange", "iss": "test-issuer"})}
+	assert.Equal(t, http.StatusUnauthorized, request("orange/2019-04-01", forged))
+
+	assert.Equal(t, http.StatusOK, request("blue/2019-04-01/task", map[string]string{"X-API-Key": "blue-key"}))
+	assert.Equal(t, http.StatusForbidden, request("orange/2019-04-01/task", map[string]string{"X-API-Key": "blue-key"}))
+	assert.Equal(t, http.StatusUnauthorized, request("blue/2019-04-01/task", map[string]string{"X-API-Key": "bad-key"}))
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type BreakKind string
+
+const (
+	ShortBreak BreakKind = "short"
+	LongBreak  BreakKind = "long"
+)
+
+// Break is a rest between pomodoros. It is stored in the same partition as
+// pomodoros of the day.
+type Break struct {
+	PKey    string    `dynamo:"pk" json:"-"`
+	SKey    string    `dynamo:"sk" json:"-"`
+	UserID  string    `dynamo:"user_id" json:"user_id"`
+	BreakID string    `dynamo:"break_id" json:"break_id"`
+	Kind    BreakKind `dynamo:"kind" json:"kind"`
+
+	Status     string    `dynamo:"status" json:"status"`
+	StartedAt  time.Time `dynamo:"started_at" json:"started_at"`
+	FinishedAt time.Time `dynamo:"finished_at" json:"finished_at"`
+	Deadline   time.Time `dynamo:"deadline" json:"deadline"`
+
+	store Store
+	// expired is true if the break has been finished by expire and the
+	// change is not saved yet.
+	expired bool
+}
+
+const breakKeyPrefix = "break/"
+
+func toBreakKey(userID string, date time.Time, breakID string) (string, string) {
+	pk, _ := toPomodoroKey(userID, date, "", "")
+	sk := breakKeyPrefix + breakID
+	return pk, sk
+}
+
+func (x KitchenManager) StartBreak(userID string, date time.Time, kind BreakKind, duration time.Duration) (*Break, error) {
+	if kind != ShortBreak && kind != LongBreak {
+		return nil, newUserError(400, "Invalid break kind: '%s'", kind)
+	}
+
+	b := Break{
+		UserID:    userID,
+		BreakID:   strings.Replace(uuid.New().String(), "-", "", -1),
+		Kind:      kind,
+		Status:    PomodoroStarted,
+		StartedAt: time.Now().UTC(),
+		store:     x.store,
+	}
+	b.Deadline = b.StartedAt.Add(duration)
+	b.PKey, b.SKey = toBreakKey(userID, date, b.BreakID)
+
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (x KitchenManager) GetBreak(userID string, date time.Time, breakID string) (*Break, error) {
+	var b Break
+	pk, sk := toBreakKey(userID, date, breakID)
+
+	if err := x.store.Get(pk, sk, &b); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Break not found: %s", breakID)
+		}
+		return nil, errors.Wrapf(err, "Fail to get break: %s %s", pk, sk)
+	}
+
+	b.store = x.store
+	b.expire(time.Now().UTC())
+	return &b, nil
+}
+
+func (x KitchenManager) FetchBreaks(userID string, date time.Time) ([]Break, error) {
+	var breaks []Break
+	pk, _ := toBreakKey(userID, date, "")
+
+	if err := x.store.Query(pk, SortKeyBeginsWith(breakKeyPrefix), &breaks); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch breaks: %s", pk)
+	}
+
+	now := time.Now().UTC()
+	for i := range breaks {
+		breaks[i].store = x.store
+		breaks[i].expire(now)
+	}
+	return breaks, nil
+}
+
+// expire finishes the break at the deadline if it is overdue. The change is
+// not saved. Breaks are not finished by the sweeper.
+func (x *Break) expire(now time.Time) {
+	if x.Status == PomodoroStarted && !now.Before(x.Deadline) {
+		x.Status = PomodoroFinished
+		x.FinishedAt = x.Deadline
+		x.expired = true
+	}
+}
+
+func (x *Break) save() error {
+	if err := x.store.Put(x); err != nil {
+		return errors.Wrapf(err, "Fail to save break: %s %s", x.PKey, x.SKey)
+	}
+
+	return nil
+}
+
+func (x *Break) Finish() error {
+	// An overdue break finishes at the deadline.
+	now := time.Now().UTC()
+	x.expire(now)
+	if x.Status == PomodoroStarted {
+		x.Status = PomodoroFinished
+		x.FinishedAt = now
+	} else if !x.expired {
+		return newUserError(409, "Break is not running: %s", x.Status)
+	}
+
+	if err := x.save(); err != nil {
+		return err
+	}
+	x.expired = false
+	return nil
+}
+
+func (x *Break) Delete() error {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
+		return errors.Wrapf(err, "Fail to delete break: %s %s", x.PKey, x.SKey)
+	}
+
+	return nil
+}
+
+// --------------------------------
+// Cycle
+// --------------------------------
+
+type PomodoroStep string
+
+const (
+	StepWork       PomodoroStep = "work"
+	StepShortBreak PomodoroStep = "short_break"
+	StepLongBreak  PomodoroStep = "long_break"
+)
+
+// NextStep tells a client what to start next in the pomodoro cycle.
+type NextStep struct {
+	Step    PomodoroStep `json:"step"`
+	Minutes int          `json:"minutes"`
+	// InProgress is true if the step has been started and not finished. Deadline
+	// is set only in that case.
+	InProgress bool       `json:"in_progress"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	// CompletedWork is number of finished work sessions since the last long
+	// break.
+	CompletedWork int `json:"completed_work"`
+}
+
+type cycleEntry struct {
+	step      PomodoroStep
+	status    string
+	startedAt time.Time
+	deadline  time.Time
+}
+
+func breakStep(kind BreakKind) PomodoroStep {
+	if kind == LongBreak {
+		return StepLongBreak
+	}
+	return StepShortBreak
+}
+
+// NextStep returns the next step of the day by pomodoros, breaks and settings
+// of the user.
+func (x KitchenManager) NextStep(userID string, date time.Time) (*NextStep, error) {
+	settings, err := x.GetPomodoroSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	pomodoros, err := x.fetchAllPomodoros(userID, date)
+	if err != nil {
+		return nil, err
+	}
+	breaks, err := x.FetchBreaks(userID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []cycleEntry
+	for _, p := range pomodoros {
+		entries = append(entries, cycleEntry{StepWork, p.Status, p.StartedAt, p.deadline()})
+	}
+	for _, b := range breaks {
+		entries = append(entries, cycleEntry{breakStep(b.Kind), b.Status, b.StartedAt, b.Deadline})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].startedAt.Before(entries[j].startedAt)
+	})
+
+	next := NextStep{Step: StepWork}
+	var last *cycleEntry
+	for i, e := range entries {
+		switch {
+		case e.step == StepWork && e.status == PomodoroFinished:
+			next.CompletedWork++
+		case e.step == StepLongBreak:
+			next.CompletedWork = 0
+		}
+		if e.status == PomodoroStarted {
+			deadline := e.deadline
+			next.Step, next.InProgress, next.Deadline = e.step, true, &deadline
+		}
+		last = &entries[i]
+	}
+
+	if !next.InProgress && last != nil && last.step == StepWork && last.status == PomodoroFinished {
+		next.Step = StepShortBreak
+		if next.CompletedWork >= settings.LongBreakInterval {
+			next.Step = StepLongBreak
+		}
+	}
+
+	switch next.Step {
+	case StepWork:
+		next.Minutes = settings.WorkMinutes
+	case StepShortBreak:
+		next.Minutes = settings.ShortBreakMinutes
+	case StepLongBreak:
+		next.Minutes = settings.LongBreakMinutes
+	}
+
+	return &next, nil
+}
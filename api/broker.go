@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Broker fans out events to subscribers of event streams. Events of a day of a
+// user are published to a topic made by toStreamTopic. A Broker is given by
+// Options of SetupRouter, and events of changes by the router are published.
+//
+// Hub is a Broker for a single process. A deployment that runs multiple
+// processes, such as Lambda, needs a Broker that delivers events across
+// processes.
+type Broker interface {
+	// Publish sends the event to current subscribers of the topic. It should
+	// not block by slow subscribers.
+	Publish(topic string, event Event) error
+	// Subscribe starts receiving events of the topic. The subscription must be
+	// closed by the caller.
+	Subscribe(topic string) (Subscription, error)
+}
+
+// Subscription is a receiver of events of a topic.
+type Subscription interface {
+	// Events returns a channel of events. The channel is closed by Close.
+	Events() <-chan Event
+	Close() error
+}
+
+func toStreamTopic(userID string, date time.Time) string {
+	return fmt.Sprintf("%s/%s", userID, date.Format("20060102"))
+}
+
+// publishEvent sends the event to the broker if not nil. Failure of publishing
+// is not an error of the change because event streams are best effort.
+func publishEvent(broker Broker, topic string, event Event) {
+	if broker == nil {
+		return
+	}
+
+	if err := broker.Publish(topic, event); err != nil {
+		Logger.WithError(err).WithFields(logrus.Fields{
+			"topic": topic,
+			"event": event.Event,
+		}).Warn("Fail to publish event")
+	}
+}
+
+// --------------------------------
+// Hub
+// --------------------------------
+
+// hubBufferSize is number of events that a subscriber can hold. Events are
+// dropped for a subscriber whose buffer is full.
+const hubBufferSize = 16
+
+// Hub is an in-process Broker.
+type Hub struct {
+	mutex  sync.Mutex
+	topics map[string]map[*hubSubscription]struct{}
+}
+
+type hubSubscription struct {
+	hub    *Hub
+	topic  string
+	events chan Event
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{topics: map[string]map[*hubSubscription]struct{}{}}
+}
+
+func (x *Hub) Publish(topic string, event Event) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	for sub := range x.topics[topic] {
+		select {
+		case sub.events <- event:
+		default:
+			Logger.WithFields(logrus.Fields{
+				"topic": topic,
+				"event": event.Event,
+			}).Warn("Drop event for slow subscriber")
+		}
+	}
+
+	return nil
+}
+
+func (x *Hub) Subscribe(topic string) (Subscription, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	sub := &hubSubscription{
+		hub:    x,
+		topic:  topic,
+		events: make(chan Event, hubBufferSize),
+	}
+	if x.topics[topic] == nil {
+		x.topics[topic] = map[*hubSubscription]struct{}{}
+	}
+	x.topics[topic][sub] = struct{}{}
+
+	return sub, nil
+}
+
+func (x *hubSubscription) Events() <-chan Event {
+	return x.events
+}
+
+func (x *hubSubscription) Close() error {
+	x.hub.mutex.Lock()
+	defer x.hub.mutex.Unlock()
+
+	if x.closed {
+		return nil
+	}
+	x.closed = true
+
+	delete(x.hub.topics[x.topic], x)
+	if len(x.hub.topics[x.topic]) == 0 {
+		delete(x.hub.topics, x.topic)
+	}
+	close(x.events)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package api_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m-mizutani/task-kitchen/api"
+)
+
+func TestHub(t *testing.T) {
+	hub := api.NewHub()
+
+	sub1, err := hub.Subscribe("blue/20190401")
+	require.NoError(t, err)
+	sub2, err := hub.Subscribe("blue/20190402")
+	require.NoError(t, err)
+	defer sub2.Close()
+
+	require.NoError(t, hub.Publish("blue/20190401", api.Event{EventID: "1", Event: api.EventTaskSaved}))
+	ev := <-sub1.Events()
+	assert.Equal(t, "1", ev.EventID)
+	assert.Equal(t, 0, len(sub2.Events()))
+
+	// Events are dropped instead of blocking if not received.
+	for i := 0; i < 100; i++ {
+		require.NoError(t, hub.Publish("blue/20190401", api.Event{Event: api.EventTaskSaved}))
+	}
+
+	require.NoError(t, sub1.Close())
+	require.NoError(t, sub1.Close())
+	require.NoError(t, hub.Publish("blue/20190401", api.Event{Event: api.EventTaskSaved}))
+}
+
+func TestEventStream(t *testing.T) {
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{Broker: api.NewHub()})
+	server := httptest.NewServer(r)
+	defer server.Close()
+	uid := uuid.New().String()
+
+	resp, err := http.Get(server.URL + "/api/v1/" + uid + "/2019-04-01/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	post := func(date, title string) {
+		body, err := json.Marshal(map[string]string{"title": title})
+		require.NoError(t, err)
+		r, err := http.Post(server.URL+"/api/v1/"+uid+"/"+date+"/task", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		r.Body.Close()
+		require.Equal(t, 200, r.StatusCode)
+	}
+	// Events of another day are not streamed.
+	post("2019-04-02", "other")
+	post("2019-04-01", "five")
+
+	events := make(chan api.Event)
+	go func() {
+		defer close(events)
+		var name string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				name = line[len("event:"):]
+			case strings.HasPrefix(line, "data:"):
+				var ev api.Event
+				require.NoError(t, json.Unmarshal([]byte(line[len("data:"):]), &ev))
+				assert.Equal(t, name, ev.Event)
+				events <- ev
+			}
+		}
+	}()
+
+	var titles []string
+	timeout := time.After(5 * time.Second)
+	for len(titles) < 1 {
+		select {
+		case ev := <-events:
+			assert.Equal(t, api.EventTaskSaved, ev.Event)
+			assert.Equal(t, uid, ev.UserID)
+			var task api.Task
+			require.NoError(t, json.Unmarshal(ev.Data, &task))
+			titles = append(titles, task.Title)
+		case <-timeout:
+			require.Fail(t, "Events are not streamed")
+		}
+	}
+	assert.Equal(t, []string{"five"}, titles)
+}
+
+func TestEventStreamWithoutBroker(t *testing.T) {
+	uid := uuid.New().String()
+
+	code, err := httpRequest("GET", uid+"/2019-04-01/events", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 501, code)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CarryOverMode is how unfinished tasks are carried over to another date.
+type CarryOverMode string
+
+const (
+	// CarryOverCopy creates new tasks and keeps the original tasks.
+	CarryOverCopy CarryOverMode = "copy"
+	// CarryOverMove creates new tasks and deletes the original tasks.
+	CarryOverMove CarryOverMode = "move"
+)
+
+// IsValidCarryOverMode returns true if mode is copy or move.
+func IsValidCarryOverMode(mode string) bool {
+	return mode == string(CarryOverCopy) || mode == string(CarryOverMove)
+}
+
+func (x *Task) unfinished() bool {
+	return x.Status == TaskTodo || x.Status == TaskInProgress
+}
+
+// CarryOverTasks creates tasks on date "to" from unfinished tasks on date
+// "from". A new task links to the original task even if the original is
+// deleted by CarryOverMove. Tasks already carried to the date are skipped.
+func (x KitchenManager) CarryOverTasks(userID string, from, to time.Time, mode CarryOverMode) ([]Task, error) {
+	if mode != CarryOverCopy && mode != CarryOverMove {
+		return nil, newUserError(400, "Invalid carry over mode: '%s'", mode)
+	}
+	if from.Equal(to) {
+		return nil, newUserError(400, "Can not carry over tasks to the same date")
+	}
+
+	tasks, err := x.FetchTasks(userID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := x.FetchTasks(userID, to)
+	if err != nil {
+		return nil, err
+	}
+	carried := map[string]bool{}
+	for _, task := range existing {
+		carried[task.CarriedFromTaskID] = true
+	}
+
+	created := []Task{}
+	for _, src := range tasks {
+		if !src.unfinished() || carried[src.TaskID] {
+			continue
+		}
+
+		task, err := x.NewTask(userID, to)
+		if err != nil {
+			return nil, err
+		}
+		task.Title = src.Title
+		task.TomatoNum = src.TomatoNum
+		task.Description = src.Description
+		task.CarriedFromDate = from.Format("2006-01-02")
+		task.CarriedFromTaskID = src.TaskID
+
+		if err := task.Save(); err != nil {
+			return nil, err
+		}
+
+		if mode == CarryOverMove {
+			if err := src.Delete(); err != nil {
+				return nil, err
+			}
+		}
+
+		created = append(created, *task)
+	}
+
+	return created, nil
+}
+
+// maxTaskHistory limits length of history to stop at broken links.
+const maxTaskHistory = 366
+
+// TaskHistory returns the task and tasks it was carried from, oldest first. It
+// stops at a task deleted by CarryOverMove.
+func (x KitchenManager) TaskHistory(task *Task) ([]Task, error) {
+	history := []Task{*task}
+
+	for cur := task; cur.CarriedFromTaskID != "" && len(history) < maxTaskHistory; {
+		date, err := time.Parse("2006-01-02", cur.CarriedFromDate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid carried_from_date of task: %s", cur.TaskID)
+		}
+
+		prev, err := x.GetTask(task.UserID, date, cur.CarriedFromTaskID)
+		if errors.Is(err, ErrNotFound) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		history = append([]Task{*prev}, history...)
+		cur = prev
+	}
+
+	return history, nil
+}
@@ -1,12 +1,12 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/guregu/dynamo"
 	"github.com/pkg/errors"
 )
 
@@ -16,11 +16,17 @@ type Chore struct {
 	UserID      string    `dynamo:"user_id" json:"user_id"`
 	ChoreID     string    `dynamo:"chore_id" json:"chore_id"`
 	CreatedAt   time.Time `dynamo:"created_at" json:"created_at"`
-	Title       string    `dynamo:"title" json:"title"`
+	Title       string    `dynamo:"title" json:"title" binding:"max=256"`
 	Done        bool      `dynamo:"done" json:"done"`
-	Description string    `dynamo:"description" json:"description"`
+	Description string    `dynamo:"description" json:"description" binding:"max=10000"`
+	// TemplateID is set if the chore is created from a ChoreTemplate.
+	TemplateID string `dynamo:"template_id,omitempty" json:"template_id,omitempty"`
+	Version    int    `dynamo:"version" json:"version"`
 
-	table   dynamo.Table
+	indexKeys
+
+	store   Store
+	broker  Broker
 	deleted bool
 }
 
@@ -31,34 +37,41 @@ func toChoreKey(userID string, date time.Time, choreID string) (string, string)
 }
 
 func (x KitchenManager) NewChore(userID string, date time.Time) (*Chore, error) {
+	chore := x.newChore(userID, date)
+	if err := chore.Save(); err != nil {
+		return nil, err
+	}
+
+	return chore, nil
+}
+
+// newChore builds a chore of the date without saving it.
+func (x KitchenManager) newChore(userID string, date time.Time) *Chore {
 	chore := Chore{
 		UserID:    userID,
 		ChoreID:   strings.Replace(uuid.New().String(), "-", "", -1),
 		CreatedAt: date,
-		table:     x.table,
+		store:     x.store,
+		broker:    x.broker,
 	}
 
 	chore.PKey, chore.SKey = toChoreKey(chore.UserID, chore.CreatedAt, chore.ChoreID)
-	if err := chore.Save(); err != nil {
-		return nil, err
-	}
-
-	return &chore, nil
+	return &chore
 }
 
 func (x KitchenManager) GetChore(userID string, date time.Time, ChoreID string) (*Chore, error) {
 	var Chore Chore
 	pk, sk := toChoreKey(userID, date, ChoreID)
 
-	if err := x.table.Get("pk", pk).Range("sk", dynamo.Equal, sk).One(&Chore); err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, newUserError(404, "The item is not found")
+	if err := x.store.Get(pk, sk, &Chore); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Chore not found: %s", ChoreID)
 		}
 
 		return nil, errors.Wrap(err, "Fail to get Chore")
 	}
 
-	Chore.table = x.table
+	Chore.store, Chore.broker = x.store, x.broker
 
 	return &Chore, nil
 }
@@ -67,11 +80,7 @@ func (x KitchenManager) FetchChores(userID string, date time.Time) ([]Chore, err
 	var chores []Chore
 	pk, _ := toChoreKey(userID, date, "")
 
-	if err := x.table.Get("pk", pk).All(&chores); err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
-		}
-
+	if err := x.store.Query(pk, AnySortKey(), &chores); err != nil {
 		return nil, errors.Wrap(err, "Fail to get chore")
 	}
 
@@ -79,18 +88,111 @@ func (x KitchenManager) FetchChores(userID string, date time.Time) ([]Chore, err
 }
 
 func (x *Chore) Save() error {
-	if err := x.table.Put(x).Run(); err != nil {
+	if err := validateStruct(x); err != nil {
+		return err
+	}
+
+	x.indexKeys = newIndexKeys(indexKindChore, x.UserID, x.CreatedAt, x.ChoreID)
+	if err := putVersioned(x.store, x, &x.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			var current Chore
+			return conflictError(x.store, "Chore", x.PKey, x.SKey, &current)
+		}
 		return errors.Wrapf(err, "Fail to save chore: %s", x.PKey)
 	}
 
+	return x.afterSave()
+}
+
+// Patch validates and applies fields changed by a patch. Only the fields are
+// written. version is checked unless it is anyVersion.
+func (x *Chore) Patch(fields map[string]json.RawMessage, version int) error {
+	next := *x
+	set := map[string]interface{}{}
+
+	for name, raw := range fields {
+		switch name {
+		case "title":
+			if err := decodePatchField(name, raw, &next.Title); err != nil {
+				return err
+			}
+			if next.Title == "" {
+				return newFieldError(name, "required", "", "title of chore is required")
+			}
+			set["title"] = next.Title
+
+		case "done":
+			if err := decodePatchField(name, raw, &next.Done); err != nil {
+				return err
+			}
+			set["done"] = next.Done
+
+		case "description":
+			if err := decodePatchField(name, raw, &next.Description); err != nil {
+				return err
+			}
+			set["description"] = next.Description
+
+		default:
+			return unchangeableFieldError(name)
+		}
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+	if err := validateStruct(&next); err != nil {
+		return err
+	}
+
+	var updated Chore
+	if err := x.store.Update(x.PKey, x.SKey, set, version, &updated); err != nil {
+		switch {
+		case errors.Is(err, errItemNotFound):
+			return newNotFoundError("Chore not found: %s", x.ChoreID)
+		case errors.Is(err, errVersionConflict):
+			var current Chore
+			return conflictError(x.store, "Chore", x.PKey, x.SKey, &current)
+		}
+		return errors.Wrapf(err, "Fail to update chore: %s", x.PKey)
+	}
+
+	updated.store, updated.broker = x.store, x.broker
+	*x = updated
+	return x.afterSave()
+}
+
+func (x *Chore) afterSave() error {
+	if err := searchIndexOf(x.store).IndexDocument(x.searchDocument()); err != nil {
+		return err
+	}
+
+	emitEvent(x.store, x.broker, x.UserID, x.CreatedAt, EventChoreSaved, x)
 	return nil
 }
 
+func (x *Chore) searchDocument() SearchDocument {
+	return SearchDocument{
+		UserID:      x.UserID,
+		Kind:        SearchChore,
+		ID:          x.ChoreID,
+		Date:        x.CreatedAt,
+		Title:       x.Title,
+		Description: x.Description,
+	}
+}
+
 func (x *Chore) Delete() error {
-	if err := x.table.Delete("pk", x.PKey).Range("sk", x.SKey).Run(); err != nil {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
 		return errors.Wrapf(err, "Fail to delete chore: %s", x.PKey)
 	}
+	if err := searchIndexOf(x.store).RemoveDocument(x.UserID, SearchChore, x.ChoreID); err != nil {
+		return err
+	}
 
 	x.deleted = true
+	emitEvent(x.store, x.broker, x.UserID, x.CreatedAt, EventChoreDeleted, x)
+	// The chore can be saved again as a new item.
+	x.Version = 0
 	return nil
 }
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/teambition/rrule-go"
+)
+
+type RecurrenceType string
+
+const (
+	// RecurDaily repeats every day.
+	RecurDaily RecurrenceType = "daily"
+	// RecurWeekdays repeats from Monday to Friday.
+	RecurWeekdays RecurrenceType = "weekdays"
+	// RecurWeekly repeats on Recurrence.Weekdays.
+	RecurWeekly RecurrenceType = "weekly"
+	// RecurMonthly repeats on Recurrence.MonthDay.
+	RecurMonthly RecurrenceType = "monthly"
+	// RecurRRule repeats by RFC 5545 Recurrence.RRule.
+	RecurRRule RecurrenceType = "rrule"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Recurrence is a schedule rule of chore template.
+type Recurrence struct {
+	Type RecurrenceType `dynamo:"type" json:"type"`
+	// Weekdays is such as ["mon", "thu"] for RecurWeekly.
+	Weekdays []string `dynamo:"weekdays,omitempty" json:"weekdays,omitempty"`
+	// MonthDay is 1 to 31, or -1 for the last day of month for RecurMonthly.
+	// The chore is skipped in months that do not have the day.
+	MonthDay int `dynamo:"month_day,omitempty" json:"month_day,omitempty"`
+	// RRule is such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO" for RecurRRule. Start
+	// date of the template is DTSTART.
+	RRule string `dynamo:"rrule,omitempty" json:"rrule,omitempty"`
+}
+
+func (x Recurrence) validate() error {
+	switch x.Type {
+	case RecurDaily, RecurWeekdays:
+	case RecurWeekly:
+		if len(x.Weekdays) == 0 {
+			return newUserError(400, "weekdays is required for weekly recurrence")
+		}
+		for _, w := range x.Weekdays {
+			if _, ok := weekdayNames[w]; !ok {
+				return newUserError(400, "Invalid weekday: '%s', should be one of sun, mon, tue, wed, thu, fri and sat", w)
+			}
+		}
+	case RecurMonthly:
+		if x.MonthDay != -1 && (x.MonthDay < 1 || 31 < x.MonthDay) {
+			return newUserError(400, "month_day should be 1 to 31 or -1, got %d", x.MonthDay)
+		}
+	case RecurRRule:
+		if _, err := x.rrule(time.Now().UTC()); err != nil {
+			return newUserError(400, "Invalid rrule: '%s'", x.RRule).setCause(err)
+		}
+	default:
+		return newUserError(400, "Invalid recurrence type: '%s'", x.Type)
+	}
+
+	return nil
+}
+
+func (x Recurrence) rrule(start time.Time) (*rrule.RRule, error) {
+	opt, err := rrule.StrToROption(x.RRule)
+	if err != nil {
+		return nil, err
+	}
+	opt.Dtstart = start
+
+	return rrule.NewRRule(*opt)
+}
+
+// Match returns true if the chore should be done on date. start is the first
+// date of the schedule.
+func (x Recurrence) Match(date, start time.Time) bool {
+	if date.Before(start) {
+		return false
+	}
+
+	switch x.Type {
+	case RecurDaily:
+		return true
+
+	case RecurWeekdays:
+		return date.Weekday() != time.Saturday && date.Weekday() != time.Sunday
+
+	case RecurWeekly:
+		for _, w := range x.Weekdays {
+			if weekdayNames[w] == date.Weekday() {
+				return true
+			}
+		}
+		return false
+
+	case RecurMonthly:
+		if x.MonthDay == -1 {
+			return date.AddDate(0, 0, 1).Day() == 1
+		}
+		return date.Day() == x.MonthDay
+
+	case RecurRRule:
+		r, err := x.rrule(start)
+		if err != nil {
+			Logger.WithError(err).WithField("rrule", x.RRule).Warn("Invalid rrule of chore template")
+			return false
+		}
+		next := r.After(date, true)
+		return !next.IsZero() && next.Before(date.AddDate(0, 0, 1))
+	}
+
+	return false
+}
+
+// ChoreTemplate creates chores automatically on dates that match Recurrence.
+type ChoreTemplate struct {
+	PKey        string     `dynamo:"pk" json:"-"`
+	SKey        string     `dynamo:"sk" json:"-"`
+	UserID      string     `dynamo:"user_id" json:"user_id"`
+	TemplateID  string     `dynamo:"template_id" json:"template_id"`
+	CreatedAt   time.Time  `dynamo:"created_at" json:"created_at"`
+	Title       string     `dynamo:"title" json:"title"`
+	Description string     `dynamo:"description" json:"description"`
+	Recurrence  Recurrence `dynamo:"recurrence" json:"recurrence"`
+	// StartDate is such as 2006-01-02. Chores are not created before the date.
+	StartDate string `dynamo:"start_date" json:"start_date"`
+
+	store Store
+}
+
+// choreSchedule records that chores of the date have been created from
+// templates, so that deleted chores do not come back.
+type choreSchedule struct {
+	PKey      string    `dynamo:"pk"`
+	SKey      string    `dynamo:"sk"`
+	CreatedAt time.Time `dynamo:"created_at"`
+}
+
+func toChoreTemplateKey(userID, templateID string) (string, string) {
+	pk := fmt.Sprintf("%s/chore_template", userID)
+	sk := templateID
+	return pk, sk
+}
+
+func toChoreScheduleKey(userID string, date time.Time) (string, string) {
+	pk := fmt.Sprintf("%s/chore_schedule", userID)
+	sk := date.Format("20060102")
+	return pk, sk
+}
+
+// toTemplatedChoreID returns ID of the chore of the date created from the
+// template. The ID is unique among dates because IDs of chores are looked up
+// across dates, and is fixed for the date to avoid duplicated chores by
+// concurrent requests.
+func toTemplatedChoreID(templateID string, date time.Time) string {
+	return templateID + "-" + date.Format("20060102")
+}
+
+func (x KitchenManager) NewChoreTemplate(userID string) *ChoreTemplate {
+	now := time.Now().UTC()
+	tmpl := ChoreTemplate{
+		UserID:     userID,
+		TemplateID: strings.Replace(uuid.New().String(), "-", "", -1),
+		CreatedAt:  now,
+		StartDate:  now.Format("2006-01-02"),
+		store:      x.store,
+	}
+	tmpl.PKey, tmpl.SKey = toChoreTemplateKey(tmpl.UserID, tmpl.TemplateID)
+
+	return &tmpl
+}
+
+func (x KitchenManager) GetChoreTemplate(userID, templateID string) (*ChoreTemplate, error) {
+	var tmpl ChoreTemplate
+	pk, sk := toChoreTemplateKey(userID, templateID)
+
+	if err := x.store.Get(pk, sk, &tmpl); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Chore template not found: %s", templateID)
+		}
+		return nil, errors.Wrapf(err, "Fail to get chore template: %s", templateID)
+	}
+
+	tmpl.store = x.store
+	return &tmpl, nil
+}
+
+func (x KitchenManager) FetchChoreTemplates(userID string) ([]ChoreTemplate, error) {
+	var templates []ChoreTemplate
+	pk, _ := toChoreTemplateKey(userID, "")
+
+	if err := x.store.Query(pk, AnySortKey(), &templates); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch chore templates: %s", pk)
+	}
+
+	return templates, nil
+}
+
+func (x *ChoreTemplate) start() (time.Time, error) {
+	return time.Parse("2006-01-02", x.StartDate)
+}
+
+func (x *ChoreTemplate) Save() error {
+	if x.Title == "" {
+		return newUserError(400, "Title of chore template is required")
+	}
+	if _, err := x.start(); err != nil {
+		return newUserError(400, "Invalid date format '%s', should be like 2006-01-02", x.StartDate).setCause(err)
+	}
+	if err := x.Recurrence.validate(); err != nil {
+		return err
+	}
+
+	if err := x.store.Put(x); err != nil {
+		return errors.Wrapf(err, "Fail to save chore template: %s", x.PKey)
+	}
+
+	return nil
+}
+
+func (x *ChoreTemplate) Delete() error {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
+		return errors.Wrapf(err, "Fail to delete chore template: %s", x.PKey)
+	}
+
+	return nil
+}
+
+// ScheduleChores creates chores of the date from templates at the first call
+// for the date. Later changes of templates do not affect the date.
+func (x KitchenManager) ScheduleChores(userID string, date time.Time) error {
+	var schedule choreSchedule
+	pk, sk := toChoreScheduleKey(userID, date)
+
+	if err := x.store.Get(pk, sk, &schedule); err == nil {
+		return nil
+	} else if !errors.Is(err, errItemNotFound) {
+		return errors.Wrapf(err, "Fail to get chore schedule: %s %s", pk, sk)
+	}
+
+	templates, err := x.FetchChoreTemplates(userID)
+	if err != nil {
+		return err
+	}
+
+	created := 0
+	for _, tmpl := range templates {
+		start, err := tmpl.start()
+		if err != nil || !tmpl.Recurrence.Match(date, start) {
+			continue
+		}
+
+		chore := Chore{
+			UserID:      userID,
+			ChoreID:     toTemplatedChoreID(tmpl.TemplateID, date),
+			CreatedAt:   date,
+			Title:       tmpl.Title,
+			Description: tmpl.Description,
+			TemplateID:  tmpl.TemplateID,
+			store:       x.store,
+			broker:      x.broker,
+		}
+		chore.PKey, chore.SKey = toChoreKey(chore.UserID, chore.CreatedAt, chore.ChoreID)
+		if err := chore.Save(); err != nil {
+			// The chore has been created by a concurrent request or a former
+			// call that failed to save the schedule.
+			if errors.Is(err, ErrConflict) {
+				continue
+			}
+			return err
+		}
+		created++
+	}
+
+	if created > 0 {
+		if _, err := x.refreshDailyStats(userID, date); err != nil {
+			return err
+		}
+	}
+
+	schedule = choreSchedule{PKey: pk, SKey: sk, CreatedAt: time.Now().UTC()}
+	if err := x.store.Put(schedule); err != nil {
+		return errors.Wrapf(err, "Fail to save chore schedule: %s %s", pk, sk)
+	}
+
+	return nil
+}
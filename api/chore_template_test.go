@@ -0,0 +1,157 @@
+package api_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/m-mizutani/task-kitchen/api"
+)
+
+func TestRecurrenceMatch(t *testing.T) {
+	date := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02", s)
+		require.NoError(t, err)
+		return ts
+	}
+	start := date("2019-04-01") // Monday
+
+	testCases := []struct {
+		rule  main.Recurrence
+		date  string
+		match bool
+	}{
+		{main.Recurrence{Type: main.RecurDaily}, "2019-04-06", true},
+		{main.Recurrence{Type: main.RecurDaily}, "2019-03-31", false},
+		{main.Recurrence{Type: main.RecurWeekdays}, "2019-04-05", true},
+		{main.Recurrence{Type: main.RecurWeekdays}, "2019-04-06", false},
+		{main.Recurrence{Type: main.RecurWeekly, Weekdays: []string{"tue", "thu"}}, "2019-04-04", true},
+		{main.Recurrence{Type: main.RecurWeekly, Weekdays: []string{"tue", "thu"}}, "2019-04-05", false},
+		{main.Recurrence{Type: main.RecurMonthly, MonthDay: 15}, "2019-05-15", true},
+		{main.Recurrence{Type: main.RecurMonthly, MonthDay: 31}, "2019-04-30", false},
+		{main.Recurrence{Type: main.RecurMonthly, MonthDay: -1}, "2019-04-30", true},
+		{main.Recurrence{Type: main.RecurRRule, RRule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO"}, "2019-04-15", true},
+		{main.Recurrence{Type: main.RecurRRule, RRule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO"}, "2019-04-08", false},
+		{main.Recurrence{Type: main.RecurRRule, RRule: "FREQ=DAILY;COUNT=3"}, "2019-04-03", true},
+		{main.Recurrence{Type: main.RecurRRule, RRule: "FREQ=DAILY;COUNT=3"}, "2019-04-04", false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.match, tc.rule.Match(date(tc.date), start), "%v on %s", tc.rule, tc.date)
+	}
+}
+
+func TestScheduleChores(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	monday := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	tmpl := mgr.NewChoreTemplate(uid1)
+	tmpl.Title = "check email"
+	tmpl.StartDate = "2019-04-01"
+	tmpl.Recurrence = main.Recurrence{Type: main.RecurWeekdays}
+	require.NoError(t, tmpl.Save())
+
+	invalid := mgr.NewChoreTemplate(uid1)
+	invalid.Title = "bad"
+	invalid.Recurrence = main.Recurrence{Type: main.RecurRRule, RRule: "FREQ=SOMETIMES"}
+	assert.Error(t, invalid.Save())
+
+	require.NoError(t, mgr.ScheduleChores(uid1, monday))
+	chores, err := mgr.FetchChores(uid1, monday)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(chores))
+	assert.Equal(t, "check email", chores[0].Title)
+	assert.Equal(t, tmpl.TemplateID, chores[0].TemplateID)
+
+	// Deleted chore is not created again.
+	chore, err := mgr.GetChore(uid1, monday, chores[0].ChoreID)
+	require.NoError(t, err)
+	require.NoError(t, chore.Delete())
+	require.NoError(t, mgr.ScheduleChores(uid1, monday))
+	chores, err = mgr.FetchChores(uid1, monday)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(chores))
+
+	// Concurrent requests for a new date create the chore only once.
+	tuesday := monday.AddDate(0, 0, 1)
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = mgr.ScheduleChores(uid1, tuesday)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	chores, err = mgr.FetchChores(uid1, tuesday)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(chores))
+	assert.Equal(t, tmpl.TemplateID, chores[0].TemplateID)
+	assert.Equal(t, tmpl.TemplateID+"-20190402", chores[0].ChoreID)
+
+	// Chores of a template have an ID for each date.
+	wednesday := monday.AddDate(0, 0, 2)
+	require.NoError(t, mgr.ScheduleChores(uid1, wednesday))
+	found, err := mgr.FindChore(uid1, tmpl.TemplateID+"-20190403")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, wednesday, found.CreatedAt.UTC())
+	found, err = mgr.FindChore(uid1, chores[0].ChoreID)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, tuesday, found.CreatedAt.UTC())
+
+	sunday := monday.AddDate(0, 0, 6)
+	require.NoError(t, mgr.ScheduleChores(uid1, sunday))
+	chores, err = mgr.FetchChores(uid1, sunday)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(chores))
+}
+
+// scheduleFailStore fails to save chore schedules while fail is true.
+type scheduleFailStore struct {
+	main.Store
+	fail bool
+}
+
+func (x *scheduleFailStore) Put(item interface{}) error {
+	if x.fail && reflect.TypeOf(item).Name() == "choreSchedule" {
+		return errors.New("schedule is not saved")
+	}
+	return x.Store.Put(item)
+}
+
+func TestScheduleChoresRetry(t *testing.T) {
+	store := &scheduleFailStore{Store: newTestStore(), fail: true}
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	monday := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	tmpl := mgr.NewChoreTemplate(uid1)
+	tmpl.Title = "check email"
+	tmpl.StartDate = "2019-04-01"
+	tmpl.Recurrence = main.Recurrence{Type: main.RecurDaily}
+	require.NoError(t, tmpl.Save())
+
+	// Chores are saved, but the schedule is not.
+	require.Error(t, mgr.ScheduleChores(uid1, monday))
+
+	// Retry does not fail by the chore created by the former call.
+	store.fail = false
+	require.NoError(t, mgr.ScheduleChores(uid1, monday))
+	chores, err := mgr.FetchChores(uid1, monday)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(chores))
+	assert.Equal(t, 1, chores[0].Version)
+}
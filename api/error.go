@@ -1,13 +1,110 @@
 package api
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
 
-type errorType int
+	"github.com/pkg/errors"
+)
 
+// Kinds of errors. An error of a kind matches it by errors.Is, e.g. an error
+// of Store for a missing item is ErrNotFound. Errors of handlers are responded
+// with status and problem type of the kind in errorCatalog.
+var (
+	ErrValidation         = errors.New("validation failed")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrBackendUnavailable = errors.New("backend unavailable")
+)
+
+// problemTypePrefix is prefix of URI of problem types. A type is identified by
+// the URI, not resolved.
+const problemTypePrefix = "urn:task-kitchen:problem:"
+
+type errorKind struct {
+	err  error
+	code int
+	// name is a suffix of the problem type.
+	name  string
+	title string
+}
+
+var errorCatalog = []errorKind{
+	{ErrValidation, 400, "validation", "Request is invalid"},
+	{ErrUnauthorized, 401, "unauthorized", "Authentication is required"},
+	{ErrForbidden, 403, "forbidden", "Access is not allowed"},
+	{ErrNotFound, 404, "not-found", "Resource is not found"},
+	{ErrConflict, 409, "conflict", "Resource has been changed"},
+	{ErrRateLimited, 429, "rate-limited", "Too many requests"},
+	{ErrBackendUnavailable, 503, "backend-unavailable", "Backend is unavailable"},
+}
+
+// lookupErrorKind returns the kind of err, or nil if err has no kind.
+func lookupErrorKind(err error) *errorKind {
+	for i := range errorCatalog {
+		if errors.Is(err, errorCatalog[i].err) {
+			return &errorCatalog[i]
+		}
+	}
+	return nil
+}
+
+// kindOfCode returns the kind responded with the status code, or nil.
+func kindOfCode(code int) *errorKind {
+	for i := range errorCatalog {
+		if errorCatalog[i].code == code {
+			return &errorCatalog[i]
+		}
+	}
+	return nil
+}
+
+// kindError is an error of a kind that keeps the original error.
+type kindError struct {
+	kind  error
+	cause error
+}
+
+// newNotFoundError returns ErrNotFound error. The message is shown to users
+// unlike other errors of kinds.
+func newNotFoundError(msg string, args ...interface{}) error {
+	return withKind(ErrNotFound, fmt.Errorf(msg, args...))
+}
+
+// withKind marks err as an error of kind such as ErrNotFound.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, cause: err}
+}
+
+func (x *kindError) Error() string {
+	return x.cause.Error()
+}
+
+func (x *kindError) Unwrap() error {
+	return x.cause
+}
+
+func (x *kindError) Is(target error) bool {
+	return target == x.kind
+}
+
+// userError is an error with a message for users. Its kind is determined by
+// code.
 type userError struct {
 	code  int
 	msg   string
 	cause error
+	// current is the resource in the store returned with the error, e.g. the
+	// latest version for a conflict.
+	current interface{}
+	// fields are violations of rules of a request.
+	fields []FieldError
 }
 
 func newUserError(code int, msg string, args ...interface{}) *userError {
@@ -21,7 +118,77 @@ func (x *userError) Error() string {
 	return x.msg
 }
 
+func (x *userError) Unwrap() error {
+	return x.cause
+}
+
+// Is matches the kind of the code, not kind of the cause. For example, 409 for
+// a deleted item is ErrConflict.
+func (x *userError) Is(target error) bool {
+	kind := kindOfCode(x.code)
+	return kind != nil && target == kind.err
+}
+
 func (x *userError) setCause(err error) *userError {
 	x.cause = err
 	return x
 }
+
+func (x *userError) setCurrent(v interface{}) *userError {
+	x.current = v
+	return x
+}
+
+// Problem is an error response in application/problem+json (RFC 7807).
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors are violations of rules by fields of a request, if any.
+	Errors []FieldError `json:"errors,omitempty"`
+	// Current is the resource in the store, e.g. the latest version for a
+	// conflict.
+	Current   interface{} `json:"current,omitempty"`
+	RequestID string      `json:"request_id"`
+}
+
+const problemContentType = "application/problem+json"
+
+// newProblem converts err to a response. Detail is a message of userError or
+// ErrNotFound error, and messages of other errors are not shown because they
+// are for operators.
+func newProblem(err error) *Problem {
+	var problem Problem
+	var kind *errorKind
+
+	var userErr *userError
+	if errors.As(err, &userErr) {
+		problem.Status = userErr.code
+		problem.Detail = userErr.msg
+		problem.Errors = userErr.fields
+		problem.Current = userErr.current
+		kind = kindOfCode(userErr.code)
+	} else if kind = lookupErrorKind(err); kind != nil {
+		problem.Status = kind.code
+
+		var kindErr *kindError
+		if kind.err == ErrNotFound && errors.As(err, &kindErr) {
+			problem.Detail = kindErr.cause.Error()
+		}
+	} else {
+		problem.Status = 500
+	}
+
+	if kind != nil {
+		problem.Type = problemTypePrefix + kind.name
+		problem.Title = kind.title
+	} else {
+		// No type other than the status code.
+		problem.Type = "about:blank"
+		problem.Title = http.StatusText(problem.Status)
+	}
+
+	return &problem
+}
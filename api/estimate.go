@@ -0,0 +1,124 @@
+package api
+
+import (
+	"math"
+	"time"
+)
+
+// Estimate compares TomatoNum of a task with finished pomodoros.
+type Estimate struct {
+	Estimated      int `json:"estimated"`
+	Actual         int `json:"actual"`
+	FocusedMinutes int `json:"focused_minutes"`
+	// Diff is Actual - Estimated. Positive means underestimated.
+	Diff int `json:"diff"`
+	// Result is "over", "under" or "exact".
+	Result string `json:"result"`
+}
+
+func newEstimate(task *Task, stats *FocusStats) *Estimate {
+	est := Estimate{
+		Estimated:      int(task.TomatoNum),
+		Actual:         stats.Finished,
+		FocusedMinutes: stats.FocusedMinutes,
+	}
+	est.Diff = est.Actual - est.Estimated
+
+	switch {
+	case est.Diff < 0:
+		est.Result = "over"
+	case est.Diff > 0:
+		est.Result = "under"
+	default:
+		est.Result = "exact"
+	}
+
+	return &est
+}
+
+// attachTaskStats sets Focus and Estimate of tasks of the date.
+func (x KitchenManager) attachTaskStats(userID string, date time.Time, tasks []Task) error {
+	_, focus, err := x.focusStats(userID, date)
+	if err != nil {
+		return err
+	}
+
+	for i := range tasks {
+		if tasks[i].Focus = focus[tasks[i].TaskID]; tasks[i].Focus == nil {
+			tasks[i].Focus = &FocusStats{}
+		}
+		tasks[i].Estimate = newEstimate(&tasks[i], tasks[i].Focus)
+	}
+
+	return nil
+}
+
+// EstimateAccuracy is summary of estimates of done tasks in a date range.
+type EstimateAccuracy struct {
+	Begin string `json:"begin"`
+	End   string `json:"end"`
+	Tasks int    `json:"tasks"`
+	Over  int    `json:"over"`
+	Under int    `json:"under"`
+	Exact int    `json:"exact"`
+	// Estimated and Actual are total pomodoros.
+	Estimated int `json:"estimated"`
+	Actual    int `json:"actual"`
+	// MeanAbsoluteError is average of |Diff| of tasks.
+	MeanAbsoluteError float64 `json:"mean_absolute_error"`
+	// Ratio is Actual / Estimated, 0 if nothing is estimated.
+	Ratio float64 `json:"ratio"`
+}
+
+// EstimateAccuracy summarizes estimates of done tasks from begin to end.
+func (x KitchenManager) EstimateAccuracy(userID string, begin, end time.Time) (*EstimateAccuracy, error) {
+	if err := checkDateRange(begin, end, maxDateRange); err != nil {
+		return nil, err
+	}
+
+	result := EstimateAccuracy{
+		Begin: begin.Format("2006-01-02"),
+		End:   end.Format("2006-01-02"),
+	}
+	var absError int
+
+	for date := begin; !date.After(end); date = date.AddDate(0, 0, 1) {
+		tasks, err := x.FetchTasksByStatus(userID, date, []TaskStatus{TaskDone})
+		if err != nil {
+			return nil, err
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+
+		if err := x.attachTaskStats(userID, date, tasks); err != nil {
+			return nil, err
+		}
+
+		for _, task := range tasks {
+			est := task.Estimate
+			result.Tasks++
+			result.Estimated += est.Estimated
+			result.Actual += est.Actual
+			absError += int(math.Abs(float64(est.Diff)))
+
+			switch est.Result {
+			case "over":
+				result.Over++
+			case "under":
+				result.Under++
+			default:
+				result.Exact++
+			}
+		}
+	}
+
+	if result.Tasks > 0 {
+		result.MeanAbsoluteError = float64(absError) / float64(result.Tasks)
+	}
+	if result.Estimated > 0 {
+		result.Ratio = float64(result.Actual) / float64(result.Estimated)
+	}
+
+	return &result, nil
+}
@@ -1,7 +1,22 @@
 package api
 
+import "net"
+
 var (
 	NewPomodoro       = newPomodoro
 	FetchPomodoros    = fetchPomodoros
+	GetPomodoro       = getPomodoro
 	NewKitchenManager = newKitchenManager
+
+	RequestReportGeneration = KitchenManager.requestReportGeneration
 )
+
+// AllowLoopbackWebhooks lets webhooks be sent to test servers on loopback
+// addresses. It returns a function to restore.
+func AllowLoopbackWebhooks() func() {
+	allowed := webhookAddrAllowed
+	webhookAddrAllowed = func(ip net.IP) bool {
+		return ip.IsLoopback() || allowed(ip)
+	}
+	return func() { webhookAddrAllowed = allowed }
+}
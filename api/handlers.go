@@ -1,11 +1,15 @@
 package api
 
 import (
+	"io"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -52,21 +56,67 @@ func getUser(params gin.Params) (string, error) {
 	return user, nil
 }
 
+// setETag sets ETag of the version of the entity to the response.
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", versionETag(version))
+}
+
+// checkIfMatch returns 412 error with current if If-Match header of the
+// request does not have ETag of version. A request without If-Match is not
+// checked.
+func checkIfMatch(c *gin.Context, version int, current interface{}) error {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	etag := versionETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return nil
+		}
+	}
+
+	return newUserError(412, "If-Match does not match current version %s", etag).setCurrent(current)
+}
+
+// patchVersion returns version to be checked by Patch of entities. A patch is
+// applied to the latest version unless the request has If-Match.
+func patchVersion(c *gin.Context, version int) int {
+	if header := c.GetHeader("If-Match"); header == "" || header == "*" {
+		return anyVersion
+	}
+	return version
+}
+
 func getTime(c *gin.Context, key string) (time.Time, error) {
 	date, ok := c.GetQuery(key)
 	if !ok {
 		ts := time.Now()
-		return ts, newUserError(400, "Missing required query string: %s", key)
+		return ts, newFieldError(key, "required", "", "Missing required query string: %s", key)
 	}
 
 	ts, err := time.Parse("2006-01-02", date)
 	if err != nil {
-		return ts, newUserError(400, "Invalid date format '%s', should be like 2006-01-02", date)
+		return ts, newFieldError(key, ruleDate, "", "Invalid date format '%s', should be like 2006-01-02", date).setCause(err)
 	}
 
 	return ts, nil
 }
 
+// getDateRange parses "begin" and "end" queries, and checks the range.
+func getDateRange(c *gin.Context) (begin, end time.Time, err error) {
+	if begin, err = getTime(c, "begin"); err != nil {
+		return
+	}
+	if end, err = getTime(c, "end"); err != nil {
+		return
+	}
+	err = checkDateRange(begin, end, maxDateRange)
+	return
+}
+
 func getSpace(params gin.Params) (user string, ts time.Time, err error) {
 	if user, err = getUser(params); err != nil {
 		return
@@ -82,34 +132,82 @@ func getSpace(params gin.Params) (user string, ts time.Time, err error) {
 	return
 }
 
+// Response is a body of a successful response. An error is responded as
+// Problem.
 type Response struct {
-	Error     string      `json:"error,omitempty"`
 	Results   interface{} `json:"results,omitempty"`
 	RequestID string      `json:"request_id"`
 }
 
 type handler func(c *gin.Context, mgr *KitchenManager) (interface{}, error)
 
-func handle(hdlr handler, c *gin.Context, mgr *KitchenManager) {
+// rawResponse is a result of handler that is written as is instead of JSON.
+type rawResponse struct {
+	contentType string
+	body        []byte
+}
+
+const ctxRequestID = "request_id"
+
+// getRequestID returns ID of the request. A new ID is assigned at first call.
+func getRequestID(c *gin.Context) string {
+	if v, ok := c.Get(ctxRequestID); ok {
+		return v.(string)
+	}
+
 	reqID := uuid.New().String()
-	result, err := hdlr(c, mgr)
-	var code int
-	var errMsg string
-	var response interface{}
-
-	if err != nil {
-		if userErr, ok := err.(*userError); ok {
-			// User oriented error
-			code = userErr.code
-			errMsg = userErr.Error()
-		} else {
-			// System oriented error
-			code = 500
-			errMsg = "Internal server error"
+	c.Set(ctxRequestID, reqID)
+	return reqID
+}
+
+// errorResponse converts err to a problem of the request.
+func errorResponse(c *gin.Context, err error) *Problem {
+	problem := newProblem(err)
+	problem.Instance = c.Request.URL.Path
+	problem.RequestID = getRequestID(c)
+	return problem
+}
+
+func writeProblem(c *gin.Context, problem *Problem) {
+	// JSON renderer does not overwrite Content-Type.
+	c.Header("Content-Type", problemContentType)
+	c.JSON(problem.Status, problem)
+}
+
+// abortWithError stops handler chain and responds the error.
+func abortWithError(c *gin.Context, err error) {
+	problem := errorResponse(c, err)
+
+	Logger.WithFields(logrus.Fields{
+		"params": c.Params,
+		"code":   problem.Status,
+	}).WithError(err).Info("Abort request handling")
+
+	c.Abort()
+	writeProblem(c, problem)
+}
+
+// touchToken records last used time of the personal token of the request.
+func touchToken(c *gin.Context) {
+	if principal := getPrincipal(c); principal != nil && principal.token != nil {
+		if err := principal.token.touch(time.Now().UTC()); err != nil {
+			Logger.WithError(err).Warn("Fail to record last used time of token")
 		}
-	} else {
-		code = 200
-		response = result
+	}
+}
+
+func handle(hdlr handler, c *gin.Context, mgr *KitchenManager) {
+	reqID := getRequestID(c)
+
+	// Record before handling, the token may be revoked by the request.
+	touchToken(c)
+
+	result, err := hdlr(c, mgr)
+	code := 200
+	var problem *Problem
+	if err != nil {
+		problem = errorResponse(c, err)
+		code = problem.Status
 	}
 
 	Logger.WithFields(logrus.Fields{
@@ -118,13 +216,60 @@ func handle(hdlr handler, c *gin.Context, mgr *KitchenManager) {
 		"code": code,
 	}).WithError(err).Info("Finish request handling")
 
-	c.JSON(code, Response{errMsg, response, reqID})
+	if problem != nil {
+		writeProblem(c, problem)
+		return
+	}
+	if raw, ok := result.(*rawResponse); ok {
+		c.Header("X-Request-Id", reqID)
+		c.Data(code, raw.contentType, raw.body)
+		return
+	}
+	c.JSON(code, Response{Results: result, RequestID: reqID})
 }
 
 // ---
 // Routines
 // ---
 
+// refreshSpaceStats refreshes DailyStats of /:user/:date of the request.
+func refreshSpaceStats(c *gin.Context, mgr *KitchenManager) error {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return err
+	}
+
+	_, err = mgr.refreshDailyStats(user, ts)
+	return err
+}
+
+// reportDoneRoutine generates reports and carries tasks over if the report has
+// become ReportDone by the request.
+func reportDoneRoutine(mgr *KitchenManager, report *Report, wasDone bool) error {
+	if wasDone || report.Status != ReportDone {
+		return nil
+	}
+
+	if err := mgr.requestReportGeneration(report); err != nil {
+		return err
+	}
+
+	if mgr.carryOver != "" {
+		next := report.CreatedAt.AddDate(0, 0, 1)
+		if _, err := mgr.CarryOverTasks(report.UserID, report.CreatedAt, next, mgr.carryOver); err != nil {
+			return err
+		}
+		if _, err := mgr.refreshDailyStats(report.UserID, report.CreatedAt); err != nil {
+			return err
+		}
+		if _, err := mgr.refreshDailyStats(report.UserID, next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func getReportRoutine(c *gin.Context, mgr *KitchenManager) (*Report, error) {
 	user, ts, err := getSpace(c.Params)
 	if err != nil {
@@ -134,8 +279,6 @@ func getReportRoutine(c *gin.Context, mgr *KitchenManager) (*Report, error) {
 	report, err := mgr.GetReport(user, ts)
 	if err != nil {
 		return nil, err
-	} else if report == nil {
-		return nil, newUserError(404, "The report is not found")
 	}
 
 	return report, nil
@@ -152,9 +295,6 @@ func getTaskRoutine(c *gin.Context, mgr *KitchenManager) (*Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	if task == nil {
-		return nil, newUserError(404, "Task not found: %s", taskID)
-	}
 
 	return task, nil
 }
@@ -170,9 +310,6 @@ func getPomodoroRoutine(c *gin.Context, mgr *KitchenManager) (*Pomodoro, error)
 	if err != nil {
 		return nil, err
 	}
-	if pomodoro == nil {
-		return nil, newUserError(404, "Pomodoro not found")
-	}
 
 	return pomodoro, nil
 }
@@ -187,11 +324,7 @@ func fetchReportHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error
 		return nil, err
 	}
 
-	begin, err := getTime(c, "begin")
-	if err != nil {
-		return nil, err
-	}
-	end, err := getTime(c, "end")
+	begin, end, err := getDateRange(c)
 	if err != nil {
 		return nil, err
 	}
@@ -201,6 +334,12 @@ func fetchReportHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error
 		return nil, err
 	}
 
+	for i := range reports {
+		if reports[i].Focus, _, err = mgr.focusStats(user, reports[i].CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
 	return reports, nil
 }
 
@@ -211,288 +350,1738 @@ func getReportHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error)
 	}
 
 	report, err := mgr.GetReport(user, ts)
+	if errors.Is(err, ErrNotFound) && !readOnlyAccess(c, user) {
+		report, err = mgr.NewReport(user, ts)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if report == nil {
-		report, err = mgr.NewReport(user, ts)
-		if err != nil {
-			return nil, err
-		}
+	if report.Focus, _, err = mgr.focusStats(user, ts); err != nil {
+		return nil, err
 	}
 
+	setETag(c, report.Version)
 	return report, nil
 }
 
+// reportRequest is a body to update a report. Status and notes are kept if not
+// given, so that notes can be updated separately.
+type reportRequest struct {
+	Status ReportStatus `json:"Status" binding:"omitempty,report_status"`
+	Notes  *ReportNotes `json:"notes"`
+}
+
 func updateReportHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
 	report, err := getReportRoutine(c, mgr)
 	if err != nil {
 		return nil, err
 	}
 
-	var updatedReport Report
-	c.BindJSON(&updatedReport)
-	report.Status = updatedReport.Status
+	if err := checkIfMatch(c, report.Version, report); err != nil {
+		return nil, err
+	}
+
+	var updatedReport reportRequest
+	if err := bindJSON(c, &updatedReport, "report", false); err != nil {
+		return nil, err
+	}
+	wasDone := report.Status == ReportDone
+	if updatedReport.Status != "" {
+		report.Status = updatedReport.Status
+	}
+	if updatedReport.Notes != nil {
+		report.Notes = updatedReport.Notes
+	}
 
+	if err := mgr.UpdateReportSummary(report); err != nil {
+		return nil, err
+	}
 	if err := report.Save(); err != nil {
 		return nil, err
 	}
+	setETag(c, report.Version)
+
+	if err := reportDoneRoutine(mgr, report, wasDone); err != nil {
+		return nil, err
+	}
 
 	return nil, nil
 }
 
-func deleteReportHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+func patchReportHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
 	report, err := getReportRoutine(c, mgr)
 	if err != nil {
 		return nil, err
 	}
-
-	if err := report.Delete(); err != nil {
+	if err := checkIfMatch(c, report.Version, report); err != nil {
 		return nil, err
 	}
 
-	return nil, nil
-}
-
-// --------------------------------
-// Task endpoints
-// --------------------------------
-
-func getTasksHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	user, ts, err := getSpace(c.Params)
+	fields, err := patchFields(c, report)
 	if err != nil {
 		return nil, err
 	}
 
-	tasks, err := mgr.FetchTasks(user, ts)
-	if err != nil {
+	wasDone := report.Status == ReportDone
+	if err := report.Patch(fields, patchVersion(c, report.Version)); err != nil {
 		return nil, err
 	}
+	setETag(c, report.Version)
 
-	return tasks, nil
+	if err := reportDoneRoutine(mgr, report, wasDone); err != nil {
+		return nil, err
+	}
+
+	return report, nil
 }
 
-func createTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	user, ts, err := getSpace(c.Params)
+func deleteReportHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	report, err := getReportRoutine(c, mgr)
 	if err != nil {
 		return nil, err
 	}
-
-	task, err := mgr.NewTask(user, ts)
-	if err != nil {
+	if err := checkIfMatch(c, report.Version, report); err != nil {
 		return nil, err
 	}
 
-	var reqTask Task
-	if err := c.ShouldBindJSON(&reqTask); err == nil && reqTask.Title != "" {
-		task.Title = reqTask.Title
-		if err := task.Save(); err != nil {
-			return nil, err
-		}
+	if err := report.Delete(); err != nil {
+		return nil, err
 	}
 
-	return task, nil
+	return nil, nil
 }
 
-func updateTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	task, err := getTaskRoutine(c, mgr)
+func getReportViewRoutine(c *gin.Context, mgr *KitchenManager) (*ReportView, error) {
+	report, err := getReportRoutine(c, mgr)
 	if err != nil {
 		return nil, err
 	}
 
-	var updatedTask Task
-	c.BindJSON(&updatedTask)
-	task.Title = updatedTask.Title
-	task.TomatoNum = updatedTask.TomatoNum
-	task.Description = updatedTask.Description
+	return mgr.GetReportView(report)
+}
 
-	if err := task.Save(); err != nil {
+func renderReportMarkdownHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	view, err := getReportViewRoutine(c, mgr)
+	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	body, err := view.RenderMarkdown()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawResponse{"text/markdown; charset=utf-8", body}, nil
 }
 
-func deleteTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	task, err := getTaskRoutine(c, mgr)
+func renderReportHTMLHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	view, err := getReportViewRoutine(c, mgr)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := task.Delete(); err != nil {
+	body, err := view.RenderHTML()
+	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return &rawResponse{"text/html; charset=utf-8", body}, nil
 }
 
 // --------------------------------
-// Chore endpoints
+// Report template endpoints
 // --------------------------------
 
-func fetchChoresHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	user, ts, err := getSpace(c.Params)
+func fetchReportTemplatesHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
 	if err != nil {
 		return nil, err
 	}
 
-	chores, err := mgr.FetchChores(user, ts)
+	templates, err := mgr.FetchReportTemplates(user)
 	if err != nil {
 		return nil, err
 	}
 
-	return chores, nil
+	return templates, nil
 }
 
-func createChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	user, ts, err := getSpace(c.Params)
+type reportTemplateRequest struct {
+	Name         string `json:"name" binding:"required,max=256"`
+	Body         string `json:"body"`
+	AutoGenerate bool   `json:"auto_generate"`
+}
+
+func (x reportTemplateRequest) apply(tmpl *ReportTemplate) {
+	tmpl.Name = x.Name
+	tmpl.Body = x.Body
+	tmpl.AutoGenerate = x.AutoGenerate
+}
+
+func createReportTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
 	if err != nil {
 		return nil, err
 	}
 
-	chore, err := mgr.NewChore(user, ts)
-	if err != nil {
+	var req reportTemplateRequest
+	if err := bindJSON(c, &req, "report template request", false); err != nil {
 		return nil, err
 	}
 
-	var reqChore Chore
-	if err := c.ShouldBindJSON(&reqChore); err == nil && reqChore.Title != "" {
-		chore.Title = reqChore.Title
-		if err := chore.Save(); err != nil {
-			return nil, err
-		}
-	} else if err != nil {
+	tmpl := mgr.NewReportTemplate(user)
+	req.apply(tmpl)
+	if err := tmpl.Save(); err != nil {
 		return nil, err
 	}
 
-	return chore, nil
+	return tmpl, nil
 }
 
-func updateChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	user, ts, err := getSpace(c.Params)
+func getReportTemplateRoutine(c *gin.Context, mgr *KitchenManager) (*ReportTemplate, error) {
+	user, err := getUser(c.Params)
 	if err != nil {
 		return nil, err
 	}
 
-	choreID := getParam(c.Params, "chore_id")
-	chore, err := mgr.GetChore(user, ts, choreID)
+	templateID := getParam(c.Params, "template_id")
+	tmpl, err := mgr.GetReportTemplate(user, templateID)
 	if err != nil {
 		return nil, err
 	}
-	if chore == nil {
-		return nil, newUserError(404, "Chore not found: %s", choreID)
-	}
 
-	var updatedChore Chore
-	c.BindJSON(&updatedChore)
-	chore.Title = updatedChore.Title
+	return tmpl, nil
+}
 
-	if err := chore.Save(); err != nil {
+func getReportTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	return getReportTemplateRoutine(c, mgr)
+}
+
+func updateReportTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	tmpl, err := getReportTemplateRoutine(c, mgr)
+	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
-}
+	var req reportTemplateRequest
+	if err := bindJSON(c, &req, "report template request", false); err != nil {
+		return nil, err
+	}
 
-func deleteChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	user, ts, err := getSpace(c.Params)
-	if err != nil {
+	req.apply(tmpl)
+	if err := tmpl.Save(); err != nil {
 		return nil, err
 	}
 
-	choreID := getParam(c.Params, "chore_id")
-	chore, err := mgr.GetChore(user, ts, choreID)
+	return tmpl, nil
+}
+
+func deleteReportTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	tmpl, err := getReportTemplateRoutine(c, mgr)
 	if err != nil {
 		return nil, err
 	}
-	if chore == nil {
-		return nil, newUserError(404, "Chore not found: %s", choreID)
-	}
 
-	if err := chore.Delete(); err != nil {
+	if err := tmpl.Delete(); err != nil {
 		return nil, err
 	}
 
 	return nil, nil
 }
 
-// --------------------------------
-// Pomodoro endpoints
-// --------------------------------
+// renderReportTemplateHandler renders the template for "date", or for a range
+// of "begin" and "end".
+func renderReportTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	tmpl, err := getReportTemplateRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
 
-func fetchAllPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	user, ts, err := getSpace(c.Params)
+	var begin, end time.Time
+	if _, ok := c.GetQuery("date"); ok {
+		if begin, err = getTime(c, "date"); err != nil {
+			return nil, err
+		}
+		end = begin
+	} else if begin, end, err = getDateRange(c); err != nil {
+		return nil, err
+	}
+
+	data, err := mgr.ReportTemplateData(tmpl.UserID, begin, end)
 	if err != nil {
 		return nil, err
 	}
 
-	pomodoros, err := mgr.fetchAllPomodoros(user, ts)
+	body, err := tmpl.Render(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return pomodoros, nil
+	return &rawResponse{"text/plain; charset=utf-8", body}, nil
 }
 
-func fetchPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	task, err := getTaskRoutine(c, mgr)
+func fetchRenderedReportsHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
 	if err != nil {
 		return nil, err
 	}
 
-	p, err := fetchPomodoros(task)
+	reports, err := mgr.FetchRenderedReports(user, ts)
 	if err != nil {
 		return nil, err
 	}
 
-	return p, nil
+	return reports, nil
 }
 
-func getPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	pomodoro, err := getPomodoroRoutine(c, mgr)
-	if err != nil {
-		return nil, err
+// --------------------------------
+// Task endpoints
+// --------------------------------
+
+// getTaskStatuses parses comma separated "status" query. It returns nil if
+// the query is not given.
+func getTaskStatuses(c *gin.Context) ([]TaskStatus, error) {
+	query, ok := c.GetQuery("status")
+	if !ok {
+		return nil, nil
 	}
-	return pomodoro, nil
+
+	var statuses []TaskStatus
+	for _, v := range strings.Split(query, ",") {
+		status := TaskStatus(strings.TrimSpace(v))
+		if !status.valid() {
+			return nil, newFieldError("status", ruleTaskStatus, "", "Invalid task status: '%s'", status)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
 }
 
-func createPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	task, err := getTaskRoutine(c, mgr)
+func getTasksHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
 	if err != nil {
 		return nil, err
 	}
 
-	p, err := newPomodoro(task)
+	statuses, err := getTaskStatuses(c)
 	if err != nil {
 		return nil, err
 	}
 
-	return p, nil
-}
-
-func updatePomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	pomodoro, err := getPomodoroRoutine(c, mgr)
+	var tasks []Task
+	if statuses != nil {
+		tasks, err = mgr.FetchTasksByStatus(user, ts, statuses)
+	} else {
+		tasks, err = mgr.FetchTasks(user, ts)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if err := pomodoro.Finish(); err != nil {
+	if err := mgr.attachTaskStats(user, ts, tasks); err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return tasks, nil
 }
 
-func deletePomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
-	pomodoro, err := getPomodoroRoutine(c, mgr)
+func createTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := pomodoro.Delete(); err != nil {
+	// The request is validated before creating the task not to leave an
+	// empty task for an invalid request.
+	var reqTask Task
+	if err := bindJSON(c, &reqTask, "task", true); err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	task := mgr.newTask(user, ts)
+	if reqTask.Title != "" {
+		task.Title = reqTask.Title
+	}
+	if err := task.Save(); err != nil {
+		return nil, err
+	}
+
+	if err := mgr.refreshReportSummary(user, ts); err != nil {
+		return nil, err
+	}
+	if _, err := mgr.refreshDailyStats(user, ts); err != nil {
+		return nil, err
+	}
+
+	setETag(c, task.Version)
+	return task, nil
+}
+
+func updateTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	task, err := getTaskRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkIfMatch(c, task.Version, task); err != nil {
+		return nil, err
+	}
+
+	var updatedTask Task
+	if err := bindJSON(c, &updatedTask, "task", false); err != nil {
+		return nil, err
+	}
+	task.Title = updatedTask.Title
+	task.TomatoNum = updatedTask.TomatoNum
+	task.Description = updatedTask.Description
+
+	// Status is kept if not given.
+	if updatedTask.Status != "" {
+		if err := task.SetStatus(updatedTask.Status, time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := task.Save(); err != nil {
+		return nil, err
+	}
+	setETag(c, task.Version)
+
+	if err := mgr.refreshReportSummary(task.UserID, task.CreatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := mgr.refreshDailyStats(task.UserID, task.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func patchTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	task, err := getTaskRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(c, task.Version, task); err != nil {
+		return nil, err
+	}
+
+	fields, err := patchFields(c, task)
+	if err != nil {
+		return nil, err
+	}
+	if err := task.Patch(fields, patchVersion(c, task.Version)); err != nil {
+		return nil, err
+	}
+	setETag(c, task.Version)
+
+	if err := mgr.refreshReportSummary(task.UserID, task.CreatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := mgr.refreshDailyStats(task.UserID, task.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func deleteTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	task, err := getTaskRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(c, task.Version, task); err != nil {
+		return nil, err
+	}
+
+	if err := task.Delete(); err != nil {
+		return nil, err
+	}
+
+	if err := mgr.refreshReportSummary(task.UserID, task.CreatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := mgr.refreshDailyStats(task.UserID, task.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func getStatsHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	begin, end, err := getDateRange(c)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := mgr.FetchStats(user, begin, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func getEstimateAccuracyHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	begin, end, err := getDateRange(c)
+	if err != nil {
+		return nil, err
+	}
+
+	accuracy, err := mgr.EstimateAccuracy(user, begin, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return accuracy, nil
+}
+
+func getTaskHistoryHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	task, err := getTaskRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := mgr.TaskHistory(task)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+type carryOverRequest struct {
+	// To is target date, the next day by default.
+	To string `json:"to" binding:"omitempty,date"`
+	// Mode is copy (default) or move.
+	Mode CarryOverMode `json:"mode"`
+}
+
+func carryOverHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var req carryOverRequest
+	if err := bindJSON(c, &req, "carry over request", true); err != nil {
+		return nil, err
+	}
+
+	to := ts.AddDate(0, 0, 1)
+	if req.To != "" {
+		if to, err = time.Parse("2006-01-02", req.To); err != nil {
+			return nil, newUserError(400, "Invalid date format '%s', should be like 2006-01-02", req.To).setCause(err)
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = CarryOverCopy
+	}
+
+	tasks, err := mgr.CarryOverTasks(user, ts, to, req.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mgr.refreshReportSummary(user, ts); err != nil {
+		return nil, err
+	}
+	if err := mgr.refreshReportSummary(user, to); err != nil {
+		return nil, err
+	}
+	if _, err := mgr.refreshDailyStats(user, ts); err != nil {
+		return nil, err
+	}
+	if _, err := mgr.refreshDailyStats(user, to); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// --------------------------------
+// Cross-date endpoints
+// --------------------------------
+
+func fetchTasksInRangeHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+	begin, end, err := getDateRange(c)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := getTaskStatuses(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := mgr.FetchTasksInRange(user, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	if statuses != nil {
+		tasks = filterTasksByStatus(tasks, statuses)
+	}
+
+	return tasks, nil
+}
+
+func findTaskHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	taskID := getParam(c.Params, "task_id")
+	task, err := mgr.FindTask(user, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func fetchChoresInRangeHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+	begin, end, err := getDateRange(c)
+	if err != nil {
+		return nil, err
+	}
+
+	chores, err := mgr.FetchChoresInRange(user, begin, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return chores, nil
+}
+
+func findChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	choreID := getParam(c.Params, "chore_id")
+	chore, err := mgr.FindChore(user, choreID)
+	if err != nil {
+		return nil, err
+	}
+
+	return chore, nil
+}
+
+func fetchPomodorosInRangeHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+	begin, end, err := getDateRange(c)
+	if err != nil {
+		return nil, err
+	}
+
+	pomodoros, err := mgr.FetchPomodorosInRange(user, begin, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return pomodoros, nil
+}
+
+// --------------------------------
+// Search endpoint
+// --------------------------------
+
+func searchHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	q := SearchQuery{Text: c.Query("q")}
+	if v, ok := c.GetQuery("type"); ok {
+		for _, kind := range strings.Split(v, ",") {
+			q.Kinds = append(q.Kinds, SearchKind(strings.TrimSpace(kind)))
+		}
+	}
+	if _, ok := c.GetQuery("begin"); ok {
+		if q.Begin, err = getTime(c, "begin"); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := c.GetQuery("end"); ok {
+		if q.End, err = getTime(c, "end"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := c.GetQuery("limit"); ok {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, newFieldError("limit", ruleType, "number", "limit should be number, got %s", v).setCause(err)
+		} else if q.Limit <= 0 {
+			return nil, newFieldError("limit", "min", "1", "limit should be at least 1")
+		}
+	}
+
+	hits, err := mgr.Search(user, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return hits, nil
+}
+
+// --------------------------------
+// Chore endpoints
+// --------------------------------
+
+func fetchChoresHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mgr.ScheduleChores(user, ts); err != nil {
+		return nil, err
+	}
+
+	chores, err := mgr.FetchChores(user, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	return chores, nil
+}
+
+func createChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqChore Chore
+	if err := bindJSON(c, &reqChore, "chore", true); err != nil {
+		return nil, err
+	}
+
+	chore := mgr.newChore(user, ts)
+	if reqChore.Title != "" {
+		chore.Title = reqChore.Title
+	}
+	if err := chore.Save(); err != nil {
+		return nil, err
+	}
+
+	if _, err := mgr.refreshDailyStats(user, ts); err != nil {
+		return nil, err
+	}
+
+	setETag(c, chore.Version)
+	return chore, nil
+}
+
+func updateChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	choreID := getParam(c.Params, "chore_id")
+	chore, err := mgr.GetChore(user, ts, choreID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkIfMatch(c, chore.Version, chore); err != nil {
+		return nil, err
+	}
+
+	var updatedChore Chore
+	if err := bindJSON(c, &updatedChore, "chore", false); err != nil {
+		return nil, err
+	}
+	chore.Title = updatedChore.Title
+
+	if err := chore.Save(); err != nil {
+		return nil, err
+	}
+	setETag(c, chore.Version)
+
+	if _, err := mgr.refreshDailyStats(user, ts); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func patchChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	choreID := getParam(c.Params, "chore_id")
+	chore, err := mgr.GetChore(user, ts, choreID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(c, chore.Version, chore); err != nil {
+		return nil, err
+	}
+
+	fields, err := patchFields(c, chore)
+	if err != nil {
+		return nil, err
+	}
+	if err := chore.Patch(fields, patchVersion(c, chore.Version)); err != nil {
+		return nil, err
+	}
+	setETag(c, chore.Version)
+
+	if _, err := mgr.refreshDailyStats(user, ts); err != nil {
+		return nil, err
+	}
+
+	return chore, nil
+}
+
+func deleteChoreHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	choreID := getParam(c.Params, "chore_id")
+	chore, err := mgr.GetChore(user, ts, choreID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(c, chore.Version, chore); err != nil {
+		return nil, err
+	}
+
+	if err := chore.Delete(); err != nil {
+		return nil, err
+	}
+
+	if _, err := mgr.refreshDailyStats(user, ts); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func fetchChoreTemplatesHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := mgr.FetchChoreTemplates(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+type choreTemplateRequest struct {
+	Title       string     `json:"title" binding:"required,max=256"`
+	Description string     `json:"description" binding:"max=10000"`
+	Recurrence  Recurrence `json:"recurrence"`
+	StartDate   string     `json:"start_date" binding:"omitempty,date"`
+}
+
+func (x choreTemplateRequest) apply(tmpl *ChoreTemplate) {
+	tmpl.Title = x.Title
+	tmpl.Description = x.Description
+	tmpl.Recurrence = x.Recurrence
+	if x.StartDate != "" {
+		tmpl.StartDate = x.StartDate
+	}
+}
+
+func createChoreTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var req choreTemplateRequest
+	if err := bindJSON(c, &req, "chore template request", false); err != nil {
+		return nil, err
+	}
+
+	tmpl := mgr.NewChoreTemplate(user)
+	req.apply(tmpl)
+	if err := tmpl.Save(); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+func getChoreTemplateRoutine(c *gin.Context, mgr *KitchenManager) (*ChoreTemplate, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	templateID := getParam(c.Params, "template_id")
+	tmpl, err := mgr.GetChoreTemplate(user, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+func updateChoreTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	tmpl, err := getChoreTemplateRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	var req choreTemplateRequest
+	if err := bindJSON(c, &req, "chore template request", false); err != nil {
+		return nil, err
+	}
+
+	req.apply(tmpl)
+	if err := tmpl.Save(); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+func deleteChoreTemplateHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	tmpl, err := getChoreTemplateRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tmpl.Delete(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// --------------------------------
+// Pomodoro endpoints
+// --------------------------------
+
+func fetchAllPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	pomodoros, err := mgr.fetchAllPomodoros(user, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	return pomodoros, nil
+}
+
+func fetchPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	task, err := getTaskRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := fetchPomodoros(task)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func getPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	pomodoro, err := getPomodoroRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	setETag(c, pomodoro.Version)
+	return pomodoro, nil
+}
+
+func createPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	task, err := getTaskRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := mgr.GetPomodoroSettings(task.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newPomodoro(task, settings.WorkDuration())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := mgr.refreshDailyStats(task.UserID, task.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	setETag(c, p.Version)
+	return p, nil
+}
+
+type updatePomodoroRequest struct {
+	// Status is finished (default) or abandoned.
+	Status string `json:"status" binding:"omitempty,oneof=finished abandoned"`
+}
+
+func updatePomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	pomodoro, err := getPomodoroRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkIfMatch(c, pomodoro.Version, pomodoro); err != nil {
+		return nil, err
+	}
+
+	var req updatePomodoroRequest
+	if err := bindJSON(c, &req, "pomodoro request", true); err != nil {
+		return nil, err
+	}
+
+	if req.Status == PomodoroAbandoned {
+		err = pomodoro.Abandon()
+	} else {
+		err = pomodoro.Finish()
+	}
+	if err != nil {
+		return nil, err
+	}
+	setETag(c, pomodoro.Version)
+
+	if err := refreshSpaceStats(c, mgr); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+type interruptPomodoroRequest struct {
+	// Interruption is checked by Interrupt to report fields without the
+	// name of embedded struct.
+	Interruption `binding:"-"`
+	// Abandon stops the pomodoro by the interruption.
+	Abandon bool `json:"abandon"`
+}
+
+func interruptPomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	pomodoro, err := getPomodoroRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkIfMatch(c, pomodoro.Version, pomodoro); err != nil {
+		return nil, err
+	}
+
+	var req interruptPomodoroRequest
+	if err := bindJSON(c, &req, "interruption request", false); err != nil {
+		return nil, err
+	}
+
+	if err := pomodoro.Interrupt(req.Interruption, req.Abandon); err != nil {
+		return nil, err
+	}
+	setETag(c, pomodoro.Version)
+
+	if err := refreshSpaceStats(c, mgr); err != nil {
+		return nil, err
+	}
+
+	return pomodoro, nil
+}
+
+func deletePomodoroHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	pomodoro, err := getPomodoroRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(c, pomodoro.Version, pomodoro); err != nil {
+		return nil, err
+	}
+
+	if err := pomodoro.Delete(); err != nil {
+		return nil, err
+	}
+
+	if err := refreshSpaceStats(c, mgr); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func fetchBreaksHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	breaks, err := mgr.FetchBreaks(user, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	return breaks, nil
+}
+
+type startBreakRequest struct {
+	// Kind is short or long. The next step of the cycle is used if empty.
+	Kind BreakKind `json:"kind"`
+}
+
+func startBreakHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var req startBreakRequest
+	if err := bindJSON(c, &req, "break request", true); err != nil {
+		return nil, err
+	}
+
+	if req.Kind == "" {
+		next, err := mgr.NextStep(user, ts)
+		if err != nil {
+			return nil, err
+		}
+		req.Kind = ShortBreak
+		if next.Step == StepLongBreak {
+			req.Kind = LongBreak
+		}
+	}
+
+	settings, err := mgr.GetPomodoroSettings(user)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := mgr.StartBreak(user, ts, req.Kind, settings.BreakDuration(req.Kind))
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func getBreakRoutine(c *gin.Context, mgr *KitchenManager) (*Break, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	breakID := getParam(c.Params, "break_id")
+	b, err := mgr.GetBreak(user, ts, breakID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func finishBreakHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	b, err := getBreakRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.Finish(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func deleteBreakHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	b, err := getBreakRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.Delete(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func getNextStepHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := mgr.NextStep(user, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+func getPomodoroSettingsHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := mgr.GetPomodoroSettings(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func updatePomodoroSettingsHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := mgr.GetPomodoroSettings(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bindJSON(c, settings, "pomodoro settings", false); err != nil {
+		return nil, err
+	}
+	settings.UserID = user
+
+	if err := settings.Save(); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// --------------------------------
+// Token endpoints
+// --------------------------------
+
+// getTokenOwner returns user of the space. Tokens can be managed only by the
+// owner even if other principals are granted to access the space.
+func getTokenOwner(c *gin.Context) (string, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return "", err
+	}
+
+	if principal := getPrincipal(c); principal != nil && principal.UserID != user {
+		return "", newUserError(403, "Tokens can be managed only by the owner")
+	}
+
+	return user, nil
+}
+
+func fetchTokensHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getTokenOwner(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := mgr.FetchTokens(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+type createTokenRequest struct {
+	Name      string     `json:"name" binding:"max=256"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,scope"`
+	ExpiresAt *time.Time `json:"expires_at" binding:"omitempty,gt"`
+}
+
+type createTokenResponse struct {
+	Token *Token `json:"token"`
+	// Secret is shown only once at creation.
+	Secret string `json:"secret"`
+}
+
+func createTokenHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getTokenOwner(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var req createTokenRequest
+	if err := bindJSON(c, &req, "token request", false); err != nil {
+		return nil, err
+	}
+
+	principal := getPrincipal(c)
+	for _, scope := range req.Scopes {
+		if principal != nil && !principal.HasScope(scope) {
+			return nil, newUserError(403, "Scope '%s' can not be granted by the credential", scope)
+		}
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.UTC()
+	}
+
+	token, secret, err := mgr.NewToken(user, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &createTokenResponse{Token: token, Secret: secret}, nil
+}
+
+func revokeTokenHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getTokenOwner(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenID := getParam(c.Params, "token_id")
+	token, err := mgr.GetToken(user, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := token.Revoke(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// --------------------------------
+// Webhook endpoints
+// --------------------------------
+
+func fetchWebhooksHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks, err := mgr.FetchWebhooks(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url" binding:"required,max=2048,webhook_url"`
+	Events []string `json:"events" binding:"required,min=1,dive,webhook_event"`
+	// Active is kept if nil.
+	Active *bool `json:"active"`
+}
+
+func (x webhookRequest) apply(hook *Webhook) {
+	hook.URL = x.URL
+	hook.Events = x.Events
+	if x.Active != nil {
+		hook.Active = *x.Active
+	}
+}
+
+type createWebhookResponse struct {
+	Webhook *Webhook `json:"webhook"`
+	// Secret is shown only once at creation.
+	Secret string `json:"secret"`
+}
+
+func createWebhookHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var req webhookRequest
+	if err := bindJSON(c, &req, "webhook request", false); err != nil {
+		return nil, err
+	}
+
+	hook, err := mgr.NewWebhook(user)
+	if err != nil {
+		return nil, err
+	}
+	req.apply(hook)
+	if err := hook.Save(); err != nil {
+		return nil, err
+	}
+
+	return &createWebhookResponse{Webhook: hook, Secret: hook.Secret}, nil
+}
+
+func getWebhookRoutine(c *gin.Context, mgr *KitchenManager) (*Webhook, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookID := getParam(c.Params, "webhook_id")
+	hook, err := mgr.GetWebhook(user, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+func getWebhookHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	return getWebhookRoutine(c, mgr)
+}
+
+func updateWebhookHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	hook, err := getWebhookRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	var req webhookRequest
+	if err := bindJSON(c, &req, "webhook request", false); err != nil {
+		return nil, err
+	}
+
+	req.apply(hook)
+	if err := hook.Save(); err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+func deleteWebhookHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	hook, err := getWebhookRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := hook.Delete(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func fetchWebhookDeliveriesHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	hook, err := getWebhookRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := hook.FetchDeliveries()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func pingWebhookHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	hook, err := getWebhookRoutine(c, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	return mgr.PingWebhook(hook)
+}
+
+// --------------------------------
+// Event stream endpoint
+// --------------------------------
+
+// streamHeartbeatInterval is interval of comment lines sent to keep an idle
+// stream open through proxies.
+const streamHeartbeatInterval = 30 * time.Second
+
+// streamEventsHandler sends events of the day as Server-Sent Events until the
+// client disconnects. It is not a handler for handle because the response is
+// written progressively.
+func streamEventsHandler(c *gin.Context, mgr *KitchenManager) {
+	user, ts, err := getSpace(c.Params)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	broker := mgr.broker
+	if broker == nil {
+		abortWithError(c, newUserError(501, "Event stream is not available"))
+		return
+	}
+
+	touchToken(c)
+
+	sub, err := broker.Subscribe(toStreamTopic(user, ts))
+	if err != nil {
+		abortWithError(c, errors.Wrapf(err, "Fail to subscribe events: %s %s", user, ts.Format("2006-01-02")))
+		return
+	}
+	defer sub.Close()
+
+	Logger.WithField("params", c.Params).Info("Start event stream")
+
+	// Headers are sent before the first event so that the client knows the
+	// stream is established.
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Request-Id", getRequestID(c))
+	c.Status(200)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return false
+			}
+			c.Render(-1, sse.Event{Id: ev.EventID, Event: ev.Event, Data: ev})
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		}
+	})
+}
+
+// --------------------------------
+// Team endpoints
+// --------------------------------
+
+// getTeamRoutine returns the team and membership of the user. A team is not
+// found for users who are not members, and for pending members unless
+// allowPending.
+func getTeamRoutine(c *gin.Context, mgr *KitchenManager, allowPending bool) (*Team, *Membership, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	teamID := getParam(c.Params, "team_id")
+	team, err := mgr.GetTeam(teamID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := team.GetMember(user)
+	if errors.Is(err, ErrNotFound) || (err == nil && member.Pending && !allowPending) {
+		return nil, nil, newNotFoundError("Team not found: %s", teamID)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return team, member, nil
+}
+
+func fetchTeamsHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	teams, err := mgr.FetchUserTeams(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
+type createTeamRequest struct {
+	Name string `json:"name" binding:"required,max=256"`
+}
+
+func createTeamHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	user, err := getUser(c.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var req createTeamRequest
+	if err := bindJSON(c, &req, "team request", false); err != nil {
+		return nil, err
+	}
+
+	team, err := mgr.NewTeam(user, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return team, nil
+}
+
+type teamResponse struct {
+	*Team
+	Members []Membership `json:"members"`
+}
+
+func getTeamHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	team, _, err := getTeamRoutine(c, mgr, false)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := team.FetchMembers()
+	if err != nil {
+		return nil, err
+	}
+
+	return &teamResponse{Team: team, Members: members}, nil
+}
+
+func getTeamReportStatusHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	team, _, err := getTeamRoutine(c, mgr, false)
+	if err != nil {
+		return nil, err
+	}
+
+	date := getParam(c.Params, "date")
+	ts, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, newUserError(400, "Invalid date format '%s', should be like 2006-01-02", date).setCause(err)
+	}
+
+	status, err := mgr.FetchTeamReportStatus(team, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+type putTeamMemberRequest struct {
+	Role TeamRole `json:"role" binding:"required,team_role"`
+}
+
+func putTeamMemberHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	team, actor, err := getTeamRoutine(c, mgr, false)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.Role.canManage() {
+		return nil, newUserError(403, "Only owner or admin can manage members")
+	}
+
+	memberID := getParam(c.Params, "member")
+	if memberID == "" || !containsOnly(memberID, charsetAlphabet+charsetDigit+"@_-") {
+		return nil, newUserError(400, "Invalid member: '%s'", memberID)
+	}
+
+	var req putTeamMemberRequest
+	if err := bindJSON(c, &req, "member request", false); err != nil {
+		return nil, err
+	}
+
+	current, err := team.GetMember(memberID)
+	if errors.Is(err, ErrNotFound) {
+		current = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if (req.Role == TeamOwner || (current != nil && current.Role == TeamOwner)) && actor.Role != TeamOwner {
+		return nil, newUserError(403, "Only owner can change owner role")
+	}
+	if current != nil && current.Role == TeamOwner && req.Role != TeamOwner {
+		return nil, newUserError(400, "Owner can not be demoted")
+	}
+
+	if err := team.SetMember(memberID, req.Role); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func deleteTeamMemberHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	team, actor, err := getTeamRoutine(c, mgr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	memberID := getParam(c.Params, "member")
+	// Any member can leave the team, and an invited user can decline it.
+	if memberID != actor.UserID && !actor.Role.canManage() {
+		return nil, newUserError(403, "Only owner or admin can manage members")
+	}
+
+	if actor.Pending && memberID != actor.UserID {
+		return nil, newNotFoundError("Team not found: %s", team.TeamID)
+	}
+
+	member, err := team.GetMember(memberID)
+	if err != nil {
+		return nil, err
+	}
+	if member.Role == TeamOwner {
+		return nil, newUserError(400, "Owner can not be removed")
+	}
+
+	if err := team.RemoveMember(memberID); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// acceptTeamHandler makes the user an active member of the team. Only the
+// invited user can accept, even if other principals are granted to access
+// the space.
+func acceptTeamHandler(c *gin.Context, mgr *KitchenManager) (interface{}, error) {
+	team, actor, err := getTeamRoutine(c, mgr, true)
+	if err != nil {
+		return nil, err
+	}
+	if principal := getPrincipal(c); principal != nil && principal.UserID != actor.UserID {
+		return nil, newUserError(403, "Invitation can be accepted only by the invited user")
+	}
+
+	if err := team.Accept(actor.UserID); err != nil {
+		return nil, err
+	}
+
+	member, err := team.GetMember(actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
 }
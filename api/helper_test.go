@@ -5,15 +5,48 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+
+	"github.com/m-mizutani/task-kitchen/api"
 )
 
 type testConfig struct {
-	TableName   string `json:"table_name"`
-	TableRegion string `json:"table_region"`
+	TableName       string `json:"table_name"`
+	TableRegion     string `json:"table_region"`
+	TableEndpoint   string `json:"table_endpoint"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	CreateTable     bool   `json:"create_table"`
 }
 
 var testCfg testConfig
 
+// newTestStore returns DynamoDB store if test config is given. Otherwise tests
+// run with in-memory store.
+func newTestStore() api.Store {
+	if testCfg.TableName == "" {
+		return api.NewMemoryStore()
+	}
+
+	cfg := api.DynamoConfig{
+		Region:          testCfg.TableRegion,
+		TableName:       testCfg.TableName,
+		Endpoint:        testCfg.TableEndpoint,
+		AccessKeyID:     testCfg.AccessKeyID,
+		SecretAccessKey: testCfg.SecretAccessKey,
+		CreateTable:     testCfg.CreateTable,
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		log.Fatalf("Fail to load DynamoDB config: %s", err)
+	}
+
+	store, err := api.NewDynamoStore(cfg)
+	if err != nil {
+		log.Fatalf("Fail to setup DynamoDB store: %s", err)
+	}
+
+	return store
+}
+
 func init() {
 	confPath := "./test.json"
 	if newPath := os.Getenv("TEST_CONFIG_PATH"); newPath != "" {
@@ -21,12 +54,14 @@ func init() {
 	}
 
 	raw, err := ioutil.ReadFile(confPath)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		log.Fatalf("Fail to read test config file: %s, %s", confPath, err)
 	}
 
-	if err := json.Unmarshal(raw, &testCfg); err != nil {
-		log.Fatalf("Fail to unmarshal test config file: %s, %s", confPath, err)
+	if err == nil {
+		if err := json.Unmarshal(raw, &testCfg); err != nil {
+			log.Fatalf("Fail to unmarshal test config file: %s, %s", confPath, err)
+		}
 	}
 
 	runTestServer()
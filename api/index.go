@@ -0,0 +1,272 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// indexByTime lists items of a user and an entity kind in date order.
+	indexByTime = Index{Name: "by_time", PKey: "ipk", SKey: "isk"}
+	// indexByID finds an item of a user by entity kind and ID without date.
+	indexByID = Index{Name: "by_id", PKey: "iid"}
+)
+
+// Entity kinds in secondary indexes.
+const (
+	indexKindTask     = "task"
+	indexKindChore    = "chore"
+	indexKindPomodoro = "pomodoro"
+)
+
+// indexKeys are attributes of secondary indexes. Entities that can be queried
+// across dates embed it and set it before saving.
+type indexKeys struct {
+	IndexPK string `dynamo:"ipk,omitempty" json:"-"`
+	IndexSK string `dynamo:"isk,omitempty" json:"-"`
+	IndexID string `dynamo:"iid,omitempty" json:"-"`
+}
+
+func newIndexKeys(kind, userID string, date time.Time, id string) indexKeys {
+	return indexKeys{
+		IndexPK: fmt.Sprintf("%s/%s", userID, kind),
+		IndexSK: fmt.Sprintf("%s/%s", date.Format("20060102"), id),
+		IndexID: fmt.Sprintf("%s/%s/%s", userID, kind, id),
+	}
+}
+
+// maxDateRange limits number of days of a range query.
+const maxDateRange = 366
+
+// checkDateRange validates a range of dates given by a client. The range
+// should be less than maxDays days.
+func checkDateRange(begin, end time.Time, maxDays int) error {
+	if end.Before(begin) {
+		return newFieldError("end", "gtefield", "begin", "end should not be before begin")
+	}
+	if end.Sub(begin) >= time.Duration(maxDays)*24*time.Hour {
+		return newFieldError("end", ruleMaxSpan, strconv.Itoa(maxDays), "Date range should be less than %d days", maxDays)
+	}
+
+	return nil
+}
+
+func (x KitchenManager) queryDateRange(kind, userID string, begin, end time.Time, out interface{}) error {
+	if err := checkDateRange(begin, end, maxDateRange); err != nil {
+		return err
+	}
+
+	pk := fmt.Sprintf("%s/%s", userID, kind)
+	// "~" is greater than any character of IDs.
+	cond := SortKeyBetween(begin.Format("20060102"), end.Format("20060102")+"/~")
+	if err := x.store.QueryIndex(indexByTime, pk, cond, out); err != nil {
+		return errors.Wrapf(err, "Fail to query %s from %s to %s", pk, begin, end)
+	}
+
+	return nil
+}
+
+func (x KitchenManager) lookupByID(kind, userID, id string, out interface{}) error {
+	iid := fmt.Sprintf("%s/%s/%s", userID, kind, id)
+	if err := x.store.QueryIndex(indexByID, iid, AnySortKey(), out); err != nil {
+		return errors.Wrapf(err, "Fail to look up %s", iid)
+	}
+
+	return nil
+}
+
+// FetchTasksInRange returns tasks from begin to end in date order.
+func (x KitchenManager) FetchTasksInRange(userID string, begin, end time.Time) ([]Task, error) {
+	var tasks []Task
+	if err := x.queryDateRange(indexKindTask, userID, begin, end, &tasks); err != nil {
+		return nil, err
+	}
+
+	for i := range tasks {
+		tasks[i].store, tasks[i].broker = x.store, x.broker
+		tasks[i].normalize()
+	}
+	return tasks, nil
+}
+
+// FindTask returns a task by ID of any date.
+func (x KitchenManager) FindTask(userID, taskID string) (*Task, error) {
+	var tasks []Task
+	if err := x.lookupByID(indexKindTask, userID, taskID, &tasks); err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, newNotFoundError("Task not found: %s", taskID)
+	}
+
+	task := tasks[0]
+	task.store, task.broker = x.store, x.broker
+	task.normalize()
+	return &task, nil
+}
+
+// FetchChoresInRange returns chores from begin to end in date order.
+func (x KitchenManager) FetchChoresInRange(userID string, begin, end time.Time) ([]Chore, error) {
+	var chores []Chore
+	if err := x.queryDateRange(indexKindChore, userID, begin, end, &chores); err != nil {
+		return nil, err
+	}
+
+	for i := range chores {
+		chores[i].store, chores[i].broker = x.store, x.broker
+	}
+	return chores, nil
+}
+
+// FindChore returns a chore by ID of any date.
+func (x KitchenManager) FindChore(userID, choreID string) (*Chore, error) {
+	var chores []Chore
+	if err := x.lookupByID(indexKindChore, userID, choreID, &chores); err != nil {
+		return nil, err
+	}
+	if len(chores) == 0 {
+		return nil, newNotFoundError("Chore not found: %s", choreID)
+	}
+
+	chore := chores[0]
+	chore.store, chore.broker = x.store, x.broker
+	return &chore, nil
+}
+
+// FetchPomodorosInRange returns pomodoros from begin to end in order of start
+// time.
+func (x KitchenManager) FetchPomodorosInRange(userID string, begin, end time.Time) ([]Pomodoro, error) {
+	var pomodoros []Pomodoro
+	if err := x.queryDateRange(indexKindPomodoro, userID, begin, end, &pomodoros); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for i := range pomodoros {
+		pomodoros[i].store, pomodoros[i].broker = x.store, x.broker
+		pomodoros[i].expire(now)
+	}
+	sort.SliceStable(pomodoros, func(i, j int) bool {
+		return pomodoros[i].StartedAt.Before(pomodoros[j].StartedAt)
+	})
+
+	return pomodoros, nil
+}
+
+// --------------------------------
+// Backfill
+// --------------------------------
+
+// BackfillIndex sets index attributes to tasks, chores and pomodoros that were
+// saved before secondary indexes were introduced. It returns number of updated
+// items. Search documents of tasks and chores are refreshed in any case. The
+// store must implement Scanner.
+func BackfillIndex(store Store) (int, error) {
+	scanner, ok := store.(Scanner)
+	if !ok {
+		return 0, errors.New("Store does not support scan")
+	}
+
+	mgr := newKitchenManager(store)
+	count := 0
+	err := scanner.Scan(func(pk, sk string) error {
+		updated, err := mgr.backfillItem(pk, sk)
+		if updated {
+			count++
+		}
+		return err
+	})
+
+	return count, err
+}
+
+// backfillItem updates an item if it is a task, chore or pomodoro without
+// index attributes. Partition key of them is user/kind/date. Only index
+// attributes are written instead of Save not to send webhook events, and not
+// to overwrite changes saved after the item is read.
+func (x KitchenManager) backfillItem(pk, sk string) (bool, error) {
+	parts := strings.Split(pk, "/")
+	if len(parts) != 3 {
+		return false, nil
+	}
+	userID, kind := parts[0], parts[1]
+	date, err := time.Parse("20060102", parts[2])
+	if err != nil {
+		return false, nil
+	}
+
+	switch kind {
+	case indexKindTask:
+		var task Task
+		if err := x.store.Get(pk, sk, &task); err != nil {
+			return false, errors.Wrapf(err, "Fail to get task: %s %s", pk, sk)
+		}
+		updated := task.IndexPK == ""
+		if updated {
+			keys := newIndexKeys(indexKindTask, task.UserID, task.CreatedAt, task.TaskID)
+			if err := x.setIndexKeys(pk, sk, keys, &task); errors.Is(err, errItemNotFound) {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+		}
+		return updated, searchIndexOf(x.store).IndexDocument(task.searchDocument())
+
+	case indexKindChore:
+		var chore Chore
+		if err := x.store.Get(pk, sk, &chore); err != nil {
+			return false, errors.Wrapf(err, "Fail to get chore: %s %s", pk, sk)
+		}
+		updated := chore.IndexPK == ""
+		if updated {
+			keys := newIndexKeys(indexKindChore, chore.UserID, chore.CreatedAt, chore.ChoreID)
+			if err := x.setIndexKeys(pk, sk, keys, &chore); errors.Is(err, errItemNotFound) {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+		}
+		return updated, searchIndexOf(x.store).IndexDocument(chore.searchDocument())
+
+	case indexKindPomodoro:
+		if strings.HasPrefix(sk, breakKeyPrefix) {
+			return false, nil
+		}
+		var pomodoro Pomodoro
+		if err := x.store.Get(pk, sk, &pomodoro); err != nil {
+			return false, errors.Wrapf(err, "Fail to get pomodoro: %s %s", pk, sk)
+		}
+		if pomodoro.IndexPK != "" {
+			return false, nil
+		}
+		keys := newIndexKeys(indexKindPomodoro, userID, date, pomodoro.PomodoroID)
+		if err := x.setIndexKeys(pk, sk, keys, &pomodoro); errors.Is(err, errItemNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// setIndexKeys writes only index attributes of the item regardless of its
+// version. The updated item is read into out. It returns errItemNotFound if
+// the item has been deleted.
+func (x KitchenManager) setIndexKeys(pk, sk string, keys indexKeys, out interface{}) error {
+	set := map[string]interface{}{
+		"ipk": keys.IndexPK,
+		"isk": keys.IndexSK,
+		"iid": keys.IndexID,
+	}
+	if err := x.store.Update(pk, sk, set, anyVersion, out); err != nil {
+		return errors.Wrapf(err, "Fail to set index keys: %s %s", pk, sk)
+	}
+	return nil
+}
@@ -1,24 +1,21 @@
 package api
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/guregu/dynamo"
 	"github.com/sirupsen/logrus"
 )
 
 type KitchenManager struct {
-	table     dynamo.Table
-	tableName string
+	store Store
+	// broker publishes events of changes to event streams. It is nil if
+	// event streams are not available.
+	broker Broker
+	// carryOver is applied when a report becomes ReportDone if not empty.
+	carryOver CarryOverMode
 }
 
-func newKitchenManager(region, tableName string) KitchenManager {
-	cfg := &aws.Config{Region: aws.String(region)}
-	db := dynamo.New(session.New(), cfg)
-
+func newKitchenManager(store Store) KitchenManager {
 	kitchenMgr := KitchenManager{
-		table:     db.Table(tableName),
-		tableName: tableName,
+		store: store,
 	}
 
 	return kitchenMgr
@@ -0,0 +1,49 @@
+package api
+
+// Feature is a name of feature toggle.
+type Feature string
+
+const (
+	// FeatureChore enables chore endpoints.
+	FeatureChore Feature = "chore"
+	// FeaturePomodoro enables pomodoro endpoints.
+	FeaturePomodoro Feature = "pomodoro"
+)
+
+// featureDefaults has all available features and their default state.
+var featureDefaults = map[Feature]bool{
+	FeatureChore:    true,
+	FeaturePomodoro: true,
+}
+
+// Features is a set of feature toggles. A feature that is not in the map
+// follows its default state.
+type Features map[Feature]bool
+
+// Enabled returns true if the feature is turned on.
+func (x Features) Enabled(f Feature) bool {
+	if v, ok := x[f]; ok {
+		return v
+	}
+	return featureDefaults[f]
+}
+
+// IsKnownFeature returns true if name is an available feature.
+func IsKnownFeature(name string) bool {
+	_, ok := featureDefaults[Feature(name)]
+	return ok
+}
+
+// Options is optional settings of SetupRouter. Zero value is default.
+type Options struct {
+	Features Features
+	// Authenticators are tried in order to identify a client. Authentication
+	// is disabled if empty.
+	Authenticators []Authenticator
+	// CarryOver carries unfinished tasks over to the next day when a report
+	// becomes done. Disabled if empty.
+	CarryOver CarryOverMode
+	// Broker publishes events of changes to event streams of
+	// GET /:user/:date/events. Event streams respond 501 if nil.
+	Broker Broker
+}
@@ -0,0 +1,425 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Media types of PATCH request body. A body of application/json is a merge
+// patch.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+
+	// maxPatchSize limits length of PATCH request body.
+	maxPatchSize = 64 * 1024
+)
+
+// patchFields applies the patch in the request body to JSON of current and
+// returns top-level fields that are changed by the patch. A removed field has
+// null. Fields are validated and applied by Patch of each entity.
+func patchFields(c *gin.Context, current interface{}) (map[string]json.RawMessage, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxPatchSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to read patch")
+	}
+	if len(body) > maxPatchSize {
+		return nil, newUserError(413, "Patch should be %d bytes or less", maxPatchSize)
+	}
+
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to marshal current data")
+	}
+	var orig, doc interface{}
+	if err := decodeJSON(raw, &orig); err != nil {
+		return nil, errors.Wrap(err, "Fail to unmarshal current data")
+	}
+	if err := decodeJSON(raw, &doc); err != nil {
+		return nil, errors.Wrap(err, "Fail to unmarshal current data")
+	}
+
+	switch c.ContentType() {
+	case jsonPatchType:
+		var ops []jsonPatchOp
+		if err := decodeJSON(body, &ops); err != nil {
+			return nil, newUserError(400, "Invalid JSON patch: %s", err).setCause(err)
+		}
+		if doc, err = applyJSONPatch(doc, ops); err != nil {
+			return nil, err
+		}
+
+	case mergePatchType, gin.MIMEJSON, "":
+		var patch interface{}
+		if err := decodeJSON(body, &patch); err != nil {
+			return nil, newUserError(400, "Invalid merge patch: %s", err).setCause(err)
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return nil, newUserError(400, "Merge patch should be an object")
+		}
+		doc = mergePatch(doc, patch)
+
+	default:
+		return nil, newUserError(415, "Unsupported patch type '%s', should be %s or %s", c.ContentType(), mergePatchType, jsonPatchType)
+	}
+
+	before, _ := orig.(map[string]interface{})
+	after, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, newUserError(400, "Patched data should be an object")
+	}
+
+	fields := map[string]json.RawMessage{}
+	for name, v := range after {
+		if old, ok := before[name]; ok && reflect.DeepEqual(old, v) {
+			continue
+		}
+		if fields[name], err = json.Marshal(v); err != nil {
+			return nil, errors.Wrapf(err, "Fail to marshal patched field: %s", name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			fields[name] = json.RawMessage("null")
+		}
+	}
+
+	return fields, nil
+}
+
+// decodeJSON keeps numbers as json.Number so that large integers are not
+// changed by patching.
+func decodeJSON(raw []byte, out interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	return decoder.Decode(out)
+}
+
+// decodePatchField decodes a patched field into out. null sets zero value.
+func decodePatchField(name string, raw json.RawMessage, out interface{}) error {
+	if string(raw) == "null" {
+		v := reflect.ValueOf(out).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, out); err != nil {
+		return newUserError(400, "Invalid value of '%s': %s", name, raw).setCause(err)
+	}
+
+	return nil
+}
+
+// unchangeableFieldError is for a patch to a field that is not allowed to
+// change such as ID.
+func unchangeableFieldError(name string) error {
+	return newUserError(400, "Field '%s' can not be changed", name)
+}
+
+// --------------------------------
+// JSON Merge Patch (RFC 7396)
+// --------------------------------
+
+// mergePatch returns target merged with patch. target is not modified.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	merged := map[string]interface{}{}
+	if targetObj, ok := target.(map[string]interface{}); ok {
+		for k, v := range targetObj {
+			merged[k] = v
+		}
+	}
+
+	for k, v := range patchObj {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = mergePatch(merged[k], v)
+		}
+	}
+
+	return merged
+}
+
+// --------------------------------
+// JSON Patch (RFC 6902)
+// --------------------------------
+
+type jsonPatchOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from"`
+	// Value is nil if not given, and "null" for null.
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies operations in order. doc is modified.
+func applyJSONPatch(doc interface{}, ops []jsonPatchOp) (interface{}, error) {
+	for i, op := range ops {
+		var err error
+		if doc, err = op.apply(doc); err != nil {
+			var userErr *userError
+			if errors.As(err, &userErr) {
+				userErr.msg = "Operation " + strconv.Itoa(i) + ": " + userErr.msg
+			}
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func (x jsonPatchOp) value() (interface{}, error) {
+	if x.Value == nil {
+		return nil, newUserError(400, "'%s' requires value", x.Op)
+	}
+
+	var v interface{}
+	if err := decodeJSON(x.Value, &v); err != nil {
+		return nil, newUserError(400, "Invalid value: %s", err).setCause(err)
+	}
+	return v, nil
+}
+
+func (x jsonPatchOp) apply(doc interface{}) (interface{}, error) {
+	path, err := parseJSONPointer(x.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x.Op {
+	case "add", "replace":
+		v, err := x.value()
+		if err != nil {
+			return nil, err
+		}
+		if x.Op == "replace" {
+			if _, err := getJSONPointer(doc, path); err != nil {
+				return nil, err
+			}
+			if len(path) > 0 {
+				if doc, err = removeJSONPointer(doc, path); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return addJSONPointer(doc, path, v)
+
+	case "remove":
+		return removeJSONPointer(doc, path)
+
+	case "move", "copy":
+		from, err := parseJSONPointer(x.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := getJSONPointer(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if x.Op == "move" {
+			if strings.HasPrefix(x.Path, x.From+"/") {
+				return nil, newUserError(400, "Can not move '%s' into its child", x.From)
+			}
+			if doc, err = removeJSONPointer(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			// Copied value must not share maps and slices with the source.
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return nil, errors.Wrap(err, "Fail to copy value")
+			}
+			if err := decodeJSON(raw, &v); err != nil {
+				return nil, errors.Wrap(err, "Fail to copy value")
+			}
+		}
+		return addJSONPointer(doc, path, v)
+
+	case "test":
+		v, err := x.value()
+		if err != nil {
+			return nil, err
+		}
+		actual, err := getJSONPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(v, actual) {
+			return nil, newUserError(409, "Test of '%s' failed", x.Path)
+		}
+		return doc, nil
+
+	default:
+		return nil, newUserError(400, "Invalid operation: '%s'", x.Op)
+	}
+}
+
+// parseJSONPointer splits a JSON Pointer (RFC 6901) into tokens. The root is
+// an empty pointer.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, newUserError(400, "Invalid path: '%s'", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses token as an index of array of size. "-" is size, the end
+// of array, if end is allowed.
+func arrayIndex(token string, size int, end bool) (int, error) {
+	if token == "-" && end {
+		return size, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, newUserError(400, "Invalid array index: '%s'", token)
+	}
+
+	limit := size - 1
+	if end {
+		limit = size
+	}
+	if i > limit {
+		return 0, newUserError(400, "Array index out of range: %d", i)
+	}
+	return i, nil
+}
+
+func getJSONPointer(doc interface{}, path []string) (interface{}, error) {
+	node := doc
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[token]
+			if !ok {
+				return nil, newUserError(400, "Path not found: '%s'", token)
+			}
+			node = v
+
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+
+		default:
+			return nil, newUserError(400, "Path not found: '%s'", token)
+		}
+	}
+
+	return node, nil
+}
+
+// updateJSONPointer calls f with the parent of the location and the last
+// token, and replaces the parent with the result of f. It returns new doc.
+func updateJSONPointer(doc interface{}, path []string, f func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return f(doc, path[0])
+	}
+
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, newUserError(400, "Path not found: '%s'", path[0])
+		}
+		updated, err := updateJSONPointer(child, path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = updated
+		return n, nil
+
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateJSONPointer(n[i], path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+
+	default:
+		return nil, newUserError(400, "Path not found: '%s'", path[0])
+	}
+}
+
+func addJSONPointer(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+
+	return updateJSONPointer(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			n[token] = v
+			return n, nil
+
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = v
+			return n, nil
+
+		default:
+			return nil, newUserError(400, "Can not add to '%s'", token)
+		}
+	})
+}
+
+func removeJSONPointer(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, newUserError(400, "Can not remove the whole data")
+	}
+
+	return updateJSONPointer(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := n[token]; !ok {
+				return nil, newUserError(400, "Path not found: '%s'", token)
+			}
+			delete(n, token)
+			return n, nil
+
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(n[:i], n[i+1:]...), nil
+
+		default:
+			return nil, newUserError(400, "Path not found: '%s'", token)
+		}
+	})
+}
@@ -2,13 +2,36 @@ package api
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/guregu/dynamo"
 	"github.com/pkg/errors"
 )
 
+// Status of pomodoro.
+const (
+	PomodoroStarted  = "started"
+	PomodoroFinished = "finished"
+	// PomodoroAbandoned means the pomodoro was stopped by an interruption.
+	PomodoroAbandoned = "abandoned"
+)
+
+type InterruptionType string
+
+const (
+	// InternalInterruption is caused by oneself, e.g. a sudden idea.
+	InternalInterruption InterruptionType = "internal"
+	// ExternalInterruption is caused by others, e.g. a phone call.
+	ExternalInterruption InterruptionType = "external"
+)
+
+type Interruption struct {
+	Type      InterruptionType `dynamo:"type" json:"type" binding:"required,oneof=internal external"`
+	Timestamp time.Time        `dynamo:"timestamp" json:"timestamp"`
+	Note      string           `dynamo:"note" json:"note" binding:"max=1000"`
+}
+
 type Pomodoro struct {
 	PKey       string `dynamo:"pk"`
 	SKey       string `dynamo:"sk"`
@@ -17,9 +40,42 @@ type Pomodoro struct {
 	Status     string    `dynamo:"status"`
 	StartedAt  time.Time `dynamo:"started_at"`
 	FinishedAt time.Time `dynamo:"finished_at"`
+	// Deadline is zero for pomodoros created before durations were
+	// configurable. DefaultPomodoroDuration is applied to them.
+	Deadline time.Time `dynamo:"deadline,omitempty"`
 
-	table   dynamo.Table
+	Interruptions []Interruption `dynamo:"interruptions,omitempty"`
+	Version       int            `dynamo:"version"`
+
+	indexKeys
+
+	store   Store
+	broker  Broker
 	deleted bool
+	// expired is true if the pomodoro has been finished by expire and the
+	// change is not saved yet.
+	expired bool
+}
+
+// activePomodoro is an index item of a started pomodoro sorted by deadline,
+// to find overdue pomodoros of all users.
+type activePomodoro struct {
+	PKey       string `dynamo:"pk"`
+	SKey       string `dynamo:"sk"`
+	PomodoroPK string `dynamo:"pomodoro_pk"`
+	PomodoroSK string `dynamo:"pomodoro_sk"`
+	// UserID and Date are to refresh DailyStats. They are empty in items
+	// created before stats were introduced.
+	UserID string    `dynamo:"user_id,omitempty"`
+	Date   time.Time `dynamo:"date,omitempty"`
+}
+
+const activePomodoroPartition = "pomodoro/active"
+
+func toActivePomodoroKey(deadline time.Time, pomodoroID string) (string, string) {
+	pk := activePomodoroPartition
+	sk := fmt.Sprintf("%s/%s", deadline.UTC().Format("20060102T150405"), pomodoroID)
+	return pk, sk
 }
 
 func toPomodoroKey(userID string, date time.Time, taskID string, pomodoroID string) (string, string) {
@@ -31,14 +87,24 @@ func toPomodoroKey(userID string, date time.Time, taskID string, pomodoroID stri
 func (x *KitchenManager) fetchAllPomodoros(userID string, date time.Time) ([]Pomodoro, error) {
 	pk, _ := toPomodoroKey(userID, date, "", "")
 	var pomodoros []Pomodoro
-	if err := x.table.Get("pk", pk).All(&pomodoros); err != nil {
+	if err := x.store.Query(pk, AnySortKey(), &pomodoros); err != nil {
 		return nil, errors.Wrapf(err, "Fail to fetch all pomodoros: %s", pk)
 	}
 
-	return pomodoros, nil
+	// Breaks are also in the partition.
+	now := time.Now().UTC()
+	var results []Pomodoro
+	for _, p := range pomodoros {
+		if strings.HasPrefix(p.SKey, breakKeyPrefix) {
+			continue
+		}
+		p.expire(now)
+		results = append(results, p)
+	}
+	return results, nil
 }
 
-func newPomodoro(task *Task) (*Pomodoro, error) {
+func newPomodoro(task *Task, duration time.Duration) (*Pomodoro, error) {
 	pID := uuid.New().String()
 	pk, sk := toPomodoroKey(task.UserID, task.CreatedAt, task.TaskID, pID)
 	p := new(Pomodoro)
@@ -46,15 +112,24 @@ func newPomodoro(task *Task) (*Pomodoro, error) {
 	p.PKey = pk
 	p.SKey = sk
 	p.PomodoroID = pID
-	p.Status = "started"
+	p.Status = PomodoroStarted
 	p.StartedAt = time.Now().UTC()
+	p.Deadline = p.StartedAt.Add(duration)
+	p.indexKeys = newIndexKeys(indexKindPomodoro, task.UserID, task.CreatedAt, pID)
+
+	p.store, p.broker = task.store, task.broker
 
-	p.table = task.table
+	active := activePomodoro{PomodoroPK: pk, PomodoroSK: sk, UserID: task.UserID, Date: task.CreatedAt}
+	active.PKey, active.SKey = toActivePomodoroKey(p.Deadline, p.PomodoroID)
+	if err := p.store.Put(active); err != nil {
+		return p, errors.Wrapf(err, "Fail to put active pomodoro: %s, %s", active.PKey, active.SKey)
+	}
 
-	if err := p.table.Put(p).Run(); err != nil {
+	if err := putVersioned(p.store, p, &p.Version); err != nil {
 		return p, errors.Wrapf(err, "Fail to put a new promodoro: %s, %s", pk, sk)
 	}
 
+	emitEvent(p.store, p.broker, task.UserID, task.CreatedAt, EventPomodoroStarted, p)
 	return p, nil
 }
 
@@ -62,43 +137,159 @@ func fetchPomodoros(task *Task) ([]Pomodoro, error) {
 	var pomodoros []Pomodoro
 	pk, sk := toPomodoroKey(task.UserID, task.CreatedAt, task.TaskID, "")
 
-	if err := task.table.Get("pk", pk).Range("sk", dynamo.BeginsWith, sk).All(&pomodoros); err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
-		}
-
+	if err := task.store.Query(pk, SortKeyBeginsWith(sk), &pomodoros); err != nil {
 		return nil, errors.Wrap(err, "Fail to get task")
 	}
 
+	now := time.Now().UTC()
+	for i := range pomodoros {
+		pomodoros[i].expire(now)
+	}
 	return pomodoros, nil
 }
 
 func getPomodoro(task *Task, pomodoroID string) (*Pomodoro, error) {
 	var pomodoro Pomodoro
 	pk, sk := toPomodoroKey(task.UserID, task.CreatedAt, task.TaskID, pomodoroID)
-	if err := task.table.Get("pk", pk).Range("sk", dynamo.Equal, sk).One(&pomodoro); err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
+	if err := task.store.Get(pk, sk, &pomodoro); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Pomodoro not found: %s", pomodoroID)
 		}
 
 		return nil, errors.Wrapf(err, "Fail to get a pomodoro: %s %s", pk, sk)
 	}
 
+	pomodoro.store, pomodoro.broker = task.store, task.broker
+	pomodoro.expire(time.Now().UTC())
 	return &pomodoro, nil
 }
 
+func (x *Pomodoro) deadline() time.Time {
+	if x.Deadline.IsZero() {
+		return x.StartedAt.Add(DefaultPomodoroDuration)
+	}
+	return x.Deadline
+}
+
+// expire finishes the pomodoro at the deadline if it is overdue. It returns
+// true if the status is changed. The change is not saved.
+func (x *Pomodoro) expire(now time.Time) bool {
+	if x.Status != PomodoroStarted || now.Before(x.deadline()) {
+		return false
+	}
+
+	x.Status = PomodoroFinished
+	x.FinishedAt = x.deadline()
+	x.expired = true
+	return true
+}
+
+func (x *Pomodoro) deactivate() error {
+	pk, sk := toActivePomodoroKey(x.deadline(), x.PomodoroID)
+	if err := x.store.Delete(pk, sk); err != nil {
+		return errors.Wrapf(err, "Fail to delete active pomodoro: %s, %s", pk, sk)
+	}
+
+	return nil
+}
+
 func (x *Pomodoro) Finish() error {
 	if x.deleted {
 		Logger.WithField("pomodoro", x).Fatal("Already deleted")
 	}
 
+	// An overdue pomodoro finishes at the deadline.
+	now := time.Now().UTC()
+	x.expire(now)
+	if x.Status == PomodoroStarted {
+		x.FinishedAt = now
+		x.Status = PomodoroFinished
+	} else if !x.expired {
+		return newUserError(409, "Pomodoro is not running: %s", x.Status)
+	}
+
+	if err := x.save(); err != nil {
+		return err
+	}
+	x.expired = false
+	if err := x.deactivate(); err != nil {
+		return err
+	}
+
+	emitEvent(x.store, x.broker, x.userID(), x.date(), EventPomodoroFinished, x)
+	return nil
+}
+
+func (x *Pomodoro) save() error {
+	if err := putVersioned(x.store, x, &x.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			var current Pomodoro
+			return conflictError(x.store, "Pomodoro", x.PKey, x.SKey, &current)
+		}
+		return errors.Wrapf(err, "Fail to save pomodoro: %s, %s", x.PKey, x.SKey)
+	}
+
+	return nil
+}
+
+func (x *Pomodoro) taskID() string {
+	return strings.SplitN(x.SKey, "/", 2)[0]
+}
+
+func (x *Pomodoro) userID() string {
+	return strings.SplitN(x.PKey, "/", 2)[0]
+}
+
+// date returns the day of the task of the pomodoro.
+func (x *Pomodoro) date() time.Time {
+	parts := strings.Split(x.PKey, "/")
+	date, _ := time.Parse("20060102", parts[len(parts)-1])
+	return date
+}
+
+// Interrupt records an interruption of the running pomodoro. The pomodoro is
+// abandoned if abandon is true.
+func (x *Pomodoro) Interrupt(interruption Interruption, abandon bool) error {
+	if err := validateStruct(&interruption); err != nil {
+		return err
+	}
+	if x.Status != PomodoroStarted {
+		return newUserError(409, "Pomodoro is not running: %s", x.Status)
+	}
+
+	if interruption.Timestamp.IsZero() {
+		interruption.Timestamp = time.Now().UTC()
+	}
+	x.Interruptions = append(x.Interruptions, interruption)
+
+	if abandon {
+		return x.Abandon()
+	}
+
+	if err := x.save(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Abandon stops the running pomodoro without finishing it.
+func (x *Pomodoro) Abandon() error {
+	if x.Status != PomodoroStarted {
+		return newUserError(409, "Pomodoro is not running: %s", x.Status)
+	}
+
+	x.Status = PomodoroAbandoned
 	x.FinishedAt = time.Now().UTC()
-	x.Status = "finished"
 
-	if err := x.table.Put(x).Run(); err != nil {
-		return errors.Wrapf(err, "Fail to update the promodoro to finish: %s, %s", x.PKey, x.SKey)
+	if err := x.save(); err != nil {
+		return err
+	}
+	if err := x.deactivate(); err != nil {
+		return err
 	}
 
+	emitEvent(x.store, x.broker, x.userID(), x.date(), EventPomodoroAbandoned, x)
 	return nil
 }
 
@@ -107,11 +298,118 @@ func (x *Pomodoro) Delete() error {
 		Logger.WithField("pomodoro", x).Fatal("Already deleted")
 	}
 
-	if err := x.table.Delete("pk", x.PKey).Range("sk", x.SKey).Run(); err != nil {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
 		return errors.Wrapf(err, "Fail to delete pomodoro: %s", x.PKey)
 	}
+	if err := x.deactivate(); err != nil {
+		return err
+	}
 
 	x.deleted = true
+	emitEvent(x.store, x.broker, x.userID(), x.date(), EventPomodoroDeleted, x)
 	return nil
+}
+
+// sweepPomodoros finishes and saves overdue pomodoros of all users. It returns
+// number of finished pomodoros.
+func (x KitchenManager) sweepPomodoros(now time.Time) (int, error) {
+	var items []activePomodoro
+	// "~" is greater than any character of pomodoro ID.
+	_, upper := toActivePomodoroKey(now, "~")
+	if err := x.store.Query(activePomodoroPartition, SortKeyBetween("0", upper), &items); err != nil {
+		return 0, errors.Wrap(err, "Fail to fetch active pomodoros")
+	}
+
+	count := 0
+	for _, item := range items {
+		var pomodoro Pomodoro
+		if err := x.store.Get(item.PomodoroPK, item.PomodoroSK, &pomodoro); err == nil {
+			pomodoro.store, pomodoro.broker = x.store, x.broker
+			if pomodoro.Status == PomodoroStarted && !pomodoro.expire(now) {
+				// Deadline is later in the same second of the index key. Keep
+				// the index item for the next sweep.
+				continue
+			} else if pomodoro.expired {
+				if err := putVersioned(x.store, &pomodoro, &pomodoro.Version); errors.Is(err, errVersionConflict) {
+					// Changed after read, it is checked again by next sweep.
+					continue
+				} else if err != nil {
+					return count, errors.Wrapf(err, "Fail to finish overdue pomodoro: %s, %s", item.PomodoroPK, item.PomodoroSK)
+				}
+				count++
+
+				emitEvent(x.store, x.broker, pomodoro.userID(), pomodoro.date(), EventPomodoroFinished, &pomodoro)
+
+				if item.UserID != "" {
+					if _, err := x.refreshDailyStats(item.UserID, item.Date); err != nil {
+						return count, err
+					}
+				}
+			}
+		} else if !errors.Is(err, errItemNotFound) {
+			return count, errors.Wrapf(err, "Fail to get a pomodoro: %s %s", item.PomodoroPK, item.PomodoroSK)
+		}
+
+		if err := x.store.Delete(item.PKey, item.SKey); err != nil {
+			return count, errors.Wrapf(err, "Fail to delete active pomodoro: %s, %s", item.PKey, item.SKey)
+		}
+	}
+
+	return count, nil
+}
+
+// FocusStats is summary of pomodoros and interruptions.
+type FocusStats struct {
+	Pomodoros             int `json:"pomodoros"`
+	Finished              int `json:"finished"`
+	Abandoned             int `json:"abandoned"`
+	InternalInterruptions int `json:"internal_interruptions"`
+	ExternalInterruptions int `json:"external_interruptions"`
+	// FocusedMinutes is total time of finished and abandoned pomodoros.
+	FocusedMinutes int `json:"focused_minutes"`
+}
+
+func (x *FocusStats) add(p *Pomodoro) {
+	x.Pomodoros++
+	switch p.Status {
+	case PomodoroFinished:
+		x.Finished++
+		x.FocusedMinutes += int(p.FinishedAt.Sub(p.StartedAt) / time.Minute)
+	case PomodoroAbandoned:
+		x.Abandoned++
+		x.FocusedMinutes += int(p.FinishedAt.Sub(p.StartedAt) / time.Minute)
+	}
+
+	for _, i := range p.Interruptions {
+		switch i.Type {
+		case InternalInterruption:
+			x.InternalInterruptions++
+		case ExternalInterruption:
+			x.ExternalInterruptions++
+		}
+	}
+}
+
+// focusStats returns stats of the day and stats of each task.
+func (x KitchenManager) focusStats(userID string, date time.Time) (*FocusStats, map[string]*FocusStats, error) {
+	pomodoros, err := x.fetchAllPomodoros(userID, date)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	total := &FocusStats{}
+	byTask := map[string]*FocusStats{}
+	for i := range pomodoros {
+		p := &pomodoros[i]
+		total.add(p)
+
+		stats, ok := byTask[p.taskID()]
+		if !ok {
+			stats = &FocusStats{}
+			byTask[p.taskID()] = stats
+		}
+		stats.add(p)
+	}
 
+	return total, byTask, nil
 }
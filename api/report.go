@@ -1,10 +1,11 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
-	"github.com/guregu/dynamo"
 	"github.com/pkg/errors"
 )
 
@@ -24,9 +25,36 @@ type Report struct {
 	SKey      string       `dynamo:"sk" json:"-"`
 	UserID    string       `dynamo:"user_id" json:"user_id"`
 	CreatedAt time.Time    `dynamo:"created_at" json:"created_at"`
-	Status    ReportStatus `dynamo:"status"`
+	Status    ReportStatus `dynamo:"status" binding:"required,report_status"`
+	// Summary is set only while the report is ReportDone.
+	Summary *TaskSummary `dynamo:"summary,omitempty" json:"summary,omitempty"`
+	// Focus is computed from pomodoros of the day on read, not stored.
+	Focus *FocusStats `dynamo:"-" json:"focus,omitempty"`
+	// Notes is free-form text in Markdown. It is nil if never written.
+	Notes   *ReportNotes `dynamo:"notes,omitempty" json:"notes,omitempty"`
+	Version int          `dynamo:"version" json:"version"`
 
-	table dynamo.Table
+	store  Store
+	broker Broker
+	// savedStatus is Status in the store to find a change to ReportDone.
+	savedStatus ReportStatus
+	// savedNotes is true if Notes in the store is not nil to find notes
+	// cleared.
+	savedNotes bool
+}
+
+// ReportNotes is free-form text of a daily report.
+type ReportNotes struct {
+	Summary   string `dynamo:"summary" json:"summary" binding:"max=10000"`
+	Blockers  string `dynamo:"blockers" json:"blockers" binding:"max=10000"`
+	Learnings string `dynamo:"learnings" json:"learnings" binding:"max=10000"`
+}
+
+// TaskSummary is numbers of tasks of the day. Planned includes all tasks.
+type TaskSummary struct {
+	Planned   int `dynamo:"planned" json:"planned"`
+	Completed int `dynamo:"completed" json:"completed"`
+	Dropped   int `dynamo:"dropped" json:"dropped"`
 }
 
 func toReportKey(userID string, date time.Time) (string, string) {
@@ -39,14 +67,16 @@ func (x KitchenManager) GetReport(userID string, date time.Time) (*Report, error
 	var report Report
 	pk, sk := toReportKey(userID, date)
 
-	if err := x.table.Get("pk", pk).Range("sk", dynamo.Equal, sk).One(&report); err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
+	if err := x.store.Get(pk, sk, &report); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("The report is not found")
 		}
 		return nil, errors.Wrapf(err, "Fail to get report: %s %s", pk, sk)
 	}
 
-	report.table = x.table
+	report.store, report.broker = x.store, x.broker
+	report.savedStatus = report.Status
+	report.savedNotes = report.Notes != nil
 	return &report, nil
 }
 
@@ -55,19 +85,14 @@ func (x KitchenManager) FetchReport(userID string, begin, end time.Time) ([]Repo
 	pk, sk1 := toReportKey(userID, begin)
 	_, sk2 := toReportKey(userID, end)
 
-	err := x.table.Get("pk", pk).
-		Range("sk", dynamo.Between, sk1, sk2).
-		All(&reports)
-
-	if err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
-		}
-		return nil, err
+	if err := x.store.Query(pk, SortKeyBetween(sk1, sk2), &reports); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch reports: %s", pk)
 	}
 
 	for i := range reports {
-		reports[i].table = x.table
+		reports[i].store, reports[i].broker = x.store, x.broker
+		reports[i].savedStatus = reports[i].Status
+		reports[i].savedNotes = reports[i].Notes != nil
 	}
 	return reports, nil
 }
@@ -82,7 +107,8 @@ func (x KitchenManager) NewReport(userID string, date time.Time) (*Report, error
 		UserID:    userID,
 		CreatedAt: date,
 		Status:    ReportEditing,
-		table:     x.table,
+		store:     x.store,
+		broker:    x.broker,
 	}
 
 	if err := report.Save(); err != nil {
@@ -92,22 +118,205 @@ func (x KitchenManager) NewReport(userID string, date time.Time) (*Report, error
 	return &report, nil
 }
 
+func summarizeTasks(store Store, userID string, date time.Time) (*TaskSummary, error) {
+	var tasks []Task
+	pk, _ := toTaskKey(userID, date, "")
+
+	if err := store.Query(pk, AnySortKey(), &tasks); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch tasks: %s", pk)
+	}
+
+	summary := TaskSummary{Planned: len(tasks)}
+	for _, task := range tasks {
+		switch task.Status {
+		case TaskDone:
+			summary.Completed++
+		case TaskDropped:
+			summary.Dropped++
+		}
+	}
+
+	return &summary, nil
+}
+
+// UpdateReportSummary sets task summary to the report if it is ReportDone and
+// clears it otherwise. It does not save the report.
+func (x KitchenManager) UpdateReportSummary(report *Report) error {
+	return updateReportSummary(x.store, report)
+}
+
+func updateReportSummary(store Store, report *Report) error {
+	if report.Status != ReportDone {
+		report.Summary = nil
+		return nil
+	}
+
+	summary, err := summarizeTasks(store, report.UserID, report.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	report.Summary = summary
+	return nil
+}
+
+// refreshReportSummary updates summary of a done report after tasks of the day
+// are changed. The report is saved only if the summary is changed.
+func (x KitchenManager) refreshReportSummary(userID string, date time.Time) error {
+	report, err := x.GetReport(userID, date)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if report.Status != ReportDone {
+		return nil
+	}
+
+	prev := report.Summary
+	if err := x.UpdateReportSummary(report); err != nil {
+		return err
+	}
+	if prev != nil && report.Summary != nil && *prev == *report.Summary {
+		return nil
+	}
+
+	return report.Save()
+}
+
+func (x ReportStatus) valid() bool {
+	return x == ReportEditing || x == ReportWorking || x == ReportDone
+}
+
 func (x *Report) Save() error {
-	if x.Status != ReportEditing && x.Status != ReportWorking && x.Status != ReportDone {
-		return newUserError(400, "Invalid report status: '%s'", x.Status)
+	if err := validateStruct(x); err != nil {
+		return err
 	}
 
-	if err := x.table.Put(x).Run(); err != nil {
+	if err := putVersioned(x.store, x, &x.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			var current Report
+			return conflictError(x.store, "Report", x.PKey, x.SKey, &current)
+		}
 		return errors.Wrapf(err, "Fail to save report: %s", x.PKey)
 	}
 
+	return x.afterSave()
+}
+
+// Patch validates and applies fields changed by a patch. Only the fields are
+// written. version is checked unless it is anyVersion. A change of status is
+// always checked with the version read because summary of tasks is updated
+// with it.
+func (x *Report) Patch(fields map[string]json.RawMessage, version int) error {
+	next := *x
+	set := map[string]interface{}{}
+
+	for name, raw := range fields {
+		switch name {
+		// Status has no JSON tag.
+		case "Status", "status":
+			if err := decodePatchField(name, raw, &next.Status); err != nil {
+				return err
+			}
+			set["status"] = next.Status
+			version = x.Version
+
+		case "notes":
+			if err := decodePatchField(name, raw, &next.Notes); err != nil {
+				return err
+			}
+			set["notes"] = next.Notes
+
+		default:
+			return unchangeableFieldError(name)
+		}
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+	if err := validateStruct(&next); err != nil {
+		return err
+	}
+	if _, ok := set["status"]; ok {
+		if err := updateReportSummary(x.store, &next); err != nil {
+			return err
+		}
+		set["summary"] = next.Summary
+	}
+
+	var updated Report
+	if err := x.store.Update(x.PKey, x.SKey, set, version, &updated); err != nil {
+		switch {
+		case errors.Is(err, errItemNotFound):
+			return newNotFoundError("The report is not found")
+		case errors.Is(err, errVersionConflict):
+			var current Report
+			return conflictError(x.store, "Report", x.PKey, x.SKey, &current)
+		}
+		return errors.Wrapf(err, "Fail to update report: %s", x.PKey)
+	}
+
+	updated.store, updated.broker = x.store, x.broker
+	updated.savedStatus, updated.savedNotes = x.savedStatus, x.savedNotes
+	*x = updated
+	return x.afterSave()
+}
+
+func (x *Report) afterSave() error {
+	if x.Notes != nil {
+		if err := searchIndexOf(x.store).IndexDocument(x.searchDocument()); err != nil {
+			return err
+		}
+	} else if x.savedNotes {
+		if err := searchIndexOf(x.store).RemoveDocument(x.UserID, SearchReport, x.SKey); err != nil {
+			return err
+		}
+	}
+	x.savedNotes = x.Notes != nil
+
+	becameDone := x.Status == ReportDone && x.savedStatus != ReportDone
+	x.savedStatus = x.Status
+	emitEvent(x.store, x.broker, x.UserID, x.CreatedAt, EventReportSaved, x)
+	if becameDone {
+		emitEvent(x.store, x.broker, x.UserID, x.CreatedAt, EventReportDone, x)
+	}
 	return nil
 }
 
+// searchDocument uses the first line of summary as title.
+func (x *Report) searchDocument() SearchDocument {
+	doc := SearchDocument{
+		UserID: x.UserID,
+		Kind:   SearchReport,
+		ID:     x.SKey,
+		Date:   x.CreatedAt,
+	}
+
+	if x.Notes != nil {
+		summary := strings.TrimSpace(x.Notes.Summary)
+		lines := strings.SplitN(summary, "\n", 2)
+		doc.Title = strings.TrimSpace(lines[0])
+		if len(lines) > 1 {
+			doc.Description = lines[1]
+		}
+		doc.Description += "\n" + x.Notes.Blockers + "\n" + x.Notes.Learnings
+	}
+
+	return doc
+}
+
 func (x *Report) Delete() error {
-	if err := x.table.Delete("pk", x.PKey).Range("sk", x.SKey).Run(); err != nil {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
 		return errors.Wrapf(err, "Fail to delete report: %s", x.PKey)
 	}
+	if err := searchIndexOf(x.store).RemoveDocument(x.UserID, SearchReport, x.SKey); err != nil {
+		return err
+	}
 
+	emitEvent(x.store, x.broker, x.UserID, x.CreatedAt, EventReportDeleted, x)
+	// The report can be saved again as a new item.
+	x.Version = 0
 	return nil
 }
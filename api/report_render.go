@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+)
+
+// ReportView is data of a daily report to be rendered.
+type ReportView struct {
+	UserID string
+	Date   string
+	// Status is empty if the report of the day has not been created.
+	Status ReportStatus
+	Notes  ReportNotes
+
+	// CompletedTasks are done tasks. Other tasks except dropped ones are in
+	// OpenTasks.
+	CompletedTasks []Task
+	OpenTasks      []Task
+	DroppedTasks   []Task
+	Chores         []Chore
+	Pomodoros      []Pomodoro
+	Focus          FocusStats
+	// Stats is set only for report templates.
+	Stats *DailyStats
+}
+
+// Interruptions is total of internal and external interruptions.
+func (x ReportView) Interruptions() int {
+	return x.Focus.InternalInterruptions + x.Focus.ExternalInterruptions
+}
+
+// GetReportView collects notes, tasks, chores and pomodoros of the report.
+func (x KitchenManager) GetReportView(report *Report) (*ReportView, error) {
+	return x.reportView(report.UserID, report.CreatedAt, report)
+}
+
+// reportView collects data of the day. report can be nil.
+func (x KitchenManager) reportView(userID string, date time.Time, report *Report) (*ReportView, error) {
+	tasks, err := x.FetchTasks(userID, date)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.attachTaskStats(userID, date, tasks); err != nil {
+		return nil, err
+	}
+	chores, err := x.FetchChores(userID, date)
+	if err != nil {
+		return nil, err
+	}
+	pomodoros, err := x.fetchAllPomodoros(userID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	view := ReportView{
+		UserID:    userID,
+		Date:      date.Format("2006-01-02"),
+		Chores:    chores,
+		Pomodoros: pomodoros,
+	}
+	for i := range pomodoros {
+		view.Focus.add(&pomodoros[i])
+	}
+	if report != nil {
+		view.Status = report.Status
+		if report.Notes != nil {
+			view.Notes = *report.Notes
+		}
+	}
+
+	for _, task := range tasks {
+		switch task.Status {
+		case TaskDone:
+			view.CompletedTasks = append(view.CompletedTasks, task)
+		case TaskDropped:
+			view.DroppedTasks = append(view.DroppedTasks, task)
+		default:
+			view.OpenTasks = append(view.OpenTasks, task)
+		}
+	}
+
+	return &view, nil
+}
+
+var reportFuncs = template.FuncMap{
+	"trim": strings.TrimSpace,
+	"check": func(done bool) string {
+		if done {
+			return "x"
+		}
+		return " "
+	},
+}
+
+// Sections of notes are omitted if they are empty.
+const defaultReportTemplate = `# Daily report {{ .Date }}
+
+- User: {{ .UserID }}
+- Status: {{ .Status }}
+{{- with trim .Notes.Summary }}
+
+## Summary
+
+{{ . }}
+{{- end }}
+
+## Completed tasks
+{{ range .CompletedTasks }}
+- {{ .Title }} ({{ .Focus.Finished }}/{{ .TomatoNum }} pomodoros)
+{{- else }}
+- None
+{{- end }}
+{{- if .OpenTasks }}
+
+## Remaining tasks
+{{ range .OpenTasks }}
+- {{ .Title }} ({{ .Status }}, {{ .Focus.Finished }}/{{ .TomatoNum }} pomodoros)
+{{- end }}
+{{- end }}
+{{- if .DroppedTasks }}
+
+## Dropped tasks
+{{ range .DroppedTasks }}
+- {{ .Title }}
+{{- end }}
+{{- end }}
+{{- if .Chores }}
+
+## Chores
+{{ range .Chores }}
+- [{{ check .Done }}] {{ .Title }}
+{{- end }}
+{{- end }}
+
+## Pomodoros
+
+- Finished: {{ .Focus.Finished }}
+- Abandoned: {{ .Focus.Abandoned }}
+- Focused minutes: {{ .Focus.FocusedMinutes }}
+- Interruptions: {{ .Interruptions }}
+{{- with trim .Notes.Blockers }}
+
+## Blockers
+
+{{ . }}
+{{- end }}
+{{- with trim .Notes.Learnings }}
+
+## Learnings
+
+{{ . }}
+{{- end }}
+`
+
+var defaultReportTmpl = template.Must(template.New("report").Funcs(reportFuncs).Parse(defaultReportTemplate))
+
+// RenderMarkdown renders the report as a Markdown document.
+func (x *ReportView) RenderMarkdown() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := defaultReportTmpl.Execute(&buf, x); err != nil {
+		return nil, errors.Wrapf(err, "Fail to render report: %s %s", x.UserID, x.Date)
+	}
+
+	return buf.Bytes(), nil
+}
+
+var reportPageTmpl = htmltemplate.Must(htmltemplate.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Daily report {{ .Date }} - {{ .UserID }}</title>
+</head>
+<body>
+{{ .Body }}
+</body>
+</html>
+`))
+
+// RenderHTML renders the report as a HTML page converted from Markdown. Raw
+// HTML in notes is not rendered.
+func (x *ReportView) RenderHTML() ([]byte, error) {
+	md, err := x.RenderMarkdown()
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := goldmark.Convert(md, &body); err != nil {
+		return nil, errors.Wrapf(err, "Fail to convert report to HTML: %s %s", x.UserID, x.Date)
+	}
+
+	page := struct {
+		UserID string
+		Date   string
+		Body   htmltemplate.HTML
+	}{x.UserID, x.Date, htmltemplate.HTML(body.String())}
+
+	var buf bytes.Buffer
+	if err := reportPageTmpl.Execute(&buf, page); err != nil {
+		return nil, errors.Wrapf(err, "Fail to render report page: %s %s", x.UserID, x.Date)
+	}
+
+	return buf.Bytes(), nil
+}
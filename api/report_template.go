@@ -0,0 +1,329 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ReportTemplate is a user-defined report written in Go text/template. It is
+// executed with ReportTemplateData.
+type ReportTemplate struct {
+	PKey       string    `dynamo:"pk" json:"-"`
+	SKey       string    `dynamo:"sk" json:"-"`
+	UserID     string    `dynamo:"user_id" json:"user_id"`
+	TemplateID string    `dynamo:"template_id" json:"template_id"`
+	CreatedAt  time.Time `dynamo:"created_at" json:"created_at"`
+	Name       string    `dynamo:"name" json:"name" binding:"required,max=256"`
+	Body       string    `dynamo:"body" json:"body"`
+	// AutoGenerate renders the template for a day when its report becomes
+	// ReportDone, and stores the output as RenderedReport.
+	AutoGenerate bool `dynamo:"auto_generate" json:"auto_generate"`
+
+	store Store
+}
+
+// ReportTemplateData is data given to ReportTemplate. A template rendered for
+// a date has one day in Days.
+type ReportTemplateData struct {
+	UserID string
+	Begin  string
+	End    string
+	// Days has all days of the range, including days without report.
+	Days  []ReportView
+	Stats *Stats
+}
+
+const (
+	// maxReportTemplateSize limits length of template body.
+	maxReportTemplateSize = 64 * 1024
+	// maxRenderRange limits number of days to render a template at once
+	// because all data of each day is read.
+	maxRenderRange = 31
+)
+
+func toReportTemplateKey(userID, templateID string) (string, string) {
+	pk := fmt.Sprintf("%s/report_template", userID)
+	sk := templateID
+	return pk, sk
+}
+
+func (x KitchenManager) NewReportTemplate(userID string) *ReportTemplate {
+	tmpl := ReportTemplate{
+		UserID:     userID,
+		TemplateID: strings.Replace(uuid.New().String(), "-", "", -1),
+		CreatedAt:  time.Now().UTC(),
+		store:      x.store,
+	}
+	tmpl.PKey, tmpl.SKey = toReportTemplateKey(tmpl.UserID, tmpl.TemplateID)
+
+	return &tmpl
+}
+
+func (x KitchenManager) GetReportTemplate(userID, templateID string) (*ReportTemplate, error) {
+	var tmpl ReportTemplate
+	pk, sk := toReportTemplateKey(userID, templateID)
+
+	if err := x.store.Get(pk, sk, &tmpl); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Report template not found: %s", templateID)
+		}
+		return nil, errors.Wrapf(err, "Fail to get report template: %s", templateID)
+	}
+
+	tmpl.store = x.store
+	return &tmpl, nil
+}
+
+func (x KitchenManager) FetchReportTemplates(userID string) ([]ReportTemplate, error) {
+	var templates []ReportTemplate
+	pk, _ := toReportTemplateKey(userID, "")
+
+	if err := x.store.Query(pk, AnySortKey(), &templates); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch report templates: %s", pk)
+	}
+
+	for i := range templates {
+		templates[i].store = x.store
+	}
+	return templates, nil
+}
+
+func (x *ReportTemplate) parse() (*template.Template, error) {
+	return template.New(x.Name).Funcs(reportFuncs).Option("missingkey=error").Parse(x.Body)
+}
+
+func (x *ReportTemplate) Save() error {
+	if err := validateStruct(x); err != nil {
+		return err
+	}
+	if len(x.Body) > maxReportTemplateSize {
+		return newFieldError("body", "max", strconv.Itoa(maxReportTemplateSize),
+			"body should be %d bytes or less", maxReportTemplateSize)
+	}
+	if _, err := x.parse(); err != nil {
+		return newFieldError("body", ruleTemplate, "", "Invalid report template: %s", err).setCause(err)
+	}
+
+	if err := x.store.Put(x); err != nil {
+		return errors.Wrapf(err, "Fail to save report template: %s", x.PKey)
+	}
+
+	return nil
+}
+
+func (x *ReportTemplate) Delete() error {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
+		return errors.Wrapf(err, "Fail to delete report template: %s", x.PKey)
+	}
+
+	return nil
+}
+
+// Render executes the template. An error of execution is a user error because
+// the template is written by the user.
+func (x *ReportTemplate) Render(data *ReportTemplateData) ([]byte, error) {
+	tmpl, err := x.parse()
+	if err != nil {
+		return nil, newUserError(400, "Invalid report template: %s", err).setCause(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, newUserError(400, "Fail to render report template: %s", err).setCause(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ReportTemplateData collects reports, tasks, chores, pomodoros and stats of
+// days from begin to end.
+func (x KitchenManager) ReportTemplateData(userID string, begin, end time.Time) (*ReportTemplateData, error) {
+	if err := checkDateRange(begin, end, maxRenderRange); err != nil {
+		return nil, err
+	}
+
+	reports, err := x.FetchReport(userID, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	byDate := map[string]*Report{}
+	for i := range reports {
+		byDate[reports[i].SKey] = &reports[i]
+	}
+
+	data := ReportTemplateData{
+		UserID: userID,
+		Begin:  begin.Format("2006-01-02"),
+		End:    end.Format("2006-01-02"),
+		Days:   []ReportView{},
+	}
+
+	// Stats are computed instead of reading saved DailyStats because days
+	// without report may not have them.
+	days := map[string]*DailyStats{}
+	for date := begin; !date.After(end); date = date.AddDate(0, 0, 1) {
+		_, sk := toReportKey(userID, date)
+		view, err := x.reportView(userID, date, byDate[sk])
+		if err != nil {
+			return nil, err
+		}
+		if view.Stats, err = x.computeDailyStats(userID, date); err != nil {
+			return nil, err
+		}
+		days[view.Stats.SKey] = view.Stats
+		data.Days = append(data.Days, *view)
+	}
+	data.Stats = aggregateStats(userID, begin, end, days)
+
+	return &data, nil
+}
+
+// --------------------------------
+// Generation
+// --------------------------------
+
+// RenderedReport is an output of ReportTemplate with AutoGenerate for a day.
+type RenderedReport struct {
+	PKey       string    `dynamo:"pk" json:"-"`
+	SKey       string    `dynamo:"sk" json:"-"`
+	UserID     string    `dynamo:"user_id" json:"user_id"`
+	TemplateID string    `dynamo:"template_id" json:"template_id"`
+	Name       string    `dynamo:"name" json:"name" binding:"required,max=256"`
+	Date       string    `dynamo:"date" json:"date"`
+	Body       string    `dynamo:"body" json:"body"`
+	CreatedAt  time.Time `dynamo:"created_at" json:"created_at"`
+}
+
+// reportGeneration is a queue item of a day whose report became ReportDone, to
+// be processed by GenerateReports.
+type reportGeneration struct {
+	PKey   string    `dynamo:"pk"`
+	SKey   string    `dynamo:"sk"`
+	UserID string    `dynamo:"user_id"`
+	Date   time.Time `dynamo:"date"`
+}
+
+const reportGenerationPartition = "report/generation"
+
+func toRenderedReportKey(userID string, date time.Time, templateID string) (string, string) {
+	pk := fmt.Sprintf("%s/rendered_report/%s", userID, date.Format("20060102"))
+	sk := templateID
+	return pk, sk
+}
+
+func toReportGenerationKey(userID string, date time.Time) (string, string) {
+	pk := reportGenerationPartition
+	sk := fmt.Sprintf("%s/%s", userID, date.Format("20060102"))
+	return pk, sk
+}
+
+// FetchRenderedReports returns outputs of templates generated for the day.
+func (x KitchenManager) FetchRenderedReports(userID string, date time.Time) ([]RenderedReport, error) {
+	var reports []RenderedReport
+	pk, _ := toRenderedReportKey(userID, date, "")
+
+	if err := x.store.Query(pk, AnySortKey(), &reports); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch rendered reports: %s", pk)
+	}
+
+	return reports, nil
+}
+
+// requestReportGeneration queues the day of the report to be rendered by
+// GenerateReports. It should be called when the report becomes ReportDone.
+func (x KitchenManager) requestReportGeneration(report *Report) error {
+	item := reportGeneration{UserID: report.UserID, Date: report.CreatedAt}
+	item.PKey, item.SKey = toReportGenerationKey(report.UserID, report.CreatedAt)
+
+	if err := x.store.Put(item); err != nil {
+		return errors.Wrapf(err, "Fail to put report generation: %s %s", item.PKey, item.SKey)
+	}
+
+	return nil
+}
+
+// generateReports renders templates with AutoGenerate for queued days. A
+// template that fails to render is skipped with a warning. It returns number
+// of stored outputs.
+func (x KitchenManager) generateReports() (int, error) {
+	var items []reportGeneration
+	if err := x.store.Query(reportGenerationPartition, AnySortKey(), &items); err != nil {
+		return 0, errors.Wrap(err, "Fail to fetch report generations")
+	}
+
+	count := 0
+	for _, item := range items {
+		n, err := x.generateReport(item.UserID, item.Date)
+		count += n
+		if err != nil {
+			return count, err
+		}
+
+		if err := x.store.Delete(item.PKey, item.SKey); err != nil {
+			return count, errors.Wrapf(err, "Fail to delete report generation: %s %s", item.PKey, item.SKey)
+		}
+	}
+
+	return count, nil
+}
+
+func (x KitchenManager) generateReport(userID string, date time.Time) (int, error) {
+	// The report may be reopened or deleted after it was queued.
+	report, err := x.GetReport(userID, date)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	} else if err != nil || report.Status != ReportDone {
+		return 0, err
+	}
+
+	templates, err := x.FetchReportTemplates(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var data *ReportTemplateData
+	count := 0
+	for _, tmpl := range templates {
+		if !tmpl.AutoGenerate {
+			continue
+		}
+		if data == nil {
+			if data, err = x.ReportTemplateData(userID, date, date); err != nil {
+				return count, err
+			}
+		}
+
+		body, err := tmpl.Render(data)
+		if err != nil {
+			Logger.WithError(err).WithFields(logrus.Fields{
+				"user_id":     userID,
+				"template_id": tmpl.TemplateID,
+			}).Warn("Fail to render report template")
+			continue
+		}
+
+		rendered := RenderedReport{
+			UserID:     userID,
+			TemplateID: tmpl.TemplateID,
+			Name:       tmpl.Name,
+			Date:       date.Format("2006-01-02"),
+			Body:       string(body),
+			CreatedAt:  time.Now().UTC(),
+		}
+		rendered.PKey, rendered.SKey = toRenderedReportKey(userID, date, tmpl.TemplateID)
+		if err := x.store.Put(rendered); err != nil {
+			return count, errors.Wrapf(err, "Fail to save rendered report: %s %s", rendered.PKey, rendered.SKey)
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,114 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/m-mizutani/task-kitchen/api"
+)
+
+func TestReportTemplateRender(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	task, err := mgr.NewTask(uid1, day1)
+	require.NoError(t, err)
+	task.Title, task.Status = "write parser", main.TaskDone
+	require.NoError(t, task.Save())
+	task, err = mgr.NewTask(uid1, day2)
+	require.NoError(t, err)
+	task.Title = "review"
+	require.NoError(t, task.Save())
+
+	report, err := mgr.NewReport(uid1, day1)
+	require.NoError(t, err)
+	report.Notes = &main.ReportNotes{Summary: "good day"}
+	require.NoError(t, report.Save())
+
+	tmpl := mgr.NewReportTemplate(uid1)
+	tmpl.Name = "weekly"
+	tmpl.Body = `{{ range .Days }}{{ .Date }} {{ .Notes.Summary }}:{{ range .CompletedTasks }} {{ .Title }}{{ end }}{{ range .OpenTasks }} ({{ .Title }}){{ end }}
+{{ end }}done {{ .Stats.TasksDone }}/{{ .Stats.Tasks }}`
+	require.NoError(t, tmpl.Save())
+
+	data, err := mgr.ReportTemplateData(uid1, day1, day2)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(data.Days))
+	assert.Equal(t, main.ReportStatus("edit"), data.Days[0].Status)
+	assert.Equal(t, main.ReportStatus(""), data.Days[1].Status)
+
+	out, err := tmpl.Render(data)
+	require.NoError(t, err)
+	assert.Equal(t, "2019-04-01 good day: write parser\n2019-04-02 : (review)\ndone 1/2", string(out))
+
+	_, err = mgr.ReportTemplateData(uid1, day1, day1.AddDate(0, 0, 31))
+	assert.Error(t, err)
+
+	invalid := mgr.NewReportTemplate(uid1)
+	invalid.Name = "invalid"
+	invalid.Body = "{{ range .Days }}"
+	assert.Error(t, invalid.Save())
+
+	// Error of execution is returned by Render.
+	tmpl.Body = "{{ .Unknown }}"
+	require.NoError(t, tmpl.Save())
+	_, err = tmpl.Render(data)
+	assert.Error(t, err)
+}
+
+func TestGenerateReports(t *testing.T) {
+	store := newTestStore()
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	auto := mgr.NewReportTemplate(uid1)
+	auto.Name, auto.Body, auto.AutoGenerate = "daily", "{{ range .Days }}{{ .Date }} {{ .Status }}{{ end }}", true
+	require.NoError(t, auto.Save())
+	manual := mgr.NewReportTemplate(uid1)
+	manual.Name, manual.Body = "manual", "manual"
+	require.NoError(t, manual.Save())
+	broken := mgr.NewReportTemplate(uid1)
+	broken.Name, broken.Body, broken.AutoGenerate = "broken", "{{ .Unknown }}", true
+	require.NoError(t, broken.Save())
+
+	report, err := mgr.NewReport(uid1, day1)
+	require.NoError(t, err)
+	report.Status = main.ReportDone
+	require.NoError(t, report.Save())
+	require.NoError(t, main.RequestReportGeneration(mgr, report))
+
+	_, err = main.GenerateReports(store)
+	require.NoError(t, err)
+
+	rendered, err := mgr.FetchRenderedReports(uid1, day1)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rendered))
+	assert.Equal(t, auto.TemplateID, rendered[0].TemplateID)
+	assert.Equal(t, "2019-04-01 done", rendered[0].Body)
+
+	// The queue is consumed.
+	require.NoError(t, auto.Delete())
+	_, err = main.GenerateReports(store)
+	require.NoError(t, err)
+	rendered, err = mgr.FetchRenderedReports(uid1, day1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(rendered))
+
+	// A report reopened before generation is skipped.
+	day2 := day1.AddDate(0, 0, 1)
+	report, err = mgr.NewReport(uid1, day2)
+	require.NoError(t, err)
+	require.NoError(t, main.RequestReportGeneration(mgr, report))
+	_, err = main.GenerateReports(store)
+	require.NoError(t, err)
+	rendered, err = mgr.FetchRenderedReports(uid1, day2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(rendered))
+}
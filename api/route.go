@@ -4,68 +4,266 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRouter(r *gin.RouterGroup, awsRegion, tableName string) {
-	mgr := newKitchenManager(awsRegion, tableName)
+func SetupRouter(r *gin.RouterGroup, store Store, opts Options) {
+	mgr := newKitchenManager(store)
+	mgr.carryOver = opts.CarryOver
+	mgr.broker = opts.Broker
+	shared := teamRoutes{}
+
+	if len(opts.Authenticators) > 0 {
+		// Personal tokens are checked first because they are also bearer tokens.
+		authenticators := append([]Authenticator{&tokenAuthenticator{mgr: &mgr}}, opts.Authenticators...)
+		r.Use(authMiddleware(authenticators, &mgr, shared))
+	}
+
+	read := requireScope(ScopeRead)
 
 	// Report endpoints
-	r.GET("/:user", func(c *gin.Context) {
+	r.GET("/:user", read, func(c *gin.Context) {
 		handle(fetchReportHandler, c, &mgr)
 	})
-	r.GET("/:user/:date", func(c *gin.Context) {
+	r.GET(shared.share(r, "/:user/:date"), read, func(c *gin.Context) {
 		handle(getReportHandler, c, &mgr)
 	})
-	r.PUT("/:user/:date", func(c *gin.Context) {
+	r.PUT("/:user/:date", requireScope(ScopeReportsWrite), func(c *gin.Context) {
 		handle(updateReportHandler, c, &mgr)
 	})
-	r.DELETE("/:user/:date", func(c *gin.Context) {
+	r.PATCH("/:user/:date", requireScope(ScopeReportsWrite), func(c *gin.Context) {
+		handle(patchReportHandler, c, &mgr)
+	})
+	r.DELETE("/:user/:date", requireScope(ScopeReportsWrite), func(c *gin.Context) {
 		handle(deleteReportHandler, c, &mgr)
 	})
+	r.GET(shared.share(r, "/:user/:date/report.md"), read, func(c *gin.Context) {
+		handle(renderReportMarkdownHandler, c, &mgr)
+	})
+	r.GET(shared.share(r, "/:user/:date/report.html"), read, func(c *gin.Context) {
+		handle(renderReportHTMLHandler, c, &mgr)
+	})
+	r.GET("/:user/:date/rendered", read, func(c *gin.Context) {
+		handle(fetchRenderedReportsHandler, c, &mgr)
+	})
+
+	// Report template endpoints
+	r.GET("/:user/report-templates", read, func(c *gin.Context) {
+		handle(fetchReportTemplatesHandler, c, &mgr)
+	})
+	r.POST("/:user/report-templates", requireScope(ScopeReportsWrite), func(c *gin.Context) {
+		handle(createReportTemplateHandler, c, &mgr)
+	})
+	r.GET("/:user/report-templates/:template_id", read, func(c *gin.Context) {
+		handle(getReportTemplateHandler, c, &mgr)
+	})
+	r.PUT("/:user/report-templates/:template_id", requireScope(ScopeReportsWrite), func(c *gin.Context) {
+		handle(updateReportTemplateHandler, c, &mgr)
+	})
+	r.DELETE("/:user/report-templates/:template_id", requireScope(ScopeReportsWrite), func(c *gin.Context) {
+		handle(deleteReportTemplateHandler, c, &mgr)
+	})
+	r.GET("/:user/report-templates/:template_id/render", read, func(c *gin.Context) {
+		handle(renderReportTemplateHandler, c, &mgr)
+	})
 
 	// Task Endpoint
-	r.GET("/:user/:date/task", func(c *gin.Context) {
+	r.GET(shared.share(r, "/:user/:date/task"), read, func(c *gin.Context) {
 		handle(getTasksHandler, c, &mgr)
 	})
-	r.POST("/:user/:date/task", func(c *gin.Context) {
+	r.POST("/:user/:date/task", requireScope(ScopeTasksWrite), func(c *gin.Context) {
 		handle(createTaskHandler, c, &mgr)
 	})
-	r.PUT("/:user/:date/task/:task_id", func(c *gin.Context) {
+	r.PUT("/:user/:date/task/:task_id", requireScope(ScopeTasksWrite), func(c *gin.Context) {
 		handle(updateTaskHandler, c, &mgr)
 	})
-	r.DELETE("/:user/:date/task/:task_id", func(c *gin.Context) {
+	r.PATCH("/:user/:date/task/:task_id", requireScope(ScopeTasksWrite), func(c *gin.Context) {
+		handle(patchTaskHandler, c, &mgr)
+	})
+	r.DELETE("/:user/:date/task/:task_id", requireScope(ScopeTasksWrite), func(c *gin.Context) {
 		handle(deleteTaskHandler, c, &mgr)
 	})
+	r.GET(shared.share(r, "/:user/:date/task/:task_id/history"), read, func(c *gin.Context) {
+		handle(getTaskHistoryHandler, c, &mgr)
+	})
+	r.GET("/:user/tasks", read, func(c *gin.Context) {
+		handle(fetchTasksInRangeHandler, c, &mgr)
+	})
+	r.GET("/:user/tasks/:task_id", read, func(c *gin.Context) {
+		handle(findTaskHandler, c, &mgr)
+	})
+	r.GET("/:user/search", read, func(c *gin.Context) {
+		handle(searchHandler, c, &mgr)
+	})
+	r.GET("/:user/stats", read, func(c *gin.Context) {
+		handle(getStatsHandler, c, &mgr)
+	})
+	r.GET("/:user/estimates", read, func(c *gin.Context) {
+		handle(getEstimateAccuracyHandler, c, &mgr)
+	})
+	r.POST("/:user/:date/carryover", requireScope(ScopeTasksWrite), func(c *gin.Context) {
+		handle(carryOverHandler, c, &mgr)
+	})
 
 	// Chore endpoints
-	r.GET("/:user/:date/chore", func(c *gin.Context) {
-		handle(fetchChoresHandler, c, &mgr)
+	if opts.Features.Enabled(FeatureChore) {
+		setupChoreRouter(r, &mgr)
+	}
+
+	// Pomodoro Endpoint
+	if opts.Features.Enabled(FeaturePomodoro) {
+		setupPomodoroRouter(r, &mgr, shared)
+	}
+
+	// Token endpoints
+	r.GET("/:user/tokens", read, func(c *gin.Context) {
+		handle(fetchTokensHandler, c, &mgr)
+	})
+	r.POST("/:user/tokens", requireScope(ScopeTokensWrite), func(c *gin.Context) {
+		handle(createTokenHandler, c, &mgr)
+	})
+	r.DELETE("/:user/tokens/:token_id", requireScope(ScopeTokensWrite), func(c *gin.Context) {
+		handle(revokeTokenHandler, c, &mgr)
+	})
+
+	// Webhook endpoints
+	r.GET("/:user/webhooks", read, func(c *gin.Context) {
+		handle(fetchWebhooksHandler, c, &mgr)
+	})
+	r.POST("/:user/webhooks", requireScope(ScopeWebhooksWrite), func(c *gin.Context) {
+		handle(createWebhookHandler, c, &mgr)
+	})
+	r.GET("/:user/webhooks/:webhook_id", read, func(c *gin.Context) {
+		handle(getWebhookHandler, c, &mgr)
 	})
-	r.POST("/:user/:date/chore", func(c *gin.Context) {
-		handle(createChoreHandler, c, &mgr)
+	r.PUT("/:user/webhooks/:webhook_id", requireScope(ScopeWebhooksWrite), func(c *gin.Context) {
+		handle(updateWebhookHandler, c, &mgr)
 	})
-	r.PUT("/:user/:date/chore/:chore_id", func(c *gin.Context) {
-		handle(updateChoreHandler, c, &mgr)
+	r.DELETE("/:user/webhooks/:webhook_id", requireScope(ScopeWebhooksWrite), func(c *gin.Context) {
+		handle(deleteWebhookHandler, c, &mgr)
 	})
-	r.DELETE("/:user/:date/chore/:chore_id", func(c *gin.Context) {
-		handle(deleteChoreHandler, c, &mgr)
+	r.GET("/:user/webhooks/:webhook_id/deliveries", read, func(c *gin.Context) {
+		handle(fetchWebhookDeliveriesHandler, c, &mgr)
+	})
+	r.POST("/:user/webhooks/:webhook_id/ping", requireScope(ScopeWebhooksWrite), func(c *gin.Context) {
+		handle(pingWebhookHandler, c, &mgr)
 	})
 
-	// Pomodoro Endpoint
-	r.GET("/:user/:date/pomodoro", func(c *gin.Context) {
-		handle(fetchAllPomodoroHandler, c, &mgr)
+	// Event stream endpoint
+	r.GET(shared.share(r, "/:user/:date/events"), read, func(c *gin.Context) {
+		streamEventsHandler(c, &mgr)
+	})
+
+	// Team endpoints
+	r.GET("/:user/teams", read, func(c *gin.Context) {
+		handle(fetchTeamsHandler, c, &mgr)
+	})
+	r.POST("/:user/teams", requireScope(ScopeTeamsWrite), func(c *gin.Context) {
+		handle(createTeamHandler, c, &mgr)
+	})
+	r.GET("/:user/teams/:team_id", read, func(c *gin.Context) {
+		handle(getTeamHandler, c, &mgr)
 	})
-	r.GET("/:user/:date/pomodoro/:task_id", func(c *gin.Context) {
-		handle(fetchPomodoroHandler, c, &mgr)
+	r.GET("/:user/teams/:team_id/:date", read, func(c *gin.Context) {
+		handle(getTeamReportStatusHandler, c, &mgr)
 	})
-	r.GET("/:user/:date/pomodoro/:task_id/:pomodoro_id", func(c *gin.Context) {
-		handle(getPomodoroHandler, c, &mgr)
+	r.PUT("/:user/teams/:team_id/members/:member", requireScope(ScopeTeamsWrite), func(c *gin.Context) {
+		handle(putTeamMemberHandler, c, &mgr)
 	})
-	r.POST("/:user/:date/pomodoro/:task_id", func(c *gin.Context) {
-		handle(createPomodoroHandler, c, &mgr)
+	r.DELETE("/:user/teams/:team_id/members/:member", requireScope(ScopeTeamsWrite), func(c *gin.Context) {
+		handle(deleteTeamMemberHandler, c, &mgr)
 	})
-	r.PUT("/:user/:date/pomodoro/:task_id/:pomodoro_id", func(c *gin.Context) {
-		handle(updatePomodoroHandler, c, &mgr)
+	r.POST("/:user/teams/:team_id/accept", requireScope(ScopeTeamsWrite), func(c *gin.Context) {
+		handle(acceptTeamHandler, c, &mgr)
+	})
+}
+
+func setupChoreRouter(r *gin.RouterGroup, mgr *KitchenManager) {
+	write := requireScope(ScopeChoresWrite)
+
+	r.GET("/:user/:date/chore", requireScope(ScopeRead), func(c *gin.Context) {
+		handle(fetchChoresHandler, c, mgr)
+	})
+	r.POST("/:user/:date/chore", write, func(c *gin.Context) {
+		handle(createChoreHandler, c, mgr)
+	})
+	r.PUT("/:user/:date/chore/:chore_id", write, func(c *gin.Context) {
+		handle(updateChoreHandler, c, mgr)
+	})
+	r.PATCH("/:user/:date/chore/:chore_id", write, func(c *gin.Context) {
+		handle(patchChoreHandler, c, mgr)
+	})
+	r.DELETE("/:user/:date/chore/:chore_id", write, func(c *gin.Context) {
+		handle(deleteChoreHandler, c, mgr)
+	})
+
+	r.GET("/:user/chores", requireScope(ScopeRead), func(c *gin.Context) {
+		handle(fetchChoresInRangeHandler, c, mgr)
+	})
+	r.GET("/:user/chores/:chore_id", requireScope(ScopeRead), func(c *gin.Context) {
+		handle(findChoreHandler, c, mgr)
+	})
+
+	r.GET("/:user/chore-templates", requireScope(ScopeRead), func(c *gin.Context) {
+		handle(fetchChoreTemplatesHandler, c, mgr)
+	})
+	r.POST("/:user/chore-templates", write, func(c *gin.Context) {
+		handle(createChoreTemplateHandler, c, mgr)
+	})
+	r.PUT("/:user/chore-templates/:template_id", write, func(c *gin.Context) {
+		handle(updateChoreTemplateHandler, c, mgr)
+	})
+	r.DELETE("/:user/chore-templates/:template_id", write, func(c *gin.Context) {
+		handle(deleteChoreTemplateHandler, c, mgr)
+	})
+}
+
+func setupPomodoroRouter(r *gin.RouterGroup, mgr *KitchenManager, shared teamRoutes) {
+	read, write := requireScope(ScopeRead), requireScope(ScopePomodorosWrite)
+
+	r.GET(shared.share(r, "/:user/:date/pomodoro"), read, func(c *gin.Context) {
+		handle(fetchAllPomodoroHandler, c, mgr)
+	})
+	r.GET(shared.share(r, "/:user/:date/pomodoro/:task_id"), read, func(c *gin.Context) {
+		handle(fetchPomodoroHandler, c, mgr)
+	})
+	r.GET(shared.share(r, "/:user/:date/pomodoro/:task_id/:pomodoro_id"), read, func(c *gin.Context) {
+		handle(getPomodoroHandler, c, mgr)
+	})
+	r.POST("/:user/:date/pomodoro/:task_id", write, func(c *gin.Context) {
+		handle(createPomodoroHandler, c, mgr)
+	})
+	r.PUT("/:user/:date/pomodoro/:task_id/:pomodoro_id", write, func(c *gin.Context) {
+		handle(updatePomodoroHandler, c, mgr)
+	})
+	r.DELETE("/:user/:date/pomodoro/:task_id/:pomodoro_id", write, func(c *gin.Context) {
+		handle(deletePomodoroHandler, c, mgr)
+	})
+	r.POST("/:user/:date/pomodoro/:task_id/:pomodoro_id/interruptions", write, func(c *gin.Context) {
+		handle(interruptPomodoroHandler, c, mgr)
+	})
+
+	r.GET(shared.share(r, "/:user/:date/break"), read, func(c *gin.Context) {
+		handle(fetchBreaksHandler, c, mgr)
+	})
+	r.POST("/:user/:date/break", write, func(c *gin.Context) {
+		handle(startBreakHandler, c, mgr)
+	})
+	r.PUT("/:user/:date/break/:break_id", write, func(c *gin.Context) {
+		handle(finishBreakHandler, c, mgr)
+	})
+	r.DELETE("/:user/:date/break/:break_id", write, func(c *gin.Context) {
+		handle(deleteBreakHandler, c, mgr)
+	})
+	r.GET("/:user/:date/next", read, func(c *gin.Context) {
+		handle(getNextStepHandler, c, mgr)
+	})
+
+	r.GET("/:user/pomodoros", read, func(c *gin.Context) {
+		handle(fetchPomodorosInRangeHandler, c, mgr)
+	})
+
+	r.GET("/:user/settings/pomodoro", read, func(c *gin.Context) {
+		handle(getPomodoroSettingsHandler, c, mgr)
 	})
-	r.DELETE("/:user/:date/pomodoro/:task_id/:pomodoro_id", func(c *gin.Context) {
-		handle(deletePomodoroHandler, c, &mgr)
+	r.PUT("/:user/settings/pomodoro", write, func(c *gin.Context) {
+		handle(updatePomodoroSettingsHandler, c, mgr)
 	})
 }
@@ -0,0 +1,382 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+type SearchKind string
+
+const (
+	SearchTask  SearchKind = "task"
+	SearchChore SearchKind = "chore"
+	// SearchReport is notes of a daily report. ID is date such as 20190401.
+	SearchReport SearchKind = "report"
+)
+
+// SearchDocument is a text of an entity to be searched.
+type SearchDocument struct {
+	UserID      string
+	Kind        SearchKind
+	ID          string
+	Date        time.Time
+	Title       string
+	Description string
+}
+
+// SearchQuery is a condition of SearchIndex.Search. Zero Begin and End mean
+// no limit of date. Empty Kinds means all kinds.
+type SearchQuery struct {
+	Text  string
+	Kinds []SearchKind
+	Begin time.Time
+	End   time.Time
+	Limit int
+}
+
+// SearchHit is a found entity. Hits are sorted by Score in descending order
+// and by Date from newer one for the same score.
+type SearchHit struct {
+	Kind  SearchKind `json:"kind"`
+	ID    string     `json:"id"`
+	Date  string     `json:"date"`
+	Title string     `json:"title"`
+	Score float64    `json:"score"`
+}
+
+// SearchIndex is a full-text index of tasks, chores and reports. A Store can
+// implement it to use a native search engine. Otherwise storeSearchIndex keeps
+// an inverted index in the Store.
+type SearchIndex interface {
+	// IndexDocument adds or replaces a document.
+	IndexDocument(doc SearchDocument) error
+	// RemoveDocument removes a document. Removing a nonexistent document is not
+	// an error.
+	RemoveDocument(userID string, kind SearchKind, id string) error
+	// Search returns documents that have all words of the query text.
+	Search(userID string, q SearchQuery) ([]SearchHit, error)
+}
+
+func searchIndexOf(store Store) SearchIndex {
+	if index, ok := store.(SearchIndex); ok {
+		return index
+	}
+	return &storeSearchIndex{store: store}
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// Words in title are more important than in description.
+	searchTitleWeight       = 3
+	searchDescriptionWeight = 1
+)
+
+// --------------------------------
+// Tokenizer
+// --------------------------------
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// tokenize splits text into lower case words. Japanese, Chinese and Korean
+// text is split into bigrams of characters because words are not separated by
+// spaces.
+func tokenize(text string) []string {
+	var tokens []string
+	var word, cjk []rune
+
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+		switch {
+		case len(cjk) == 1:
+			tokens = append(tokens, string(cjk))
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			if len(word) > 0 {
+				flush()
+			}
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(cjk) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// termWeights returns sum of weights of each token in the document.
+func (x SearchDocument) termWeights() map[string]int {
+	weights := map[string]int{}
+	for _, token := range tokenize(x.Title) {
+		weights[token] += searchTitleWeight
+	}
+	for _, token := range tokenize(x.Description) {
+		weights[token] += searchDescriptionWeight
+	}
+	return weights
+}
+
+// --------------------------------
+// Inverted index in Store
+// --------------------------------
+
+type storeSearchIndex struct {
+	store Store
+}
+
+// searchPosting is an item of inverted index, a token in a document.
+type searchPosting struct {
+	PKey   string     `dynamo:"pk"`
+	SKey   string     `dynamo:"sk"`
+	Kind   SearchKind `dynamo:"kind"`
+	ID     string     `dynamo:"id"`
+	Weight int        `dynamo:"weight"`
+}
+
+// searchEntry records tokens of an indexed document to remove its postings
+// later, and title to show in results.
+type searchEntry struct {
+	PKey   string   `dynamo:"pk"`
+	SKey   string   `dynamo:"sk"`
+	Date   string   `dynamo:"date"`
+	Title  string   `dynamo:"title"`
+	Tokens []string `dynamo:"tokens,omitempty"`
+}
+
+// toSearchPostingKey makes sort key begin with date so that postings can be
+// filtered by date range.
+func toSearchPostingKey(userID, token, date string, kind SearchKind, id string) (string, string) {
+	pk := fmt.Sprintf("%s/search/%s", userID, token)
+	sk := fmt.Sprintf("%s/%s/%s", date, kind, id)
+	return pk, sk
+}
+
+func toSearchEntryKey(userID string, kind SearchKind, id string) (string, string) {
+	pk := fmt.Sprintf("%s/search_entry", userID)
+	sk := fmt.Sprintf("%s/%s", kind, id)
+	return pk, sk
+}
+
+func (x *storeSearchIndex) getEntry(userID string, kind SearchKind, id string) (*searchEntry, error) {
+	var entry searchEntry
+	pk, sk := toSearchEntryKey(userID, kind, id)
+
+	if err := x.store.Get(pk, sk, &entry); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "Fail to get search entry: %s %s", pk, sk)
+	}
+
+	return &entry, nil
+}
+
+func (x *storeSearchIndex) IndexDocument(doc SearchDocument) error {
+	date := doc.Date.Format("20060102")
+	weights := doc.termWeights()
+
+	old, err := x.getEntry(doc.UserID, doc.Kind, doc.ID)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		for _, token := range old.Tokens {
+			if _, ok := weights[token]; ok && old.Date == date {
+				continue
+			}
+			pk, sk := toSearchPostingKey(doc.UserID, token, old.Date, doc.Kind, doc.ID)
+			if err := x.store.Delete(pk, sk); err != nil {
+				return errors.Wrapf(err, "Fail to delete search posting: %s %s", pk, sk)
+			}
+		}
+	}
+
+	entry := searchEntry{Date: date, Title: doc.Title}
+	entry.PKey, entry.SKey = toSearchEntryKey(doc.UserID, doc.Kind, doc.ID)
+	for token, weight := range weights {
+		posting := searchPosting{Kind: doc.Kind, ID: doc.ID, Weight: weight}
+		posting.PKey, posting.SKey = toSearchPostingKey(doc.UserID, token, date, doc.Kind, doc.ID)
+		if err := x.store.Put(posting); err != nil {
+			return errors.Wrapf(err, "Fail to save search posting: %s %s", posting.PKey, posting.SKey)
+		}
+		entry.Tokens = append(entry.Tokens, token)
+	}
+	sort.Strings(entry.Tokens)
+
+	if err := x.store.Put(entry); err != nil {
+		return errors.Wrapf(err, "Fail to save search entry: %s %s", entry.PKey, entry.SKey)
+	}
+
+	return nil
+}
+
+func (x *storeSearchIndex) RemoveDocument(userID string, kind SearchKind, id string) error {
+	entry, err := x.getEntry(userID, kind, id)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	for _, token := range entry.Tokens {
+		pk, sk := toSearchPostingKey(userID, token, entry.Date, kind, id)
+		if err := x.store.Delete(pk, sk); err != nil {
+			return errors.Wrapf(err, "Fail to delete search posting: %s %s", pk, sk)
+		}
+	}
+
+	if err := x.store.Delete(entry.PKey, entry.SKey); err != nil {
+		return errors.Wrapf(err, "Fail to delete search entry: %s %s", entry.PKey, entry.SKey)
+	}
+
+	return nil
+}
+
+func (x *storeSearchIndex) Search(userID string, q SearchQuery) ([]SearchHit, error) {
+	tokens := map[string]bool{}
+	for _, token := range tokenize(q.Text) {
+		tokens[token] = true
+	}
+	if len(tokens) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	cond := AnySortKey()
+	if !q.Begin.IsZero() || !q.End.IsZero() {
+		begin, end := "0", "~"
+		if !q.Begin.IsZero() {
+			begin = q.Begin.Format("20060102")
+		}
+		if !q.End.IsZero() {
+			end = q.End.Format("20060102") + "/~"
+		}
+		cond = SortKeyBetween(begin, end)
+	}
+
+	kinds := map[SearchKind]bool{}
+	for _, kind := range q.Kinds {
+		kinds[kind] = true
+	}
+
+	type candidate struct {
+		hit     SearchHit
+		matched int
+	}
+	candidates := map[string]*candidate{}
+
+	for token := range tokens {
+		var postings []searchPosting
+		pk, _ := toSearchPostingKey(userID, token, "", "", "")
+		if err := x.store.Query(pk, cond, &postings); err != nil {
+			return nil, errors.Wrapf(err, "Fail to query search postings: %s", pk)
+		}
+
+		// Rare words are more important.
+		idf := 1 / math.Log2(1+float64(len(postings)))
+		for _, p := range postings {
+			if len(kinds) > 0 && !kinds[p.Kind] {
+				continue
+			}
+
+			key := string(p.Kind) + "/" + p.ID
+			c, ok := candidates[key]
+			if !ok {
+				c = &candidate{hit: SearchHit{Kind: p.Kind, ID: p.ID, Date: p.SKey[:8]}}
+				candidates[key] = c
+			}
+			c.hit.Score += float64(p.Weight) * idf
+			c.matched++
+		}
+	}
+
+	var hits []SearchHit
+	for _, c := range candidates {
+		if c.matched == len(tokens) {
+			hits = append(hits, c.hit)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Date != hits[j].Date {
+			return hits[i].Date > hits[j].Date
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	for i := range hits {
+		entry, err := x.getEntry(userID, hits[i].Kind, hits[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			hits[i].Title = entry.Title
+		}
+		if d, err := time.Parse("20060102", hits[i].Date); err == nil {
+			hits[i].Date = d.Format("2006-01-02")
+		}
+	}
+
+	if hits == nil {
+		hits = []SearchHit{}
+	}
+	return hits, nil
+}
+
+// --------------------------------
+// KitchenManager
+// --------------------------------
+
+// Search finds tasks, chores and reports of the user by text.
+func (x KitchenManager) Search(userID string, q SearchQuery) ([]SearchHit, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, newUserError(400, "Search text is required")
+	}
+	for _, kind := range q.Kinds {
+		if kind != SearchTask && kind != SearchChore && kind != SearchReport {
+			return nil, newUserError(400, "Invalid search type: '%s'", kind)
+		}
+	}
+	if !q.Begin.IsZero() && !q.End.IsZero() && q.End.Before(q.Begin) {
+		return nil, newFieldError("end", "gtefield", "begin", "end should not be before begin")
+	}
+	if q.Limit > maxSearchLimit {
+		return nil, newFieldError("limit", "max", strconv.Itoa(maxSearchLimit), "limit should be %d or less", maxSearchLimit)
+	}
+
+	return searchIndexOf(x.store).Search(userID, q)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default pomodoro settings.
+const (
+	DefaultPomodoroDuration   = 25 * time.Minute
+	DefaultShortBreakDuration = 5 * time.Minute
+	DefaultLongBreakDuration  = 15 * time.Minute
+	DefaultLongBreakInterval  = 4
+)
+
+// PomodoroSettings is per user configuration of pomodoro timer.
+type PomodoroSettings struct {
+	PKey        string `dynamo:"pk" json:"-"`
+	SKey        string `dynamo:"sk" json:"-"`
+	UserID      string `dynamo:"user_id" json:"user_id"`
+	WorkMinutes int    `dynamo:"work_minutes" json:"work_minutes" binding:"min=1,max=180"`
+	// ShortBreakMinutes and LongBreakMinutes are length of breaks.
+	ShortBreakMinutes int `dynamo:"short_break_minutes" json:"short_break_minutes" binding:"min=1,max=180"`
+	LongBreakMinutes  int `dynamo:"long_break_minutes" json:"long_break_minutes" binding:"min=1,max=180"`
+	// LongBreakInterval is number of work sessions before a long break.
+	LongBreakInterval int `dynamo:"long_break_interval" json:"long_break_interval" binding:"min=1"`
+
+	store Store
+}
+
+func toSettingsKey(userID, name string) (string, string) {
+	pk := fmt.Sprintf("%s/settings", userID)
+	sk := name
+	return pk, sk
+}
+
+// GetPomodoroSettings returns settings of the user, or default settings if
+// the user has not saved them.
+func (x KitchenManager) GetPomodoroSettings(userID string) (*PomodoroSettings, error) {
+	settings := PomodoroSettings{UserID: userID}
+	pk, sk := toSettingsKey(userID, "pomodoro")
+
+	if err := x.store.Get(pk, sk, &settings); err != nil && !errors.Is(err, errItemNotFound) {
+		return nil, errors.Wrapf(err, "Fail to get pomodoro settings: %s", pk)
+	}
+
+	// Settings saved by older version do not have some of fields.
+	if settings.WorkMinutes == 0 {
+		settings.WorkMinutes = int(DefaultPomodoroDuration / time.Minute)
+	}
+	if settings.ShortBreakMinutes == 0 {
+		settings.ShortBreakMinutes = int(DefaultShortBreakDuration / time.Minute)
+	}
+	if settings.LongBreakMinutes == 0 {
+		settings.LongBreakMinutes = int(DefaultLongBreakDuration / time.Minute)
+	}
+	if settings.LongBreakInterval == 0 {
+		settings.LongBreakInterval = DefaultLongBreakInterval
+	}
+
+	settings.PKey, settings.SKey = pk, sk
+	settings.store = x.store
+	return &settings, nil
+}
+
+// WorkDuration is length of a pomodoro.
+func (x *PomodoroSettings) WorkDuration() time.Duration {
+	return time.Duration(x.WorkMinutes) * time.Minute
+}
+
+// BreakDuration is length of the kind of break.
+func (x *PomodoroSettings) BreakDuration(kind BreakKind) time.Duration {
+	if kind == LongBreak {
+		return time.Duration(x.LongBreakMinutes) * time.Minute
+	}
+	return time.Duration(x.ShortBreakMinutes) * time.Minute
+}
+
+func (x *PomodoroSettings) Save() error {
+	if err := validateStruct(x); err != nil {
+		return err
+	}
+
+	if err := x.store.Put(x); err != nil {
+		return errors.Wrapf(err, "Fail to save pomodoro settings: %s", x.PKey)
+	}
+
+	return nil
+}
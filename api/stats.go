@@ -0,0 +1,244 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DailyStats is an aggregate of tasks, chores and pomodoros of a day. It is
+// refreshed when they are changed, so that a range of days can be read by a
+// single query.
+type DailyStats struct {
+	PKey   string `dynamo:"pk" json:"-"`
+	SKey   string `dynamo:"sk" json:"-"`
+	UserID string `dynamo:"user_id" json:"-"`
+	Date   string `dynamo:"date" json:"date"`
+
+	Tasks              int `dynamo:"tasks" json:"tasks"`
+	TasksDone          int `dynamo:"tasks_done" json:"tasks_done"`
+	TasksDropped       int `dynamo:"tasks_dropped" json:"tasks_dropped"`
+	Chores             int `dynamo:"chores" json:"chores"`
+	ChoresDone         int `dynamo:"chores_done" json:"chores_done"`
+	Pomodoros          int `dynamo:"pomodoros" json:"pomodoros"`
+	PomodorosFinished  int `dynamo:"pomodoros_finished" json:"pomodoros_finished"`
+	PomodorosAbandoned int `dynamo:"pomodoros_abandoned" json:"pomodoros_abandoned"`
+	FocusMinutes       int `dynamo:"focus_minutes" json:"focus_minutes"`
+	// Hours is number of pomodoros started in each hour of day in UTC.
+	Hours []int `dynamo:"hours" json:"-"`
+
+	UpdatedAt time.Time `dynamo:"updated_at" json:"-"`
+}
+
+func toDailyStatsKey(userID string, date time.Time) (string, string) {
+	pk := fmt.Sprintf("%s/stats", userID)
+	sk := date.Format("20060102")
+	return pk, sk
+}
+
+// active returns true if something was completed on the day.
+func (x *DailyStats) active() bool {
+	return x.TasksDone > 0 || x.ChoresDone > 0 || x.PomodorosFinished > 0
+}
+
+func (x KitchenManager) computeDailyStats(userID string, date time.Time) (*DailyStats, error) {
+	tasks, err := x.FetchTasks(userID, date)
+	if err != nil {
+		return nil, err
+	}
+	chores, err := x.FetchChores(userID, date)
+	if err != nil {
+		return nil, err
+	}
+	pomodoros, err := x.fetchAllPomodoros(userID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := DailyStats{
+		UserID:    userID,
+		Date:      date.Format("2006-01-02"),
+		Hours:     make([]int, 24),
+		UpdatedAt: time.Now().UTC(),
+	}
+	stats.PKey, stats.SKey = toDailyStatsKey(userID, date)
+
+	for _, task := range tasks {
+		stats.Tasks++
+		switch task.Status {
+		case TaskDone:
+			stats.TasksDone++
+		case TaskDropped:
+			stats.TasksDropped++
+		}
+	}
+
+	for _, chore := range chores {
+		stats.Chores++
+		if chore.Done {
+			stats.ChoresDone++
+		}
+	}
+
+	var focus FocusStats
+	for i := range pomodoros {
+		focus.add(&pomodoros[i])
+		stats.Hours[pomodoros[i].StartedAt.UTC().Hour()]++
+	}
+	stats.Pomodoros = focus.Pomodoros
+	stats.PomodorosFinished = focus.Finished
+	stats.PomodorosAbandoned = focus.Abandoned
+	stats.FocusMinutes = focus.FocusedMinutes
+
+	return &stats, nil
+}
+
+// refreshDailyStats recomputes and saves DailyStats of the day. It should be
+// called after tasks, chores or pomodoros of the day are changed.
+func (x KitchenManager) refreshDailyStats(userID string, date time.Time) (*DailyStats, error) {
+	stats, err := x.computeDailyStats(userID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := x.store.Put(stats); err != nil {
+		return nil, errors.Wrapf(err, "Fail to save daily stats: %s %s", stats.PKey, stats.SKey)
+	}
+
+	return stats, nil
+}
+
+// Stats is an aggregate of DailyStats in a date range.
+type Stats struct {
+	Begin string `json:"begin"`
+	End   string `json:"end"`
+	// Days has all days of the range, including days without activity.
+	Days []DailyStats `json:"days"`
+
+	Tasks              int `json:"tasks"`
+	TasksDone          int `json:"tasks_done"`
+	TasksDropped       int `json:"tasks_dropped"`
+	Chores             int `json:"chores"`
+	ChoresDone         int `json:"chores_done"`
+	Pomodoros          int `json:"pomodoros"`
+	PomodorosFinished  int `json:"pomodoros_finished"`
+	PomodorosAbandoned int `json:"pomodoros_abandoned"`
+	FocusMinutes       int `json:"focus_minutes"`
+
+	// TaskCompletionRate is done tasks / tasks except dropped ones.
+	TaskCompletionRate  float64 `json:"task_completion_rate"`
+	ChoreCompletionRate float64 `json:"chore_completion_rate"`
+	// BusiestHour is hour of day in UTC when the most pomodoros were started.
+	// It is nil if there is no pomodoro.
+	BusiestHour *int `json:"busiest_hour"`
+
+	// A streak is consecutive days when something was completed.
+	// CurrentStreak is the streak that continues to the end of range.
+	CurrentStreak int `json:"current_streak"`
+	LongestStreak int `json:"longest_streak"`
+}
+
+func (x *Stats) add(day *DailyStats) {
+	x.Tasks += day.Tasks
+	x.TasksDone += day.TasksDone
+	x.TasksDropped += day.TasksDropped
+	x.Chores += day.Chores
+	x.ChoresDone += day.ChoresDone
+	x.Pomodoros += day.Pomodoros
+	x.PomodorosFinished += day.PomodorosFinished
+	x.PomodorosAbandoned += day.PomodorosAbandoned
+	x.FocusMinutes += day.FocusMinutes
+}
+
+// FetchStats aggregates DailyStats from begin to end. Days that have a report
+// but no DailyStats, such as days before stats were introduced, are computed
+// and saved at the first call.
+func (x KitchenManager) FetchStats(userID string, begin, end time.Time) (*Stats, error) {
+	if err := checkDateRange(begin, end, maxDateRange); err != nil {
+		return nil, err
+	}
+
+	var items []DailyStats
+	pk, sk1 := toDailyStatsKey(userID, begin)
+	_, sk2 := toDailyStatsKey(userID, end)
+	if err := x.store.Query(pk, SortKeyBetween(sk1, sk2), &items); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch daily stats: %s", pk)
+	}
+
+	days := map[string]*DailyStats{}
+	for i := range items {
+		days[items[i].SKey] = &items[i]
+	}
+
+	reports, err := x.FetchReport(userID, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		if _, ok := days[report.SKey]; ok {
+			continue
+		}
+		day, err := x.refreshDailyStats(userID, report.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		days[day.SKey] = day
+	}
+
+	return aggregateStats(userID, begin, end, days), nil
+}
+
+// aggregateStats sums up DailyStats by sort key from begin to end. Missing
+// days are treated as days without activity.
+func aggregateStats(userID string, begin, end time.Time, days map[string]*DailyStats) *Stats {
+	stats := Stats{
+		Begin: begin.Format("2006-01-02"),
+		End:   end.Format("2006-01-02"),
+		Days:  []DailyStats{},
+	}
+	hours := make([]int, 24)
+	streak := 0
+
+	for date := begin; !date.After(end); date = date.AddDate(0, 0, 1) {
+		_, sk := toDailyStatsKey(userID, date)
+		day, ok := days[sk]
+		if !ok {
+			day = &DailyStats{Date: date.Format("2006-01-02")}
+		}
+
+		stats.add(day)
+		for h, n := range day.Hours {
+			if h < len(hours) {
+				hours[h] += n
+			}
+		}
+
+		if day.active() {
+			streak++
+		} else {
+			streak = 0
+		}
+		if streak > stats.LongestStreak {
+			stats.LongestStreak = streak
+		}
+
+		stats.Days = append(stats.Days, *day)
+	}
+	stats.CurrentStreak = streak
+
+	if planned := stats.Tasks - stats.TasksDropped; planned > 0 {
+		stats.TaskCompletionRate = float64(stats.TasksDone) / float64(planned)
+	}
+	if stats.Chores > 0 {
+		stats.ChoreCompletionRate = float64(stats.ChoresDone) / float64(stats.Chores)
+	}
+	for h, n := range hours {
+		if n > 0 && (stats.BusiestHour == nil || n > hours[*stats.BusiestHour]) {
+			hour := h
+			stats.BusiestHour = &hour
+		}
+	}
+
+	return &stats
+}
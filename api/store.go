@@ -0,0 +1,269 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	"github.com/pkg/errors"
+)
+
+const (
+	keyPartition = "pk"
+	keySort      = "sk"
+	// keyVersion is a number attribute that is incremented by every write of
+	// a versioned item. See Store.PutVersion.
+	keyVersion = "version"
+	// anyVersion skips version check of Store.Update.
+	anyVersion = -1
+)
+
+// Errors of Store. Use errors.Is because they may be wrapped. Other errors of
+// the backend are ErrRateLimited or ErrBackendUnavailable if they are
+// temporary.
+var (
+	errItemNotFound    = withKind(ErrNotFound, errors.New("item not found"))
+	errVersionConflict = withKind(ErrConflict, errors.New("version conflict"))
+)
+
+// Store is a storage backend of KitchenManager. All items are identified by
+// a pair of partition key (pk) and sort key (sk) and encoded with dynamo tags.
+type Store interface {
+	// Get retrieves an item into out. It returns errItemNotFound if no item.
+	Get(pk, sk string, out interface{}) error
+	// Query retrieves items in the partition into out, a pointer to a slice.
+	// Items are sorted by sort key in ascending order.
+	Query(pk string, cond SortKeyCond, out interface{}) error
+	// Put creates or replaces an item.
+	Put(item interface{}) error
+	// PutVersion creates or replaces an item only if the stored item has the
+	// version attribute equal to version. Version 0 also matches no item and
+	// an item without the attribute. It returns errVersionConflict if not
+	// matched.
+	PutVersion(item interface{}, version int) error
+	// Update changes attributes of an existing item atomically and increments
+	// its version. set maps attribute names to values encoded as fields of
+	// items, and an attribute with nil or empty value is removed. The version
+	// is checked as PutVersion unless it is anyVersion. The updated item is
+	// read into out. It returns errItemNotFound if no item.
+	Update(pk, sk string, set map[string]interface{}, version int, out interface{}) error
+	// Delete removes an item. Deleting a nonexistent item is not an error.
+	Delete(pk, sk string) error
+	// QueryIndex retrieves items by a secondary index into out, a pointer to a
+	// slice. Items are sorted by sort key of the index in ascending order.
+	QueryIndex(index Index, pk string, cond SortKeyCond, out interface{}) error
+}
+
+// Scanner is implemented by a Store that can iterate all items. It is for
+// maintenance jobs such as BackfillIndex, not for request handling.
+type Scanner interface {
+	// Scan calls f with partition and sort key of every item. Items may be
+	// modified in f.
+	Scan(f func(pk, sk string) error) error
+}
+
+// Index is a global secondary index of the table. Only items that have the
+// key attributes of the index are included.
+type Index struct {
+	Name string
+	PKey string
+	// SKey is empty if the index has no range key.
+	SKey string
+}
+
+type sortKeyOp int
+
+const (
+	sortKeyAny sortKeyOp = iota
+	sortKeyEqual
+	sortKeyBeginsWith
+	sortKeyBetween
+)
+
+// SortKeyCond is a condition on sort key in a partition for Store.Query.
+type SortKeyCond struct {
+	op     sortKeyOp
+	values []string
+}
+
+// AnySortKey matches all items in a partition.
+func AnySortKey() SortKeyCond {
+	return SortKeyCond{op: sortKeyAny}
+}
+
+// SortKeyEqual matches an item that has exactly the sort key.
+func SortKeyEqual(sk string) SortKeyCond {
+	return SortKeyCond{op: sortKeyEqual, values: []string{sk}}
+}
+
+// SortKeyBeginsWith matches items whose sort key has the prefix.
+func SortKeyBeginsWith(prefix string) SortKeyCond {
+	return SortKeyCond{op: sortKeyBeginsWith, values: []string{prefix}}
+}
+
+// SortKeyBetween matches items whose sort key is in [begin, end].
+func SortKeyBetween(begin, end string) SortKeyCond {
+	return SortKeyCond{op: sortKeyBetween, values: []string{begin, end}}
+}
+
+// start returns the lowest sort key that can satisfy the condition. Items
+// matching the condition are contiguous from start in sort key order.
+func (x SortKeyCond) start() string {
+	if x.op == sortKeyAny {
+		return ""
+	}
+	return x.values[0]
+}
+
+// Match returns true if sk satisfies the condition.
+func (x SortKeyCond) Match(sk string) bool {
+	switch x.op {
+	case sortKeyEqual:
+		return sk == x.values[0]
+	case sortKeyBeginsWith:
+		return strings.HasPrefix(sk, x.values[0])
+	case sortKeyBetween:
+		return x.values[0] <= sk && sk <= x.values[1]
+	default:
+		return true
+	}
+}
+
+// --------------------------------
+// Helpers for non-DynamoDB backends
+// --------------------------------
+
+func marshalStoreItem(item interface{}) (string, string, map[string]*dynamodb.AttributeValue, error) {
+	attrs, err := dynamo.MarshalItem(item)
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "Fail to marshal item")
+	}
+
+	pk, sk := attrs[keyPartition], attrs[keySort]
+	if pk == nil || pk.S == nil || sk == nil || sk.S == nil {
+		return "", "", nil, errors.New("Item must have both of pk and sk")
+	}
+
+	return *pk.S, *sk.S, attrs, nil
+}
+
+// matchVersion returns true if PutVersion with version can replace the stored
+// item. attrs is nil if there is no item.
+func matchVersion(attrs map[string]*dynamodb.AttributeValue, version int) bool {
+	v := attrs[keyVersion]
+	if v == nil || v.N == nil {
+		return version == 0
+	}
+
+	n, err := strconv.Atoi(*v.N)
+	return err == nil && n == version
+}
+
+// applyUpdate returns a copy of attrs with values of set and incremented
+// version for Store.Update.
+func applyUpdate(attrs map[string]*dynamodb.AttributeValue, set map[string]interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	updated := make(map[string]*dynamodb.AttributeValue, len(attrs)+len(set))
+	for name, v := range attrs {
+		updated[name] = v
+	}
+
+	for name, value := range set {
+		if name == keyPartition || name == keySort || name == keyVersion {
+			return nil, errors.Errorf("Attribute can not be updated: %s", name)
+		}
+
+		var v *dynamodb.AttributeValue
+		if value != nil {
+			var err error
+			if v, err = dynamo.Marshal(value); err != nil {
+				return nil, errors.Wrapf(err, "Fail to marshal attribute: %s", name)
+			}
+		}
+
+		if v == nil {
+			delete(updated, name)
+		} else {
+			updated[name] = v
+		}
+	}
+
+	version := 0
+	if v := attrs[keyVersion]; v != nil && v.N != nil {
+		version, _ = strconv.Atoi(*v.N)
+	}
+	n := strconv.Itoa(version + 1)
+	updated[keyVersion] = &dynamodb.AttributeValue{N: &n}
+
+	return updated, nil
+}
+
+func appendStoreItem(attrs map[string]*dynamodb.AttributeValue, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("Query result must be a pointer to slice")
+	}
+
+	slice := rv.Elem()
+	v := reflect.New(slice.Type().Elem())
+	if err := dynamo.UnmarshalItem(attrs, v.Interface()); err != nil {
+		return errors.Wrap(err, "Fail to unmarshal item")
+	}
+
+	slice.Set(reflect.Append(slice, v.Elem()))
+	return nil
+}
+
+func stringAttr(attrs map[string]*dynamodb.AttributeValue, name string) (string, bool) {
+	v := attrs[name]
+	if v == nil || v.S == nil {
+		return "", false
+	}
+	return *v.S, true
+}
+
+// indexEntry is an item found by Store.QueryIndex of a backend that does not
+// have native secondary indexes.
+type indexEntry struct {
+	key   string
+	attrs map[string]*dynamodb.AttributeValue
+}
+
+// match returns an indexEntry if the item is in the index partition pk and
+// satisfies cond.
+func (x Index) match(attrs map[string]*dynamodb.AttributeValue, pk string, cond SortKeyCond) (*indexEntry, bool) {
+	if v, ok := stringAttr(attrs, x.PKey); !ok || v != pk {
+		return nil, false
+	}
+
+	itemPK, _ := stringAttr(attrs, keyPartition)
+	itemSK, _ := stringAttr(attrs, keySort)
+	// Items that have the same index sort key are ordered by primary key.
+	key := itemPK + "\x00" + itemSK
+
+	if x.SKey != "" {
+		sk, ok := stringAttr(attrs, x.SKey)
+		if !ok || !cond.Match(sk) {
+			return nil, false
+		}
+		key = sk + "\x00" + key
+	}
+
+	return &indexEntry{key: key, attrs: attrs}, true
+}
+
+func appendIndexEntries(entries []indexEntry, out interface{}) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	for _, entry := range entries {
+		if err := appendStoreItem(entry.attrs, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore is a Store backed by a single bbolt database file. Each partition
+// key is a bucket and each sort key is a key in the bucket, so that items keep
+// the same pk/sk layout as the DynamoDB table.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) a database file at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Fail to open database file: %s", path)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the database file.
+func (x *BoltStore) Close() error {
+	return x.db.Close()
+}
+
+func (x *BoltStore) Get(pk, sk string, out interface{}) error {
+	return x.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(pk))
+		if bucket == nil {
+			return errItemNotFound
+		}
+
+		raw := bucket.Get([]byte(sk))
+		if raw == nil {
+			return errItemNotFound
+		}
+
+		return decodeBoltItem(raw, func(attrs map[string]*dynamodb.AttributeValue) error {
+			return dynamo.UnmarshalItem(attrs, out)
+		})
+	})
+}
+
+func (x *BoltStore) Query(pk string, cond SortKeyCond, out interface{}) error {
+	return x.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(pk))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.Seek([]byte(cond.start())); k != nil && cond.Match(string(k)); k, v = c.Next() {
+			err := decodeBoltItem(v, func(attrs map[string]*dynamodb.AttributeValue) error {
+				return appendStoreItem(attrs, out)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "Fail to query items: %s", pk)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (x *BoltStore) Put(item interface{}) error {
+	pk, sk, attrs, err := marshalStoreItem(item)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to encode item: %s %s", pk, sk)
+	}
+
+	return x.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(pk))
+		if err != nil {
+			return errors.Wrapf(err, "Fail to create bucket: %s", pk)
+		}
+
+		return bucket.Put([]byte(sk), raw)
+	})
+}
+
+func (x *BoltStore) PutVersion(item interface{}, version int) error {
+	pk, sk, attrs, err := marshalStoreItem(item)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to encode item: %s %s", pk, sk)
+	}
+
+	return x.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(pk))
+		if err != nil {
+			return errors.Wrapf(err, "Fail to create bucket: %s", pk)
+		}
+
+		var stored map[string]*dynamodb.AttributeValue
+		if old := bucket.Get([]byte(sk)); old != nil {
+			if err := json.Unmarshal(old, &stored); err != nil {
+				return errors.Wrapf(err, "Fail to decode item: %s %s", pk, sk)
+			}
+		}
+		if !matchVersion(stored, version) {
+			return errVersionConflict
+		}
+
+		return bucket.Put([]byte(sk), raw)
+	})
+}
+
+func (x *BoltStore) Update(pk, sk string, set map[string]interface{}, version int, out interface{}) error {
+	return x.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(pk))
+		if bucket == nil {
+			return errItemNotFound
+		}
+		old := bucket.Get([]byte(sk))
+		if old == nil {
+			return errItemNotFound
+		}
+
+		return decodeBoltItem(old, func(attrs map[string]*dynamodb.AttributeValue) error {
+			if version != anyVersion && !matchVersion(attrs, version) {
+				return errVersionConflict
+			}
+
+			updated, err := applyUpdate(attrs, set)
+			if err != nil {
+				return err
+			}
+			raw, err := json.Marshal(updated)
+			if err != nil {
+				return errors.Wrapf(err, "Fail to encode item: %s %s", pk, sk)
+			}
+			if err := bucket.Put([]byte(sk), raw); err != nil {
+				return err
+			}
+
+			return dynamo.UnmarshalItem(updated, out)
+		})
+	})
+}
+
+func (x *BoltStore) Delete(pk, sk string) error {
+	return x.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(pk))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(sk))
+	})
+}
+
+// QueryIndex scans all items because BoltStore does not have indexes.
+func (x *BoltStore) QueryIndex(index Index, pk string, cond SortKeyCond, out interface{}) error {
+	var entries []indexEntry
+
+	err := x.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			return bucket.ForEach(func(k, v []byte) error {
+				return decodeBoltItem(v, func(attrs map[string]*dynamodb.AttributeValue) error {
+					if entry, ok := index.match(attrs, pk, cond); ok {
+						entries = append(entries, *entry)
+					}
+					return nil
+				})
+			})
+		})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Fail to query index: %s %s", index.Name, pk)
+	}
+
+	if err := appendIndexEntries(entries, out); err != nil {
+		return errors.Wrapf(err, "Fail to query index: %s %s", index.Name, pk)
+	}
+
+	return nil
+}
+
+func (x *BoltStore) Scan(f func(pk, sk string) error) error {
+	// Keys are copied out of the read transaction so that f can modify items.
+	var keys [][2]string
+	err := x.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			return bucket.ForEach(func(k, v []byte) error {
+				keys = append(keys, [2]string{string(name), string(k)})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "Fail to scan items")
+	}
+
+	for _, key := range keys {
+		if err := f(key[0], key[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeBoltItem(raw []byte, f func(attrs map[string]*dynamodb.AttributeValue) error) error {
+	var attrs map[string]*dynamodb.AttributeValue
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return errors.Wrap(err, "Fail to decode item")
+	}
+
+	return f(attrs)
+}
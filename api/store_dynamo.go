@@ -0,0 +1,288 @@
+package api
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	"github.com/pkg/errors"
+)
+
+// Environment variables to override DynamoConfig.
+const (
+	envDynamoEndpoint        = "DYNAMODB_ENDPOINT"
+	envDynamoAccessKeyID     = "DYNAMODB_ACCESS_KEY_ID"
+	envDynamoSecretAccessKey = "DYNAMODB_SECRET_ACCESS_KEY"
+	envDynamoCreateTable     = "DYNAMODB_CREATE_TABLE"
+)
+
+// DynamoConfig is settings of DynamoDB storage backend.
+type DynamoConfig struct {
+	Region    string `json:"region"`
+	TableName string `json:"table_name"`
+
+	// Endpoint replaces AWS endpoint, e.g. http://localhost:8000 for DynamoDB Local.
+	Endpoint string `json:"endpoint"`
+	// AccessKeyID and SecretAccessKey are static credentials. Default credential
+	// chain of AWS SDK is used if empty.
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	// CreateTable creates the table with pk and sk if it does not exist.
+	CreateTable bool `json:"create_table"`
+}
+
+// LoadEnv overwrites settings by DYNAMODB_* environment variables if set.
+func (x *DynamoConfig) LoadEnv() error {
+	if v := os.Getenv(envDynamoEndpoint); v != "" {
+		x.Endpoint = v
+	}
+	if v := os.Getenv(envDynamoAccessKeyID); v != "" {
+		x.AccessKeyID = v
+	}
+	if v := os.Getenv(envDynamoSecretAccessKey); v != "" {
+		x.SecretAccessKey = v
+	}
+	if v := os.Getenv(envDynamoCreateTable); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid %s: '%s'", envDynamoCreateTable, v)
+		}
+		x.CreateTable = b
+	}
+
+	return nil
+}
+
+func (x DynamoConfig) awsConfig() *aws.Config {
+	cfg := &aws.Config{Region: aws.String(x.Region)}
+	if x.Endpoint != "" {
+		cfg.Endpoint = aws.String(x.Endpoint)
+	}
+	if x.AccessKeyID != "" {
+		cfg.Credentials = credentials.NewStaticCredentials(x.AccessKeyID, x.SecretAccessKey, "")
+	}
+
+	return cfg
+}
+
+type dynamoStore struct {
+	table dynamo.Table
+}
+
+// tableSchema is key schema of DynamoDB table for CreateTable option. Index
+// tags should be the same as indexByTime and indexByID.
+type tableSchema struct {
+	PKey    string `dynamo:"pk,hash"`
+	SKey    string `dynamo:"sk,range"`
+	IndexPK string `dynamo:"ipk" index:"by_time,hash"`
+	IndexSK string `dynamo:"isk" index:"by_time,range"`
+	IndexID string `dynamo:"iid" index:"by_id,hash"`
+}
+
+const tableActiveTimeout = 60 * time.Second
+
+// NewDynamoStore creates a Store backed by a DynamoDB table that has pk (hash
+// key) and sk (range key).
+func NewDynamoStore(cfg DynamoConfig) (Store, error) {
+	if cfg.TableName == "" {
+		return nil, errors.New("DynamoDB table name is required")
+	}
+
+	db := dynamo.New(session.New(), cfg.awsConfig())
+
+	if cfg.CreateTable {
+		if err := createTableIfNotExists(db, cfg.TableName); err != nil {
+			return nil, err
+		}
+	}
+
+	return &dynamoStore{table: db.Table(cfg.TableName)}, nil
+}
+
+func createTableIfNotExists(db *dynamo.DB, tableName string) error {
+	tables, err := db.ListTables().All()
+	if err != nil {
+		return errors.Wrap(err, "Fail to list tables")
+	}
+
+	for _, t := range tables {
+		if t == tableName {
+			return nil
+		}
+	}
+
+	Logger.WithField("table", tableName).Info("Creating DynamoDB table")
+	ct := db.CreateTable(tableName, tableSchema{}).Provision(1, 1).
+		ProvisionIndex(indexByTime.Name, 1, 1).
+		ProvisionIndex(indexByID.Name, 1, 1)
+	if err := ct.Run(); err != nil {
+		return errors.Wrapf(err, "Fail to create table: %s", tableName)
+	}
+
+	for deadline := time.Now().Add(tableActiveTimeout); time.Now().Before(deadline); time.Sleep(time.Second) {
+		desc, err := db.Table(tableName).Describe().Run()
+		if err != nil {
+			return errors.Wrapf(err, "Fail to describe table: %s", tableName)
+		}
+		if desc.Active() {
+			return nil
+		}
+	}
+
+	return errors.Errorf("Table does not become active: %s", tableName)
+}
+
+// dynamoError marks err of DynamoDB with its kind. Throttling is
+// ErrRateLimited, and failures of the service or the network are
+// ErrBackendUnavailable.
+func dynamoError(err error) error {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return err
+	}
+
+	switch aerr.Code() {
+	case dynamodb.ErrCodeProvisionedThroughputExceededException,
+		dynamodb.ErrCodeRequestLimitExceeded,
+		"ThrottlingException":
+		return withKind(ErrRateLimited, err)
+	case dynamodb.ErrCodeInternalServerError,
+		"ServiceUnavailable",
+		// Failure of sending a request.
+		"RequestError",
+		request.ErrCodeResponseTimeout:
+		return withKind(ErrBackendUnavailable, err)
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= 500 {
+		return withKind(ErrBackendUnavailable, err)
+	}
+
+	return err
+}
+
+func (x *dynamoStore) Get(pk, sk string, out interface{}) error {
+	err := x.table.Get(keyPartition, pk).Range(keySort, dynamo.Equal, sk).One(out)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return errItemNotFound
+	}
+
+	return dynamoError(err)
+}
+
+func withSortKeyCond(q *dynamo.Query, name string, cond SortKeyCond) *dynamo.Query {
+	switch cond.op {
+	case sortKeyEqual:
+		q = q.Range(name, dynamo.Equal, cond.values[0])
+	case sortKeyBeginsWith:
+		q = q.Range(name, dynamo.BeginsWith, cond.values[0])
+	case sortKeyBetween:
+		q = q.Range(name, dynamo.Between, cond.values[0], cond.values[1])
+	}
+
+	return q
+}
+
+func (x *dynamoStore) Query(pk string, cond SortKeyCond, out interface{}) error {
+	return dynamoError(withSortKeyCond(x.table.Get(keyPartition, pk), keySort, cond).All(out))
+}
+
+func (x *dynamoStore) QueryIndex(index Index, pk string, cond SortKeyCond, out interface{}) error {
+	q := x.table.Get(index.PKey, pk).Index(index.Name)
+	if index.SKey != "" {
+		q = withSortKeyCond(q, index.SKey, cond)
+	}
+
+	return dynamoError(q.All(out))
+}
+
+func (x *dynamoStore) Scan(f func(pk, sk string) error) error {
+	iter := x.table.Scan().Project(keyPartition, keySort).Iter()
+
+	var key tableSchema
+	for iter.Next(&key) {
+		if err := f(key.PKey, key.SKey); err != nil {
+			return err
+		}
+	}
+
+	return dynamoError(iter.Err())
+}
+
+func (x *dynamoStore) Put(item interface{}) error {
+	return dynamoError(x.table.Put(item).Run())
+}
+
+func (x *dynamoStore) PutVersion(item interface{}, version int) error {
+	put := x.table.Put(item)
+	if version == 0 {
+		put = put.If("attribute_not_exists($) OR $ = ?", keyVersion, keyVersion, version)
+	} else {
+		put = put.If("$ = ?", keyVersion, version)
+	}
+
+	err := put.Run()
+	if isConditionalCheckFailed(err) {
+		return errVersionConflict
+	}
+
+	return dynamoError(err)
+}
+
+func (x *dynamoStore) Update(pk, sk string, set map[string]interface{}, version int, out interface{}) error {
+	update := x.table.Update(keyPartition, pk).Range(keySort, sk).If("attribute_exists($)", keyPartition)
+	switch {
+	case version == 0:
+		update = update.If("(attribute_not_exists($) OR $ = ?)", keyVersion, keyVersion, version)
+	case version != anyVersion:
+		update = update.If("$ = ?", keyVersion, version)
+	}
+
+	for name, value := range set {
+		if name == keyPartition || name == keySort || name == keyVersion {
+			return errors.Errorf("Attribute can not be updated: %s", name)
+		}
+
+		var v *dynamodb.AttributeValue
+		if value != nil {
+			var err error
+			if v, err = dynamo.Marshal(value); err != nil {
+				return errors.Wrapf(err, "Fail to marshal attribute: %s", name)
+			}
+		}
+
+		if v == nil {
+			update = update.Remove(name)
+		} else {
+			update = update.Set(name, v)
+		}
+	}
+
+	err := update.Add(keyVersion, 1).Value(out)
+	if isConditionalCheckFailed(err) {
+		// Either of conditions failed.
+		if err := x.Get(pk, sk, &tableSchema{}); err != nil {
+			return err
+		}
+		return errVersionConflict
+	}
+
+	return dynamoError(err)
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func (x *dynamoStore) Delete(pk, sk string) error {
+	return dynamoError(x.table.Delete(keyPartition, pk).Range(keySort, sk).Run())
+}
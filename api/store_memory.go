@@ -0,0 +1,183 @@
+package api
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	mutex      sync.RWMutex
+	partitions map[string]map[string]map[string]*dynamodb.AttributeValue
+}
+
+// NewMemoryStore creates a Store that keeps all items in memory. It is for
+// local development and testing, items are lost when the process exits.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		partitions: make(map[string]map[string]map[string]*dynamodb.AttributeValue),
+	}
+}
+
+func (x *memoryStore) Get(pk, sk string, out interface{}) error {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	attrs, ok := x.partitions[pk][sk]
+	if !ok {
+		return errItemNotFound
+	}
+
+	if err := dynamo.UnmarshalItem(attrs, out); err != nil {
+		return errors.Wrapf(err, "Fail to unmarshal item: %s %s", pk, sk)
+	}
+
+	return nil
+}
+
+func (x *memoryStore) Query(pk string, cond SortKeyCond, out interface{}) error {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	partition := x.partitions[pk]
+	var keys []string
+	for sk := range partition {
+		if cond.Match(sk) {
+			keys = append(keys, sk)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, sk := range keys {
+		if err := appendStoreItem(partition[sk], out); err != nil {
+			return errors.Wrapf(err, "Fail to query items: %s", pk)
+		}
+	}
+
+	return nil
+}
+
+func (x *memoryStore) Put(item interface{}) error {
+	pk, sk, attrs, err := marshalStoreItem(item)
+	if err != nil {
+		return err
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	partition, ok := x.partitions[pk]
+	if !ok {
+		partition = make(map[string]map[string]*dynamodb.AttributeValue)
+		x.partitions[pk] = partition
+	}
+	partition[sk] = attrs
+
+	return nil
+}
+
+func (x *memoryStore) PutVersion(item interface{}, version int) error {
+	pk, sk, attrs, err := marshalStoreItem(item)
+	if err != nil {
+		return err
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if !matchVersion(x.partitions[pk][sk], version) {
+		return errVersionConflict
+	}
+
+	partition, ok := x.partitions[pk]
+	if !ok {
+		partition = make(map[string]map[string]*dynamodb.AttributeValue)
+		x.partitions[pk] = partition
+	}
+	partition[sk] = attrs
+
+	return nil
+}
+
+func (x *memoryStore) Update(pk, sk string, set map[string]interface{}, version int, out interface{}) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	attrs, ok := x.partitions[pk][sk]
+	if !ok {
+		return errItemNotFound
+	}
+	if version != anyVersion && !matchVersion(attrs, version) {
+		return errVersionConflict
+	}
+
+	updated, err := applyUpdate(attrs, set)
+	if err != nil {
+		return err
+	}
+	x.partitions[pk][sk] = updated
+
+	if err := dynamo.UnmarshalItem(updated, out); err != nil {
+		return errors.Wrapf(err, "Fail to unmarshal item: %s %s", pk, sk)
+	}
+
+	return nil
+}
+
+func (x *memoryStore) Delete(pk, sk string) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if partition, ok := x.partitions[pk]; ok {
+		delete(partition, sk)
+		if len(partition) == 0 {
+			delete(x.partitions, pk)
+		}
+	}
+
+	return nil
+}
+
+// QueryIndex scans all items because memoryStore does not have indexes.
+func (x *memoryStore) QueryIndex(index Index, pk string, cond SortKeyCond, out interface{}) error {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	var entries []indexEntry
+	for _, partition := range x.partitions {
+		for _, attrs := range partition {
+			if entry, ok := index.match(attrs, pk, cond); ok {
+				entries = append(entries, *entry)
+			}
+		}
+	}
+
+	if err := appendIndexEntries(entries, out); err != nil {
+		return errors.Wrapf(err, "Fail to query index: %s %s", index.Name, pk)
+	}
+
+	return nil
+}
+
+func (x *memoryStore) Scan(f func(pk, sk string) error) error {
+	// Keys are copied so that f can modify items.
+	x.mutex.RLock()
+	var keys [][2]string
+	for pk, partition := range x.partitions {
+		for sk := range partition {
+			keys = append(keys, [2]string{pk, sk})
+		}
+	}
+	x.mutex.RUnlock()
+
+	for _, key := range keys {
+		if err := f(key[0], key[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m-mizutani/task-kitchen/api"
+)
+
+type storeTestItem struct {
+	PKey  string `dynamo:"pk"`
+	SKey  string `dynamo:"sk"`
+	Value string `dynamo:"value"`
+	// IndexPK and IndexSK are keys of storeTestIndex.
+	IndexPK string `dynamo:"ipk,omitempty"`
+	IndexSK string `dynamo:"isk,omitempty"`
+}
+
+var storeTestIndex = api.Index{Name: "test", PKey: "ipk", SKey: "isk"}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, api.NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "task-kitchen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := api.NewBoltStore(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	testStore(t, store)
+}
+
+func testStore(t *testing.T, store api.Store) {
+	for _, sk := range []string{"b/2", "a/1", "b/1", "c"} {
+		require.NoError(t, store.Put(storeTestItem{PKey: "p1", SKey: sk, Value: "v" + sk}))
+	}
+	require.NoError(t, store.Put(storeTestItem{PKey: "p2", SKey: "a/1"}))
+
+	var item storeTestItem
+	require.NoError(t, store.Get("p1", "b/1", &item))
+	assert.Equal(t, "vb/1", item.Value)
+	assert.True(t, errors.Is(store.Get("p1", "x", &item), api.ErrNotFound))
+
+	var all []storeTestItem
+	require.NoError(t, store.Query("p1", api.AnySortKey(), &all))
+	require.Equal(t, 4, len(all))
+	assert.Equal(t, "a/1", all[0].SKey)
+	assert.Equal(t, "c", all[3].SKey)
+
+	var prefixed []storeTestItem
+	require.NoError(t, store.Query("p1", api.SortKeyBeginsWith("b/"), &prefixed))
+	assert.Equal(t, 2, len(prefixed))
+
+	var ranged []storeTestItem
+	require.NoError(t, store.Query("p1", api.SortKeyBetween("a/1", "b/1"), &ranged))
+	assert.Equal(t, 2, len(ranged))
+
+	require.NoError(t, store.Delete("p1", "b/1"))
+	var remained []storeTestItem
+	require.NoError(t, store.Query("p1", api.AnySortKey(), &remained))
+	assert.Equal(t, 3, len(remained))
+
+	var empty []storeTestItem
+	require.NoError(t, store.Query("p3", api.AnySortKey(), &empty))
+	assert.Equal(t, 0, len(empty))
+
+	// Secondary index across partitions.
+	require.NoError(t, store.Put(storeTestItem{PKey: "q1", SKey: "x", IndexPK: "i1", IndexSK: "2"}))
+	require.NoError(t, store.Put(storeTestItem{PKey: "q2", SKey: "x", IndexPK: "i1", IndexSK: "1"}))
+	require.NoError(t, store.Put(storeTestItem{PKey: "q3", SKey: "x", IndexPK: "i1", IndexSK: "3"}))
+	require.NoError(t, store.Put(storeTestItem{PKey: "q4", SKey: "x", IndexPK: "i2", IndexSK: "1"}))
+
+	var indexed []storeTestItem
+	require.NoError(t, store.QueryIndex(storeTestIndex, "i1", api.SortKeyBetween("1", "2"), &indexed))
+	require.Equal(t, 2, len(indexed))
+	assert.Equal(t, "q2", indexed[0].PKey)
+	assert.Equal(t, "q1", indexed[1].PKey)
+
+	// Conditional write by version.
+	type versionedItem struct {
+		PKey    string `dynamo:"pk"`
+		SKey    string `dynamo:"sk"`
+		Version int    `dynamo:"version"`
+	}
+	require.NoError(t, store.PutVersion(versionedItem{PKey: "v1", SKey: "x", Version: 1}, 0))
+	assert.True(t, errors.Is(store.PutVersion(versionedItem{PKey: "v1", SKey: "x", Version: 1}, 0), api.ErrConflict))
+	require.NoError(t, store.PutVersion(versionedItem{PKey: "v1", SKey: "x", Version: 2}, 1))
+	assert.Error(t, store.PutVersion(versionedItem{PKey: "v1", SKey: "x", Version: 2}, 1))
+	assert.Error(t, store.PutVersion(versionedItem{PKey: "v1", SKey: "y", Version: 2}, 1))
+	// An item without version is version 0.
+	require.NoError(t, store.PutVersion(versionedItem{PKey: "p1", SKey: "c", Version: 1}, 0))
+
+	// Update changes only given attributes. Version -1 is not checked.
+	var updated storeTestItem
+	require.NoError(t, store.Update("p1", "a/1", map[string]interface{}{"value": "updated", "ipk": "i3"}, -1, &updated))
+	assert.Equal(t, "updated", updated.Value)
+	assert.Equal(t, "a/1", updated.SKey)
+	var versioned versionedItem
+	require.NoError(t, store.Update("p1", "a/1", map[string]interface{}{"ipk": nil}, 1, &versioned))
+	assert.Equal(t, 2, versioned.Version)
+	require.NoError(t, store.Get("p1", "a/1", &updated))
+	assert.Equal(t, "updated", updated.Value)
+	assert.Equal(t, "", updated.IndexPK)
+	assert.True(t, errors.Is(store.Update("p1", "a/1", map[string]interface{}{"value": "x"}, 1, &updated), api.ErrConflict))
+	assert.True(t, errors.Is(store.Update("p1", "nothing", map[string]interface{}{"value": "x"}, -1, &updated), api.ErrNotFound))
+	assert.True(t, errors.Is(store.Get("p1", "nothing", &updated), api.ErrNotFound))
+	require.NoError(t, store.Delete("v1", "x"))
+
+	scanner, ok := store.(api.Scanner)
+	require.True(t, ok)
+	count := 0
+	require.NoError(t, scanner.Scan(func(pk, sk string) error {
+		count++
+		// Items can be modified while scanning.
+		return store.Put(storeTestItem{PKey: pk, SKey: sk, Value: "scanned"})
+	}))
+	assert.Equal(t, 8, count)
+	require.NoError(t, store.Get("q1", "x", &item))
+	assert.Equal(t, "scanned", item.Value)
+}
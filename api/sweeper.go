@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// SweepPomodoros finishes overdue pomodoros of all users in the store. It is
+// for a scheduled job, such as a scheduled Lambda event.
+func SweepPomodoros(store Store, now time.Time) (int, error) {
+	mgr := newKitchenManager(store)
+	return mgr.sweepPomodoros(now)
+}
+
+// GenerateReports renders report templates with AutoGenerate for days whose
+// report became done since the last call. It is for a scheduled job as well
+// as SweepPomodoros.
+func GenerateReports(store Store) (int, error) {
+	mgr := newKitchenManager(store)
+	return mgr.generateReports()
+}
+
+// DeliverWebhooks sends webhook deliveries that are due at now, including
+// retries of failed ones. It returns number of attempts.
+func DeliverWebhooks(store Store, now time.Time) (int, error) {
+	mgr := newKitchenManager(store)
+	return mgr.deliverWebhooks(now)
+}
+
+// RunPomodoroSweeper calls SweepPomodoros every interval until ctx is done.
+// Finished pomodoros are published to the broker unless it is nil.
+func RunPomodoroSweeper(ctx context.Context, store Store, broker Broker, interval time.Duration) {
+	mgr := newKitchenManager(store)
+	mgr.broker = broker
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := mgr.sweepPomodoros(now.UTC())
+			if err != nil {
+				Logger.WithError(err).Error("Fail to sweep pomodoros")
+			} else if n > 0 {
+				Logger.WithField("count", n).Info("Finished overdue pomodoros")
+			}
+		}
+	}
+}
+
+// RunReportGenerator calls GenerateReports every interval until ctx is done.
+func RunReportGenerator(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := GenerateReports(store)
+			if err != nil {
+				Logger.WithError(err).Error("Fail to generate reports")
+			} else if n > 0 {
+				Logger.WithField("count", n).Info("Generated reports")
+			}
+		}
+	}
+}
+
+// RunWebhookDispatcher calls DeliverWebhooks every interval until ctx is done.
+func RunWebhookDispatcher(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := DeliverWebhooks(store, now.UTC())
+			if err != nil {
+				Logger.WithError(err).Error("Fail to deliver webhooks")
+			} else if n > 0 {
+				Logger.WithField("count", n).Info("Delivered webhooks")
+			}
+		}
+	}
+}
@@ -1,26 +1,74 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/guregu/dynamo"
 	"github.com/pkg/errors"
 )
 
+type TaskStatus string
+
+const (
+	TaskTodo       TaskStatus = "todo"
+	TaskInProgress TaskStatus = "in-progress"
+	TaskDone       TaskStatus = "done"
+	TaskDropped    TaskStatus = "dropped"
+)
+
+// taskTransitions is allowed next statuses of each status. A done task can be
+// reopened and a dropped task can be restored.
+var taskTransitions = map[TaskStatus][]TaskStatus{
+	TaskTodo:       {TaskInProgress, TaskDone, TaskDropped},
+	TaskInProgress: {TaskTodo, TaskDone, TaskDropped},
+	TaskDone:       {TaskInProgress},
+	TaskDropped:    {TaskTodo},
+}
+
+func (x TaskStatus) valid() bool {
+	_, ok := taskTransitions[x]
+	return ok
+}
+
+func (x TaskStatus) canTransit(next TaskStatus) bool {
+	for _, s := range taskTransitions[x] {
+		if s == next {
+			return true
+		}
+	}
+	return false
+}
+
 type Task struct {
-	PKey        string    `dynamo:"pk" json:"-"`
-	SKey        string    `dynamo:"sk" json:"-"`
-	UserID      string    `dynamo:"user_id" json:"user_id"`
-	TaskID      string    `dynamo:"task_id" json:"task_id"`
-	CreatedAt   time.Time `dynamo:"created_at" json:"created_at"`
-	Title       string    `dynamo:"title" json:"title"`
-	TomatoNum   int64     `dynamo:"tomato_num" json:"tomato_num"`
-	Description string    `dynamo:"description" json:"description"`
-
-	table   dynamo.Table
+	PKey        string     `dynamo:"pk" json:"-"`
+	SKey        string     `dynamo:"sk" json:"-"`
+	UserID      string     `dynamo:"user_id" json:"user_id"`
+	TaskID      string     `dynamo:"task_id" json:"task_id"`
+	CreatedAt   time.Time  `dynamo:"created_at" json:"created_at"`
+	Title       string     `dynamo:"title" json:"title" binding:"max=256"`
+	TomatoNum   int64      `dynamo:"tomato_num" json:"tomato_num" binding:"min=0"`
+	Description string     `dynamo:"description" json:"description" binding:"max=10000"`
+	Status      TaskStatus `dynamo:"status" json:"status" binding:"omitempty,task_status"`
+	// CompletedAt is zero unless the task is done.
+	CompletedAt time.Time `dynamo:"completed_at,omitempty" json:"completed_at"`
+	// CarriedFromDate and CarriedFromTaskID point the task that this task was
+	// carried over from.
+	CarriedFromDate   string `dynamo:"carried_from_date,omitempty" json:"carried_from_date,omitempty"`
+	CarriedFromTaskID string `dynamo:"carried_from_task_id,omitempty" json:"carried_from_task_id,omitempty"`
+	// Version is incremented by every save to detect concurrent updates.
+	Version int `dynamo:"version" json:"version"`
+	// Focus is computed from pomodoros of the task on read, not stored.
+	Focus *FocusStats `dynamo:"-" json:"focus,omitempty"`
+	// Estimate is computed with Focus on read, not stored.
+	Estimate *Estimate `dynamo:"-" json:"estimate,omitempty"`
+
+	indexKeys
+
+	store   Store
+	broker  Broker
 	deleted bool
 }
 
@@ -31,35 +79,44 @@ func toTaskKey(userID string, date time.Time, taskID string) (string, string) {
 }
 
 func (x KitchenManager) NewTask(userID string, date time.Time) (*Task, error) {
+	task := x.newTask(userID, date)
+	if err := task.Save(); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// newTask builds a task of the date without saving it.
+func (x KitchenManager) newTask(userID string, date time.Time) *Task {
 	task := Task{
 		UserID:    userID,
 		TaskID:    strings.Replace(uuid.New().String(), "-", "", -1),
 		CreatedAt: date,
-		table:     x.table,
+		store:     x.store,
+		broker:    x.broker,
 		TomatoNum: 1,
+		Status:    TaskTodo,
 	}
 
 	task.PKey, task.SKey = toTaskKey(task.UserID, task.CreatedAt, task.TaskID)
-	if err := task.Save(); err != nil {
-		return nil, err
-	}
-
-	return &task, nil
+	return &task
 }
 
 func (x KitchenManager) GetTask(userID string, date time.Time, taskID string) (*Task, error) {
 	var task Task
 	pk, sk := toTaskKey(userID, date, taskID)
 
-	if err := x.table.Get("pk", pk).Range("sk", dynamo.Equal, sk).One(&task); err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
+	if err := x.store.Get(pk, sk, &task); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Task not found: %s", taskID)
 		}
 
 		return nil, errors.Wrap(err, "Fail to get task")
 	}
 
-	task.table = x.table
+	task.store, task.broker = x.store, x.broker
+	task.normalize()
 
 	return &task, nil
 }
@@ -68,30 +125,195 @@ func (x KitchenManager) FetchTasks(userID string, date time.Time) ([]Task, error
 	var tasks []Task
 	pk, _ := toTaskKey(userID, date, "")
 
-	if err := x.table.Get("pk", pk).All(&tasks); err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
-		}
-
+	if err := x.store.Query(pk, AnySortKey(), &tasks); err != nil {
 		return nil, errors.Wrap(err, "Fail to get task")
 	}
 
+	for i := range tasks {
+		tasks[i].store, tasks[i].broker = x.store, x.broker
+		tasks[i].normalize()
+	}
 	return tasks, nil
 }
 
+// FetchTasksByStatus returns tasks that have one of statuses.
+func (x KitchenManager) FetchTasksByStatus(userID string, date time.Time, statuses []TaskStatus) ([]Task, error) {
+	tasks, err := x.FetchTasks(userID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterTasksByStatus(tasks, statuses), nil
+}
+
+func filterTasksByStatus(tasks []Task, statuses []TaskStatus) []Task {
+	var filtered []Task
+	for _, task := range tasks {
+		for _, s := range statuses {
+			if task.Status == s {
+				filtered = append(filtered, task)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// normalize sets status of tasks created before the status was introduced.
+func (x *Task) normalize() {
+	if x.Status == "" {
+		x.Status = TaskTodo
+	}
+}
+
+// SetStatus changes status of the task if the transition is allowed. It does
+// not save the task.
+func (x *Task) SetStatus(status TaskStatus, now time.Time) error {
+	if !status.valid() {
+		return newUserError(400, "Invalid task status: '%s'", status)
+	}
+	if status == x.Status {
+		return nil
+	}
+	if !x.Status.canTransit(status) {
+		return newUserError(409, "Task status can not be changed from '%s' to '%s'", x.Status, status)
+	}
+
+	x.Status = status
+	if status == TaskDone {
+		x.CompletedAt = now
+	} else {
+		x.CompletedAt = time.Time{}
+	}
+
+	return nil
+}
+
 func (x *Task) Save() error {
-	if err := x.table.Put(x).Run(); err != nil {
+	if err := validateStruct(x); err != nil {
+		return err
+	}
+
+	x.indexKeys = newIndexKeys(indexKindTask, x.UserID, x.CreatedAt, x.TaskID)
+	if err := putVersioned(x.store, x, &x.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			var current Task
+			return conflictError(x.store, "Task", x.PKey, x.SKey, &current)
+		}
 		return errors.Wrapf(err, "Fail to save task: %s", x.PKey)
 	}
 
+	return x.afterSave()
+}
+
+// Patch validates and applies fields changed by a patch. Only the fields are
+// written, so that concurrent patches of other fields are kept. version is
+// checked unless it is anyVersion. A change of status is always checked with
+// the version read because the transition depends on the current status.
+func (x *Task) Patch(fields map[string]json.RawMessage, version int) error {
+	next := *x
+	set := map[string]interface{}{}
+
+	for name, raw := range fields {
+		switch name {
+		case "title":
+			if err := decodePatchField(name, raw, &next.Title); err != nil {
+				return err
+			}
+			if next.Title == "" {
+				return newFieldError(name, "required", "", "title of task is required")
+			}
+			set["title"] = next.Title
+
+		case "tomato_num":
+			if err := decodePatchField(name, raw, &next.TomatoNum); err != nil {
+				return err
+			}
+			set["tomato_num"] = next.TomatoNum
+
+		case "description":
+			if err := decodePatchField(name, raw, &next.Description); err != nil {
+				return err
+			}
+			set["description"] = next.Description
+
+		case "status":
+			var status TaskStatus
+			if err := decodePatchField(name, raw, &status); err != nil {
+				return err
+			}
+			if err := next.SetStatus(status, time.Now().UTC()); err != nil {
+				return err
+			}
+			set["status"] = next.Status
+			set["completed_at"] = nil
+			if !next.CompletedAt.IsZero() {
+				set["completed_at"] = next.CompletedAt
+			}
+			version = x.Version
+
+		default:
+			return unchangeableFieldError(name)
+		}
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+	if err := validateStruct(&next); err != nil {
+		return err
+	}
+
+	var updated Task
+	if err := x.store.Update(x.PKey, x.SKey, set, version, &updated); err != nil {
+		switch {
+		case errors.Is(err, errItemNotFound):
+			return newNotFoundError("Task not found: %s", x.TaskID)
+		case errors.Is(err, errVersionConflict):
+			var current Task
+			return conflictError(x.store, "Task", x.PKey, x.SKey, &current)
+		}
+		return errors.Wrapf(err, "Fail to update task: %s", x.PKey)
+	}
+
+	updated.store, updated.broker = x.store, x.broker
+	updated.normalize()
+	*x = updated
+	return x.afterSave()
+}
+
+func (x *Task) afterSave() error {
+	if err := searchIndexOf(x.store).IndexDocument(x.searchDocument()); err != nil {
+		return err
+	}
+
+	emitEvent(x.store, x.broker, x.UserID, x.CreatedAt, EventTaskSaved, x)
 	return nil
 }
 
+func (x *Task) searchDocument() SearchDocument {
+	return SearchDocument{
+		UserID:      x.UserID,
+		Kind:        SearchTask,
+		ID:          x.TaskID,
+		Date:        x.CreatedAt,
+		Title:       x.Title,
+		Description: x.Description,
+	}
+}
+
 func (x *Task) Delete() error {
-	if err := x.table.Delete("pk", x.PKey).Range("sk", x.SKey).Run(); err != nil {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
 		return errors.Wrapf(err, "Fail to delete task: %s", x.PKey)
 	}
+	if err := searchIndexOf(x.store).RemoveDocument(x.UserID, SearchTask, x.TaskID); err != nil {
+		return err
+	}
 
 	x.deleted = true
+	emitEvent(x.store, x.broker, x.UserID, x.CreatedAt, EventTaskDeleted, x)
+	// The task can be saved again as a new item.
+	x.Version = 0
 	return nil
 }
@@ -1,6 +1,7 @@
 package api_test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -12,7 +13,7 @@ import (
 )
 
 func TestNewTask(t *testing.T) {
-	mgr := main.NewKitchenManager(testCfg.TableRegion, testCfg.TableName)
+	mgr := main.NewKitchenManager(newTestStore())
 	uid1 := uuid.New().String()
 	now := time.Now()
 
@@ -27,13 +28,12 @@ func TestNewTask(t *testing.T) {
 	err = t1.Delete()
 	require.NoError(t, err)
 
-	t3, err := mgr.GetTask(uid1, now, t1.TaskID)
-	require.NoError(t, err)
-	require.Nil(t, t3)
+	_, err = mgr.GetTask(uid1, now, t1.TaskID)
+	assert.True(t, errors.Is(err, main.ErrNotFound))
 }
 
 func TestFetchTasks(t *testing.T) {
-	mgr := main.NewKitchenManager(testCfg.TableRegion, testCfg.TableName)
+	mgr := main.NewKitchenManager(newTestStore())
 	uid1 := uuid.New().String()
 	now := time.Now()
 
@@ -52,8 +52,70 @@ func TestFetchTasks(t *testing.T) {
 	assert.NoError(t, t2.Delete())
 }
 
+func TestTaskStatus(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	now := time.Now()
+
+	t1, err := mgr.NewTask(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, main.TaskTodo, t1.Status)
+
+	require.NoError(t, t1.SetStatus(main.TaskInProgress, now))
+	require.NoError(t, t1.SetStatus(main.TaskDone, now))
+	assert.False(t, t1.CompletedAt.IsZero())
+	require.NoError(t, t1.Save())
+
+	// Done task can not be dropped without reopening.
+	assert.Error(t, t1.SetStatus(main.TaskDropped, now))
+	assert.Error(t, t1.SetStatus("finished", now))
+
+	t2, err := mgr.NewTask(uid1, now)
+	require.NoError(t, err)
+
+	done, err := mgr.FetchTasksByStatus(uid1, now, []main.TaskStatus{main.TaskDone})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(done))
+	assert.Equal(t, t1.TaskID, done[0].TaskID)
+
+	require.NoError(t, t1.SetStatus(main.TaskInProgress, now))
+	assert.True(t, t1.CompletedAt.IsZero())
+
+	assert.NoError(t, t1.Delete())
+	assert.NoError(t, t2.Delete())
+}
+
+func TestTaskVersion(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	now := time.Now()
+
+	t1, err := mgr.NewTask(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, t1.Version)
+
+	t2, err := mgr.GetTask(uid1, now, t1.TaskID)
+	require.NoError(t, err)
+	t2.Title = "first"
+	require.NoError(t, t2.Save())
+	assert.Equal(t, 2, t2.Version)
+
+	// t1 has been read before t2 was saved.
+	t1.Title = "second"
+	assert.Error(t, t1.Save())
+	assert.Equal(t, 1, t1.Version)
+
+	t3, err := mgr.GetTask(uid1, now, t1.TaskID)
+	require.NoError(t, err)
+	assert.Equal(t, "first", t3.Title)
+	assert.Equal(t, 2, t3.Version)
+
+	require.NoError(t, t2.Delete())
+	assert.Error(t, t3.Save())
+}
+
 func TestPomodoro(t *testing.T) {
-	mgr := main.NewKitchenManager(testCfg.TableRegion, testCfg.TableName)
+	mgr := main.NewKitchenManager(newTestStore())
 	uid1 := uuid.New().String()
 	now := time.Now()
 
@@ -63,7 +125,7 @@ func TestPomodoro(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create a pomodoro
-	p1, err := main.NewPomodoro(t1)
+	p1, err := main.NewPomodoro(t1, main.DefaultPomodoroDuration)
 	require.NoError(t, err)
 	assert.Equal(t, "started", p1.Status)
 
@@ -71,11 +133,11 @@ func TestPomodoro(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create another pomodoro
-	p2, err := main.NewPomodoro(t1)
+	p2, err := main.NewPomodoro(t1, main.DefaultPomodoroDuration)
 	require.NoError(t, err)
 
 	// Create yet another pomodoro for t2
-	p3, err := main.NewPomodoro(t2)
+	p3, err := main.NewPomodoro(t2, main.DefaultPomodoroDuration)
 	require.NoError(t, err)
 
 	// Check fetch action and isolation
@@ -95,3 +157,535 @@ func TestPomodoro(t *testing.T) {
 	require.NoError(t, p2.Delete())
 	require.NoError(t, p3.Delete())
 }
+
+func TestCarryOverTasks(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	day2, day3 := day1.AddDate(0, 0, 1), day1.AddDate(0, 0, 2)
+
+	t1, err := mgr.NewTask(uid1, day1)
+	require.NoError(t, err)
+	t1.Title = "unfinished"
+	require.NoError(t, t1.Save())
+
+	t2, err := mgr.NewTask(uid1, day1)
+	require.NoError(t, err)
+	require.NoError(t, t2.SetStatus(main.TaskDone, day1))
+	require.NoError(t, t2.Save())
+
+	copied, err := mgr.CarryOverTasks(uid1, day1, day2, main.CarryOverCopy)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(copied))
+	assert.Equal(t, "unfinished", copied[0].Title)
+	assert.Equal(t, t1.TaskID, copied[0].CarriedFromTaskID)
+	assert.Equal(t, "2019-04-01", copied[0].CarriedFromDate)
+
+	// Already carried tasks are skipped.
+	again, err := mgr.CarryOverTasks(uid1, day1, day2, main.CarryOverCopy)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(again))
+
+	history, err := mgr.TaskHistory(&copied[0])
+	require.NoError(t, err)
+	require.Equal(t, 2, len(history))
+	assert.Equal(t, t1.TaskID, history[0].TaskID)
+	assert.Equal(t, copied[0].TaskID, history[1].TaskID)
+
+	moved, err := mgr.CarryOverTasks(uid1, day2, day3, main.CarryOverMove)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(moved))
+	assert.Equal(t, copied[0].TaskID, moved[0].CarriedFromTaskID)
+
+	rest, err := mgr.FetchTasks(uid1, day2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(rest))
+
+	_, err = mgr.CarryOverTasks(uid1, day1, day1, main.CarryOverCopy)
+	assert.Error(t, err)
+}
+
+func TestPomodoroFinish(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+
+	t1, err := mgr.NewTask(uid1, time.Now())
+	require.NoError(t, err)
+
+	finished, err := main.NewPomodoro(t1, main.DefaultPomodoroDuration)
+	require.NoError(t, err)
+	require.NoError(t, finished.Finish())
+	finishedAt := finished.FinishedAt
+	err = finished.Finish()
+	assert.True(t, errors.Is(err, main.ErrConflict))
+	assert.Equal(t, finishedAt, finished.FinishedAt)
+
+	abandoned, err := main.NewPomodoro(t1, main.DefaultPomodoroDuration)
+	require.NoError(t, err)
+	require.NoError(t, abandoned.Abandon())
+	err = abandoned.Finish()
+	assert.True(t, errors.Is(err, main.ErrConflict))
+	assert.Equal(t, main.PomodoroAbandoned, abandoned.Status)
+
+	// Overdue pomodoro which is not swept yet finishes at the deadline.
+	overdue, err := main.NewPomodoro(t1, -time.Minute)
+	require.NoError(t, err)
+	p, err := main.GetPomodoro(t1, overdue.PomodoroID)
+	require.NoError(t, err)
+	require.NoError(t, p.Finish())
+	assert.Equal(t, overdue.Deadline, p.FinishedAt)
+	assert.True(t, errors.Is(p.Finish(), main.ErrConflict))
+}
+
+func TestPomodoroExpiry(t *testing.T) {
+	store := newTestStore()
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	now := time.Now()
+
+	t1, err := mgr.NewTask(uid1, now)
+	require.NoError(t, err)
+
+	overdue, err := main.NewPomodoro(t1, -time.Minute)
+	require.NoError(t, err)
+	running, err := main.NewPomodoro(t1, time.Hour)
+	require.NoError(t, err)
+
+	// Overdue pomodoro is finished at the deadline on read.
+	pset, err := main.FetchPomodoros(t1)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(pset))
+	for _, p := range pset {
+		switch p.PomodoroID {
+		case overdue.PomodoroID:
+			assert.Equal(t, main.PomodoroFinished, p.Status)
+			assert.Equal(t, overdue.Deadline, p.FinishedAt)
+		case running.PomodoroID:
+			assert.Equal(t, main.PomodoroStarted, p.Status)
+		}
+	}
+
+	n, err := main.SweepPomodoros(store, time.Now().UTC())
+	require.NoError(t, err)
+	assert.True(t, n >= 1)
+
+	// Sweeper does not finish the same pomodoro twice.
+	n, err = main.SweepPomodoros(store, time.Now().UTC())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = main.SweepPomodoros(store, time.Now().UTC().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, n >= 1)
+
+	require.NoError(t, overdue.Delete())
+	require.NoError(t, running.Delete())
+	require.NoError(t, t1.Delete())
+}
+
+func TestPomodoroSweepSameSecond(t *testing.T) {
+	store := newTestStore()
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+
+	t1, err := mgr.NewTask(uid1, time.Now())
+	require.NoError(t, err)
+	p, err := main.NewPomodoro(t1, time.Hour)
+	require.NoError(t, err)
+
+	// Sweep a few milliseconds before the deadline in the same second.
+	now := p.Deadline.Truncate(time.Second)
+	if now.Equal(p.Deadline) {
+		now = now.Add(-5 * time.Millisecond)
+	}
+	n, err := main.SweepPomodoros(store, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	got, err := main.GetPomodoro(t1, p.PomodoroID)
+	require.NoError(t, err)
+	assert.Equal(t, main.PomodoroStarted, got.Status)
+
+	// Index is kept and the pomodoro is finished by the next sweep.
+	n, err = main.SweepPomodoros(store, p.Deadline.Add(5*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	got, err = main.GetPomodoro(t1, p.PomodoroID)
+	require.NoError(t, err)
+	assert.Equal(t, main.PomodoroFinished, got.Status)
+}
+
+func TestPomodoroCycle(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	now := time.Now()
+
+	t1, err := mgr.NewTask(uid1, now)
+	require.NoError(t, err)
+
+	next, err := mgr.NextStep(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, main.StepWork, next.Step)
+	assert.Equal(t, 25, next.Minutes)
+
+	// Finished pomodoro is followed by a short break.
+	_, err = main.NewPomodoro(t1, -time.Minute)
+	require.NoError(t, err)
+	next, err = mgr.NextStep(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, main.StepShortBreak, next.Step)
+	assert.Equal(t, 1, next.CompletedWork)
+
+	b, err := mgr.StartBreak(uid1, now, main.ShortBreak, 5*time.Minute)
+	require.NoError(t, err)
+	next, err = mgr.NextStep(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, main.StepShortBreak, next.Step)
+	assert.True(t, next.InProgress)
+
+	require.NoError(t, b.Finish())
+	next, err = mgr.NextStep(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, main.StepWork, next.Step)
+
+	// Long break after 4 work sessions.
+	for i := 0; i < 3; i++ {
+		_, err = main.NewPomodoro(t1, -time.Minute)
+		require.NoError(t, err)
+	}
+	next, err = mgr.NextStep(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, main.StepLongBreak, next.Step)
+	assert.Equal(t, 15, next.Minutes)
+
+	overdue, err := mgr.StartBreak(uid1, now, main.LongBreak, -time.Minute)
+	require.NoError(t, err)
+	next, err = mgr.NextStep(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, main.StepWork, next.Step)
+	assert.Equal(t, 0, next.CompletedWork)
+
+	breaks, err := mgr.FetchBreaks(uid1, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(breaks))
+
+	// A finished break can not be finished again, but an overdue one can.
+	assert.True(t, errors.Is(b.Finish(), main.ErrConflict))
+	got, err := mgr.GetBreak(uid1, now, overdue.BreakID)
+	require.NoError(t, err)
+	require.NoError(t, got.Finish())
+	assert.Equal(t, overdue.Deadline, got.FinishedAt)
+	assert.True(t, errors.Is(got.Finish(), main.ErrConflict))
+
+	pset, err := main.FetchPomodoros(t1)
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(pset))
+}
+
+func TestEstimateAccuracy(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	newDoneTask := func(date time.Time, estimate int64, finished int) *main.Task {
+		task, err := mgr.NewTask(uid1, date)
+		require.NoError(t, err)
+		task.TomatoNum = estimate
+
+		for i := 0; i < finished; i++ {
+			p, err := main.NewPomodoro(task, 25*time.Minute)
+			require.NoError(t, err)
+			p.StartedAt = p.StartedAt.Add(-30 * time.Minute)
+			p.Deadline = p.StartedAt.Add(25 * time.Minute)
+			require.NoError(t, p.Finish())
+		}
+
+		require.NoError(t, task.SetStatus(main.TaskDone, time.Now()))
+		require.NoError(t, task.Save())
+		return task
+	}
+
+	newDoneTask(day1, 2, 1)
+	newDoneTask(day2, 1, 2)
+	// Unfinished tasks are not counted.
+	_, err := mgr.NewTask(uid1, day2)
+	require.NoError(t, err)
+
+	accuracy, err := mgr.EstimateAccuracy(uid1, day1, day2)
+	require.NoError(t, err)
+	assert.Equal(t, "2019-04-01", accuracy.Begin)
+	assert.Equal(t, 2, accuracy.Tasks)
+	assert.Equal(t, 1, accuracy.Over)
+	assert.Equal(t, 1, accuracy.Under)
+	assert.Equal(t, 0, accuracy.Exact)
+	assert.Equal(t, 3, accuracy.Estimated)
+	assert.Equal(t, 3, accuracy.Actual)
+	assert.Equal(t, 1.0, accuracy.MeanAbsoluteError)
+	assert.Equal(t, 1.0, accuracy.Ratio)
+
+	accuracy, err = mgr.EstimateAccuracy(uid1, day1, day1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, accuracy.Tasks)
+	assert.Equal(t, 0.5, accuracy.Ratio)
+
+	_, err = mgr.EstimateAccuracy(uid1, day2, day1)
+	assert.Error(t, err)
+}
+
+func TestFetchStatsWithoutDailyStats(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	// A day that has data saved without DailyStats is computed from its report.
+	_, err := mgr.NewReport(uid1, day1)
+	require.NoError(t, err)
+	t1, err := mgr.NewTask(uid1, day1)
+	require.NoError(t, err)
+	require.NoError(t, t1.SetStatus(main.TaskDone, time.Now()))
+	require.NoError(t, t1.Save())
+
+	stats, err := mgr.FetchStats(uid1, day1, day1.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(stats.Days))
+	assert.Equal(t, 1, stats.Tasks)
+	assert.Equal(t, 1, stats.TasksDone)
+	assert.Equal(t, 1.0, stats.TaskCompletionRate)
+	assert.Nil(t, stats.BusiestHour)
+	assert.Equal(t, 1, stats.LongestStreak)
+}
+
+func TestCrossDateQueries(t *testing.T) {
+	store := newTestStore()
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2)
+
+	t1, err := mgr.NewTask(uid1, day1)
+	require.NoError(t, err)
+	t2, err := mgr.NewTask(uid1, day2)
+	require.NoError(t, err)
+	_, err = mgr.NewTask(uid1, day3)
+	require.NoError(t, err)
+	_, err = mgr.NewTask(uuid.New().String(), day1)
+	require.NoError(t, err)
+
+	tasks, err := mgr.FetchTasksInRange(uid1, day1, day2)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(tasks))
+	assert.Equal(t, t1.TaskID, tasks[0].TaskID)
+	assert.Equal(t, t2.TaskID, tasks[1].TaskID)
+
+	found, err := mgr.FindTask(uid1, t2.TaskID)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, t2.SKey, found.SKey)
+	_, err = mgr.FindTask(uid1, "nothing")
+	assert.True(t, errors.Is(err, main.ErrNotFound))
+	_, err = mgr.FindChore(uid1, "nothing")
+	assert.True(t, errors.Is(err, main.ErrNotFound))
+
+	c1, err := mgr.NewChore(uid1, day3)
+	require.NoError(t, err)
+	chores, err := mgr.FetchChoresInRange(uid1, day1, day3)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(chores))
+	foundChore, err := mgr.FindChore(uid1, c1.ChoreID)
+	require.NoError(t, err)
+	require.NotNil(t, foundChore)
+
+	p1, err := main.NewPomodoro(t2, time.Hour)
+	require.NoError(t, err)
+	pomodoros, err := mgr.FetchPomodorosInRange(uid1, day1, day3)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pomodoros))
+	assert.Equal(t, p1.PomodoroID, pomodoros[0].PomodoroID)
+
+	_, err = mgr.FetchTasksInRange(uid1, day2, day1)
+	assert.Error(t, err)
+
+	// Items saved without index attributes are found after backfill.
+	legacy := main.Task{PKey: uid1 + "/task/20190402", SKey: "legacy", UserID: uid1, TaskID: "legacy", CreatedAt: day2, Title: "old"}
+	require.NoError(t, store.Put(legacy))
+	_, err = mgr.FindTask(uid1, "legacy")
+	assert.True(t, errors.Is(err, main.ErrNotFound))
+
+	n, err := main.BackfillIndex(store)
+	require.NoError(t, err)
+	assert.True(t, n >= 1)
+	backfilled, err := mgr.FindTask(uid1, "legacy")
+	require.NoError(t, err)
+	require.NotNil(t, backfilled)
+	assert.Equal(t, "old", backfilled.Title)
+
+	n, err = main.BackfillIndex(store)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+// editAfterGetStore calls edit once after the item of key is read.
+type editAfterGetStore struct {
+	main.Store
+	key  [2]string
+	edit func()
+}
+
+func (x *editAfterGetStore) Get(pk, sk string, out interface{}) error {
+	err := x.Store.Get(pk, sk, out)
+	if x.edit != nil && x.key == [2]string{pk, sk} {
+		edit := x.edit
+		x.edit = nil
+		edit()
+	}
+	return err
+}
+
+func (x *editAfterGetStore) Scan(f func(pk, sk string) error) error {
+	return x.Store.(main.Scanner).Scan(f)
+}
+
+func TestBackfillConcurrentEdit(t *testing.T) {
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	legacy := main.Task{PKey: uid1 + "/task/20190401", SKey: "legacy", UserID: uid1, TaskID: "legacy", CreatedAt: day1, Title: "old"}
+
+	store := &editAfterGetStore{Store: newTestStore(), key: [2]string{legacy.PKey, legacy.SKey}}
+	require.NoError(t, store.Put(legacy))
+
+	// The task is changed after backfill reads it.
+	store.edit = func() {
+		edited := legacy
+		edited.Title = "new"
+		require.NoError(t, store.Store.Put(edited))
+	}
+
+	_, err := main.BackfillIndex(store)
+	require.NoError(t, err)
+
+	backfilled, err := main.NewKitchenManager(store).FindTask(uid1, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "new", backfilled.Title)
+}
+
+// removeCountStore is a Store with SearchIndex that counts removed documents.
+type removeCountStore struct {
+	main.Store
+	removed int
+}
+
+func (x *removeCountStore) IndexDocument(doc main.SearchDocument) error { return nil }
+
+func (x *removeCountStore) RemoveDocument(userID string, kind main.SearchKind, id string) error {
+	x.removed++
+	return nil
+}
+
+func (x *removeCountStore) Search(userID string, q main.SearchQuery) ([]main.SearchHit, error) {
+	return nil, nil
+}
+
+func TestReportNotesRemoval(t *testing.T) {
+	store := &removeCountStore{Store: newTestStore()}
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	// A report without notes is not removed from search on each save.
+	report, err := mgr.NewReport(uid1, day1)
+	require.NoError(t, err)
+	report.Status = main.ReportWorking
+	require.NoError(t, report.Save())
+	assert.Equal(t, 0, store.removed)
+
+	report.Notes = &main.ReportNotes{Summary: "Released the build"}
+	require.NoError(t, report.Save())
+	assert.Equal(t, 0, store.removed)
+
+	saved, err := mgr.GetReport(uid1, day1)
+	require.NoError(t, err)
+	saved.Notes = nil
+	require.NoError(t, saved.Save())
+	assert.Equal(t, 1, store.removed)
+
+	require.NoError(t, saved.Save())
+	assert.Equal(t, 1, store.removed)
+}
+
+func TestSearch(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	day2 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	newTask := func(date time.Time, title, description string) *main.Task {
+		task, err := mgr.NewTask(uid1, date)
+		require.NoError(t, err)
+		task.Title, task.Description = title, description
+		require.NoError(t, task.Save())
+		return task
+	}
+
+	t1 := newTask(day1, "Fix the deploy script", "")
+	t2 := newTask(day2, "Write docs", "about the deploy script")
+	newTask(day2, "Review", "")
+	t4 := newTask(day2, "デプロイ手順を確認", "")
+
+	chore, err := mgr.NewChore(uid1, day2)
+	require.NoError(t, err)
+	chore.Title = "Deploy weekly build"
+	require.NoError(t, chore.Save())
+
+	// Words in title rank higher than in description.
+	hits, err := mgr.Search(uid1, main.SearchQuery{Text: "Deploy Script"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(hits))
+	assert.Equal(t, t1.TaskID, hits[0].ID)
+	assert.Equal(t, "Fix the deploy script", hits[0].Title)
+	assert.Equal(t, "2019-04-01", hits[0].Date)
+	assert.Equal(t, t2.TaskID, hits[1].ID)
+
+	hits, err = mgr.Search(uid1, main.SearchQuery{Text: "deploy", Kinds: []main.SearchKind{main.SearchChore}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(hits))
+	assert.Equal(t, chore.ChoreID, hits[0].ID)
+
+	hits, err = mgr.Search(uid1, main.SearchQuery{Text: "deploy", Begin: day2, End: day2})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(hits))
+
+	hits, err = mgr.Search(uid1, main.SearchQuery{Text: "デプロイ"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(hits))
+	assert.Equal(t, t4.TaskID, hits[0].ID)
+
+	// Updated and deleted documents are reflected.
+	t1.Title = "Fix the build"
+	require.NoError(t, t1.Save())
+	require.NoError(t, t2.Delete())
+	hits, err = mgr.Search(uid1, main.SearchQuery{Text: "script"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(hits))
+
+	// Cleared notes of a report are removed.
+	report, err := mgr.NewReport(uid1, day1)
+	require.NoError(t, err)
+	report.Notes = &main.ReportNotes{Summary: "Released the build"}
+	require.NoError(t, report.Save())
+	hits, err = mgr.Search(uid1, main.SearchQuery{Text: "released", Kinds: []main.SearchKind{main.SearchReport}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(hits))
+	report.Notes = nil
+	require.NoError(t, report.Save())
+	hits, err = mgr.Search(uid1, main.SearchQuery{Text: "released", Kinds: []main.SearchKind{main.SearchReport}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(hits))
+
+	_, err = mgr.Search(uid1, main.SearchQuery{Text: " "})
+	assert.Error(t, err)
+	_, err = mgr.Search(uid1, main.SearchQuery{Text: "deploy", Kinds: []main.SearchKind{"note"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type TeamRole string
+
+const (
+	// TeamOwner can manage members and delete the team.
+	TeamOwner TeamRole = "owner"
+	// TeamAdmin can manage members.
+	TeamAdmin TeamRole = "admin"
+	// TeamMember can view daily data of other members.
+	TeamMember TeamRole = "member"
+)
+
+func (x TeamRole) valid() bool {
+	return x == TeamOwner || x == TeamAdmin || x == TeamMember
+}
+
+func (x TeamRole) canManage() bool {
+	return x == TeamOwner || x == TeamAdmin
+}
+
+type Team struct {
+	PKey      string    `dynamo:"pk" json:"-"`
+	SKey      string    `dynamo:"sk" json:"-"`
+	TeamID    string    `dynamo:"team_id" json:"team_id"`
+	Name      string    `dynamo:"name" json:"name"`
+	CreatedBy string    `dynamo:"created_by" json:"created_by"`
+	CreatedAt time.Time `dynamo:"created_at" json:"created_at"`
+
+	store Store
+}
+
+// Membership is a user's role in a team. It is stored both in the team
+// partition to list members and in the user partition to list teams.
+type Membership struct {
+	PKey     string    `dynamo:"pk" json:"-"`
+	SKey     string    `dynamo:"sk" json:"-"`
+	TeamID   string    `dynamo:"team_id" json:"team_id"`
+	UserID   string    `dynamo:"user_id" json:"user_id"`
+	Role     TeamRole  `dynamo:"role" json:"role"`
+	JoinedAt time.Time `dynamo:"joined_at" json:"joined_at"`
+	// Pending is true while the user has not accepted the invitation. A
+	// pending member neither shares data with the team nor sees data of it.
+	Pending bool `dynamo:"pending,omitempty" json:"pending,omitempty"`
+}
+
+const teamMemberPrefix = "member/"
+
+func toTeamKey(teamID string) (string, string) {
+	pk := fmt.Sprintf("team/%s", teamID)
+	sk := "meta"
+	return pk, sk
+}
+
+func toTeamMemberKey(teamID, userID string) (string, string) {
+	pk := fmt.Sprintf("team/%s", teamID)
+	sk := teamMemberPrefix + userID
+	return pk, sk
+}
+
+func toUserTeamKey(userID, teamID string) (string, string) {
+	pk := fmt.Sprintf("%s/team", userID)
+	sk := teamID
+	return pk, sk
+}
+
+// NewTeam creates a team and makes userID the owner.
+func (x KitchenManager) NewTeam(userID, name string) (*Team, error) {
+	team := Team{
+		TeamID:    strings.Replace(uuid.New().String(), "-", "", -1),
+		Name:      name,
+		CreatedBy: userID,
+		CreatedAt: time.Now().UTC(),
+		store:     x.store,
+	}
+	team.PKey, team.SKey = toTeamKey(team.TeamID)
+
+	if err := x.store.Put(team); err != nil {
+		return nil, errors.Wrapf(err, "Fail to save team: %s", team.PKey)
+	}
+
+	owner := Membership{
+		TeamID:   team.TeamID,
+		UserID:   userID,
+		Role:     TeamOwner,
+		JoinedAt: team.CreatedAt,
+	}
+	if err := team.putMember(owner); err != nil {
+		return nil, err
+	}
+
+	return &team, nil
+}
+
+func (x KitchenManager) GetTeam(teamID string) (*Team, error) {
+	var team Team
+	pk, sk := toTeamKey(teamID)
+
+	if err := x.store.Get(pk, sk, &team); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Team not found: %s", teamID)
+		}
+		return nil, errors.Wrapf(err, "Fail to get team: %s", teamID)
+	}
+
+	team.store = x.store
+	return &team, nil
+}
+
+// FetchUserTeams returns memberships of the user.
+func (x KitchenManager) FetchUserTeams(userID string) ([]Membership, error) {
+	var memberships []Membership
+	pk, _ := toUserTeamKey(userID, "")
+
+	if err := x.store.Query(pk, AnySortKey(), &memberships); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch teams: %s", pk)
+	}
+
+	return memberships, nil
+}
+
+// sharesTeam returns true if both of users are members of the same team and
+// have accepted the invitation.
+func (x KitchenManager) sharesTeam(userID, otherID string) (bool, error) {
+	memberships, err := x.FetchUserTeams(userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range memberships {
+		if m.Pending {
+			continue
+		}
+
+		var member Membership
+		pk, sk := toTeamMemberKey(m.TeamID, otherID)
+		if err := x.store.Get(pk, sk, &member); err == nil {
+			if !member.Pending {
+				return true, nil
+			}
+		} else if !errors.Is(err, errItemNotFound) {
+			return false, errors.Wrapf(err, "Fail to get team member: %s %s", pk, sk)
+		}
+	}
+
+	return false, nil
+}
+
+func (x *Team) FetchMembers() ([]Membership, error) {
+	var members []Membership
+	pk, _ := toTeamMemberKey(x.TeamID, "")
+
+	if err := x.store.Query(pk, SortKeyBeginsWith(teamMemberPrefix), &members); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch team members: %s", pk)
+	}
+
+	return members, nil
+}
+
+// GetMember returns membership of the user, or ErrNotFound error if not a
+// member.
+func (x *Team) GetMember(userID string) (*Membership, error) {
+	var member Membership
+	pk, sk := toTeamMemberKey(x.TeamID, userID)
+
+	if err := x.store.Get(pk, sk, &member); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Member not found: %s", userID)
+		}
+		return nil, errors.Wrapf(err, "Fail to get team member: %s %s", pk, sk)
+	}
+
+	return &member, nil
+}
+
+// SetMember invites the user to the team, or changes role of the user. An
+// invited user is a pending member until Accept by the user.
+func (x *Team) SetMember(userID string, role TeamRole) error {
+	member := Membership{
+		TeamID:   x.TeamID,
+		UserID:   userID,
+		Role:     role,
+		JoinedAt: time.Now().UTC(),
+		Pending:  true,
+	}
+
+	if current, err := x.GetMember(userID); err == nil {
+		member.JoinedAt = current.JoinedAt
+		member.Pending = current.Pending
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return x.putMember(member)
+}
+
+// Accept makes the invited user an active member.
+func (x *Team) Accept(userID string) error {
+	member, err := x.GetMember(userID)
+	if errors.Is(err, ErrNotFound) {
+		return newNotFoundError("Invitation not found: %s", x.TeamID)
+	} else if err != nil {
+		return err
+	}
+	if !member.Pending {
+		return nil
+	}
+
+	member.Pending = false
+	member.JoinedAt = time.Now().UTC()
+	return x.putMember(*member)
+}
+
+// putMember saves both records of the membership.
+func (x *Team) putMember(member Membership) error {
+	userID := member.UserID
+	member.PKey, member.SKey = toTeamMemberKey(x.TeamID, userID)
+	if err := x.store.Put(member); err != nil {
+		return errors.Wrapf(err, "Fail to save team member: %s %s", member.PKey, member.SKey)
+	}
+
+	member.PKey, member.SKey = toUserTeamKey(userID, x.TeamID)
+	if err := x.store.Put(member); err != nil {
+		return errors.Wrapf(err, "Fail to save user team: %s %s", member.PKey, member.SKey)
+	}
+
+	return nil
+}
+
+func (x *Team) RemoveMember(userID string) error {
+	pk, sk := toUserTeamKey(userID, x.TeamID)
+	if err := x.store.Delete(pk, sk); err != nil {
+		return errors.Wrapf(err, "Fail to delete user team: %s %s", pk, sk)
+	}
+
+	pk, sk = toTeamMemberKey(x.TeamID, userID)
+	if err := x.store.Delete(pk, sk); err != nil {
+		return errors.Wrapf(err, "Fail to delete team member: %s %s", pk, sk)
+	}
+
+	return nil
+}
+
+// MemberReportStatus is a status of a member's daily report. Status is empty
+// if the member has no report of the day.
+type MemberReportStatus struct {
+	UserID string       `json:"user_id"`
+	Role   TeamRole     `json:"role"`
+	Status ReportStatus `json:"status"`
+}
+
+type TeamReportStatus struct {
+	TeamID  string               `json:"team_id"`
+	Date    string               `json:"date"`
+	Members []MemberReportStatus `json:"members"`
+	// Summary is number of members by report status.
+	Summary map[ReportStatus]int `json:"summary"`
+}
+
+// FetchTeamReportStatus aggregates report status of all members for the date.
+func (x KitchenManager) FetchTeamReportStatus(team *Team, date time.Time) (*TeamReportStatus, error) {
+	members, err := team.FetchMembers()
+	if err != nil {
+		return nil, err
+	}
+
+	result := TeamReportStatus{
+		TeamID:  team.TeamID,
+		Date:    date.Format("2006-01-02"),
+		Members: []MemberReportStatus{},
+		Summary: map[ReportStatus]int{},
+	}
+
+	for _, member := range members {
+		if member.Pending {
+			continue
+		}
+
+		status := MemberReportStatus{UserID: member.UserID, Role: member.Role}
+		// Status is empty if the member has no report.
+		if report, err := x.GetReport(member.UserID, date); err == nil {
+			status.Status = report.Status
+			result.Summary[report.Status]++
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		result.Members = append(result.Members, status)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,122 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m-mizutani/task-kitchen/api"
+)
+
+func TestTeam(t *testing.T) {
+	const secret = "test-secret"
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{
+		Authenticators: []api.Authenticator{api.NewHMACAuthenticator([]byte(secret), "")},
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	auth := func(user string) string {
+		return "Bearer " + signTestToken(t, secret, jwt.MapClaims{"sub": user, "exp": exp})
+	}
+	request := func(method, path, user string, body interface{}, out interface{}) int {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req := httptest.NewRequest(method, "/api/v1/"+path, &buf)
+		req.Header.Set("Authorization", auth(user))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if out != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		}
+		return w.Code
+	}
+
+	var created struct {
+		Results api.Team `json:"results"`
+	}
+	require.Equal(t, http.StatusOK, request("POST", "orange/teams", "orange", map[string]string{"name": "fruits"}, &created))
+	teamID := created.Results.TeamID
+	require.NotEmpty(t, teamID)
+
+	// orange has a report and a task before blue joins.
+	require.Equal(t, http.StatusOK, request("GET", "orange/2019-04-01", "orange", nil, nil))
+	require.Equal(t, http.StatusOK, request("POST", "orange/2019-04-01/task", "orange", map[string]string{"title": "five"}, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "orange/2019-04-01/task", "blue", nil, nil))
+
+	assert.Equal(t, http.StatusNotFound, request("PUT", "blue/teams/"+teamID+"/members/green", "blue", map[string]string{"role": "member"}, nil))
+	require.Equal(t, http.StatusOK, request("PUT", "orange/teams/"+teamID+"/members/blue", "orange", map[string]string{"role": "member"}, nil))
+	assert.Equal(t, http.StatusBadRequest, request("PUT", "orange/teams/"+teamID+"/members/green", "orange", map[string]string{"role": "boss"}, nil))
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "orange/teams/"+teamID+"/members/green", "orange", nil, nil))
+	assert.Equal(t, http.StatusNotFound, request("GET", "orange/teams/nothing", "orange", nil, nil))
+
+	var teams struct {
+		Results []api.Membership `json:"results"`
+	}
+	require.Equal(t, http.StatusOK, request("GET", "blue/teams", "blue", nil, &teams))
+	require.Equal(t, 1, len(teams.Results))
+	assert.Equal(t, api.TeamMember, teams.Results[0].Role)
+	assert.True(t, teams.Results[0].Pending)
+
+	// Adding blue does not expose data of blue to orange until blue accepts.
+	require.Equal(t, http.StatusOK, request("GET", "blue/2019-04-03", "blue", nil, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "blue/2019-04-03", "orange", nil, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "orange/2019-04-01/task", "blue", nil, nil))
+	assert.Equal(t, http.StatusNotFound, request("GET", "blue/teams/"+teamID, "blue", nil, nil))
+	// Only blue can accept the invitation.
+	assert.Equal(t, http.StatusForbidden, request("POST", "blue/teams/"+teamID+"/accept", "orange", nil, nil))
+	assert.Equal(t, http.StatusNotFound, request("POST", "green/teams/"+teamID+"/accept", "green", nil, nil))
+
+	var accepted struct {
+		Results api.Membership `json:"results"`
+	}
+	require.Equal(t, http.StatusOK, request("POST", "blue/teams/"+teamID+"/accept", "blue", nil, &accepted))
+	assert.False(t, accepted.Results.Pending)
+	assert.Equal(t, http.StatusOK, request("GET", "blue/2019-04-03", "orange", nil, nil))
+
+	// Teammates can read report, task and pomodoro, but can not write.
+	assert.Equal(t, http.StatusOK, request("GET", "orange/2019-04-01", "blue", nil, nil))
+	assert.Equal(t, http.StatusOK, request("GET", "orange/2019-04-01/task", "blue", nil, nil))
+	assert.Equal(t, http.StatusOK, request("GET", "orange/2019-04-01/pomodoro", "blue", nil, nil))
+	assert.Equal(t, http.StatusForbidden, request("POST", "orange/2019-04-01/task", "blue", map[string]string{"title": "six"}, nil))
+	assert.Equal(t, http.StatusForbidden, request("PUT", "orange/2019-04-01", "blue", map[string]string{"Status": "done"}, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "orange/2019-04-01/chore", "blue", nil, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "orange/tokens", "blue", nil, nil))
+	// A teammate does not create a report of other member.
+	assert.Equal(t, http.StatusNotFound, request("GET", "orange/2019-04-02", "blue", nil, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "orange/2019-04-01", "green", nil, nil))
+
+	// Members can not manage other members.
+	assert.Equal(t, http.StatusForbidden, request("PUT", "blue/teams/"+teamID+"/members/green", "blue", map[string]string{"role": "member"}, nil))
+
+	require.Equal(t, http.StatusOK, request("PUT", "orange/2019-04-01", "orange", map[string]string{"Status": "done"}, nil))
+	var status struct {
+		Results api.TeamReportStatus `json:"results"`
+	}
+	require.Equal(t, http.StatusOK, request("GET", "blue/teams/"+teamID+"/2019-04-01", "blue", nil, &status))
+	require.Equal(t, 2, len(status.Results.Members))
+	for _, m := range status.Results.Members {
+		switch m.UserID {
+		case "orange":
+			assert.Equal(t, api.ReportStatus("done"), m.Status)
+		case "blue":
+			assert.Equal(t, api.ReportStatus(""), m.Status)
+		}
+	}
+	assert.Equal(t, 1, status.Results.Summary["done"])
+
+	// Leaving the team removes visibility.
+	require.Equal(t, http.StatusOK, request("DELETE", "blue/teams/"+teamID+"/members/blue", "blue", nil, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "orange/2019-04-01/task", "blue", nil, nil))
+	assert.Equal(t, http.StatusBadRequest, request("DELETE", "orange/teams/"+teamID+"/members/orange", "orange", nil, nil))
+}
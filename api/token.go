@@ -0,0 +1,200 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Token is a personal API token. Only SHA-256 digest of the secret is stored.
+type Token struct {
+	PKey      string    `dynamo:"pk" json:"-"`
+	SKey      string    `dynamo:"sk" json:"-"`
+	UserID    string    `dynamo:"user_id" json:"user_id"`
+	TokenID   string    `dynamo:"token_id" json:"token_id"`
+	Name      string    `dynamo:"name" json:"name"`
+	Hash      string    `dynamo:"hash" json:"-"`
+	Scopes    []string  `dynamo:"scopes" json:"scopes"`
+	CreatedAt time.Time `dynamo:"created_at" json:"created_at"`
+	// ExpiresAt and LastUsedAt are zero if not set.
+	ExpiresAt  time.Time `dynamo:"expires_at,omitempty" json:"expires_at"`
+	LastUsedAt time.Time `dynamo:"last_used_at,omitempty" json:"last_used_at"`
+
+	store Store
+}
+
+// tokenRef is an item to look up a token by digest of the secret.
+type tokenRef struct {
+	PKey    string `dynamo:"pk"`
+	SKey    string `dynamo:"sk"`
+	UserID  string `dynamo:"user_id"`
+	TokenID string `dynamo:"token_id"`
+}
+
+const (
+	tokenPrefix = "tkp_"
+	// tokenTouchInterval suppresses writes of LastUsedAt on every request.
+	tokenTouchInterval = time.Minute
+)
+
+func toTokenKey(userID string, tokenID string) (string, string) {
+	pk := fmt.Sprintf("%s/token", userID)
+	sk := tokenID
+	return pk, sk
+}
+
+func toTokenRefKey(hash string) (string, string) {
+	pk := fmt.Sprintf("token/%s", hash)
+	sk := "ref"
+	return pk, sk
+}
+
+// NewToken creates a token and returns it with the secret. The secret can not
+// be retrieved later. Zero expiresAt means no expiration.
+func (x KitchenManager) NewToken(userID, name string, scopes []string, expiresAt time.Time) (*Token, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", errors.Wrap(err, "Fail to generate token secret")
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	token := Token{
+		UserID:    userID,
+		TokenID:   strings.Replace(uuid.New().String(), "-", "", -1),
+		Name:      name,
+		Hash:      HashAPIKey(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+		store:     x.store,
+	}
+	token.PKey, token.SKey = toTokenKey(token.UserID, token.TokenID)
+
+	ref := tokenRef{UserID: token.UserID, TokenID: token.TokenID}
+	ref.PKey, ref.SKey = toTokenRefKey(token.Hash)
+
+	if err := x.store.Put(ref); err != nil {
+		return nil, "", errors.Wrapf(err, "Fail to save token ref: %s", token.TokenID)
+	}
+	if err := token.Save(); err != nil {
+		return nil, "", err
+	}
+
+	return &token, secret, nil
+}
+
+func (x KitchenManager) GetToken(userID, tokenID string) (*Token, error) {
+	var token Token
+	pk, sk := toTokenKey(userID, tokenID)
+
+	if err := x.store.Get(pk, sk, &token); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Token not found: %s", tokenID)
+		}
+		return nil, errors.Wrapf(err, "Fail to get token: %s", tokenID)
+	}
+
+	token.store = x.store
+	return &token, nil
+}
+
+func (x KitchenManager) FetchTokens(userID string) ([]Token, error) {
+	var tokens []Token
+	pk, _ := toTokenKey(userID, "")
+
+	if err := x.store.Query(pk, AnySortKey(), &tokens); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch tokens: %s", pk)
+	}
+
+	return tokens, nil
+}
+
+// lookupToken returns a token that has the secret, or ErrNotFound error if not
+// found.
+func (x KitchenManager) lookupToken(secret string) (*Token, error) {
+	var ref tokenRef
+	pk, sk := toTokenRefKey(HashAPIKey(secret))
+
+	if err := x.store.Get(pk, sk, &ref); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Token not found")
+		}
+		return nil, errors.Wrap(err, "Fail to get token ref")
+	}
+
+	return x.GetToken(ref.UserID, ref.TokenID)
+}
+
+func (x *Token) Save() error {
+	if err := x.store.Put(x); err != nil {
+		return errors.Wrapf(err, "Fail to save token: %s", x.PKey)
+	}
+
+	return nil
+}
+
+// Revoke deletes the token and its lookup item.
+func (x *Token) Revoke() error {
+	pk, sk := toTokenRefKey(x.Hash)
+	if err := x.store.Delete(pk, sk); err != nil {
+		return errors.Wrapf(err, "Fail to delete token ref: %s", x.TokenID)
+	}
+
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
+		return errors.Wrapf(err, "Fail to delete token: %s", x.PKey)
+	}
+
+	return nil
+}
+
+func (x *Token) expired(now time.Time) bool {
+	return !x.ExpiresAt.IsZero() && !now.Before(x.ExpiresAt)
+}
+
+// touch records the last used time, at most once per tokenTouchInterval.
+func (x *Token) touch(now time.Time) error {
+	if now.Sub(x.LastUsedAt) < tokenTouchInterval {
+		return nil
+	}
+
+	x.LastUsedAt = now
+	return x.Save()
+}
+
+// --------------------------------
+// Authenticator
+// --------------------------------
+
+type tokenAuthenticator struct {
+	mgr *KitchenManager
+}
+
+func (x *tokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	secret := bearerToken(r)
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, nil
+	}
+
+	token, err := x.mgr.lookupToken(secret)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errors.New("Unknown personal token")
+	} else if err != nil {
+		return nil, err
+	}
+	if token.expired(time.Now()) {
+		return nil, errors.Errorf("Personal token has been expired: %s", token.TokenID)
+	}
+
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return &Principal{UserID: token.UserID, Scopes: scopes, token: token}, nil
+}
@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m-mizutani/task-kitchen/api"
+)
+
+func TestPersonalToken(t *testing.T) {
+	const secret = "test-secret"
+	r := gin.New()
+	api.SetupRouter(r.Group("/api/v1"), api.NewMemoryStore(), api.Options{
+		Authenticators: []api.Authenticator{api.NewHMACAuthenticator([]byte(secret), "")},
+	})
+
+	jwtAuth := "Bearer " + signTestToken(t, secret, jwt.MapClaims{
+		"sub": "orange", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	request := func(method, path, auth string, body interface{}, out interface{}) int {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req := httptest.NewRequest(method, "/api/v1/"+path, &buf)
+		req.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if out != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		}
+		return w.Code
+	}
+
+	var created struct {
+		Results struct {
+			Token  api.Token `json:"token"`
+			Secret string    `json:"secret"`
+		} `json:"results"`
+	}
+	code := request("POST", "orange/tokens", jwtAuth, map[string]interface{}{
+		"name":   "cli",
+		"scopes": []string{"read", "tasks:write"},
+	}, &created)
+	require.Equal(t, http.StatusOK, code)
+	require.NotEmpty(t, created.Results.Secret)
+	tokenAuth := "Bearer " + created.Results.Secret
+
+	assert.Equal(t, http.StatusOK, request("GET", "orange/2019-04-01/task", tokenAuth, nil, nil))
+	assert.Equal(t, http.StatusOK, request("POST", "orange/2019-04-01/task", tokenAuth, nil, nil))
+	assert.Equal(t, http.StatusForbidden, request("PUT", "orange/2019-04-01", tokenAuth, map[string]string{"Status": "done"}, nil))
+	assert.Equal(t, http.StatusForbidden, request("GET", "blue/2019-04-01", tokenAuth, nil, nil))
+	// Token can not create another token without tokens:write
+	assert.Equal(t, http.StatusForbidden, request("POST", "orange/tokens", tokenAuth, map[string]interface{}{"scopes": []string{"read"}}, nil))
+
+	assert.Equal(t, http.StatusBadRequest, request("POST", "orange/tokens", jwtAuth, map[string]interface{}{"scopes": []string{"admin"}}, nil))
+	assert.Equal(t, http.StatusBadRequest, request("POST", "orange/tokens", jwtAuth, map[string]interface{}{
+		"scopes": []string{"read"}, "expires_at": time.Now().Add(-time.Hour),
+	}, nil))
+
+	var listed struct {
+		Results []map[string]interface{} `json:"results"`
+	}
+	require.Equal(t, http.StatusOK, request("GET", "orange/tokens", jwtAuth, nil, &listed))
+	require.Equal(t, 1, len(listed.Results))
+	assert.Equal(t, "cli", listed.Results[0]["name"])
+	assert.NotEqual(t, "0001-01-01T00:00:00Z", listed.Results[0]["last_used_at"])
+	assert.NotContains(t, listed.Results[0], "hash")
+
+	require.Equal(t, http.StatusOK, request("DELETE", "orange/tokens/"+created.Results.Token.TokenID, jwtAuth, nil, nil))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "orange/2019-04-01/task", tokenAuth, nil, nil))
+}
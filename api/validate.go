@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+// Rules of request bodies are declared by "binding" tags of structs, and
+// checked by gin when binding and by validateStruct when saving. Rule names
+// of FieldError are stable to be localized by clients. Rules of validator are
+// used as is, e.g. "required", "max" and "min", with the rules below.
+const (
+	ruleTaskStatus   = "task_status"
+	ruleReportStatus = "report_status"
+	ruleTeamRole     = "team_role"
+	// ruleDate is a date like 2006-01-02.
+	ruleDate = "date"
+	// ruleMaxSpan is maximum number of days of a date range.
+	ruleMaxSpan = "max_span"
+	// ruleType is for a value of wrong JSON type.
+	ruleType = "type"
+	// ruleTemplate is a body of report template that can not be parsed.
+	ruleTemplate = "template"
+	// ruleWebhookURL is a public http or https URL.
+	ruleWebhookURL = "webhook_url"
+	// ruleWebhookEvent is an event filter of webhook such as "task.*".
+	ruleWebhookEvent = "webhook_event"
+	// ruleScope is a scope of personal token.
+	ruleScope = "scope"
+)
+
+// FieldError is a violation of a rule by a field of a request. Field is a
+// path of the field in JSON such as "notes.summary", or a name of a query.
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	// Param is a parameter of the rule, e.g. "256" of max.
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func init() {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	engine.RegisterTagNameFunc(fieldName)
+	for rule, f := range map[string]validator.Func{
+		ruleTaskStatus: func(fl validator.FieldLevel) bool {
+			return TaskStatus(fl.Field().String()).valid()
+		},
+		ruleReportStatus: func(fl validator.FieldLevel) bool {
+			return ReportStatus(fl.Field().String()).valid()
+		},
+		ruleDate: func(fl validator.FieldLevel) bool {
+			_, err := time.Parse("2006-01-02", fl.Field().String())
+			return err == nil
+		},
+		ruleWebhookURL: func(fl validator.FieldLevel) bool {
+			return validWebhookURL(fl.Field().String())
+		},
+		ruleWebhookEvent: func(fl validator.FieldLevel) bool {
+			return validEventFilter(fl.Field().String())
+		},
+		ruleScope: func(fl validator.FieldLevel) bool {
+			return knownScopes[fl.Field().String()]
+		},
+		ruleTeamRole: func(fl validator.FieldLevel) bool {
+			return TeamRole(fl.Field().String()).valid()
+		},
+	} {
+		if err := engine.RegisterValidation(rule, f); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// fieldName is a name of the field in JSON or query, which is used as Field of
+// FieldError.
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		name := strings.SplitN(field.Tag.Get(key), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// newValidationError returns 400 error with violations.
+func newValidationError(fieldErrs ...FieldError) *userError {
+	msgs := make([]string, len(fieldErrs))
+	for i, fe := range fieldErrs {
+		msgs[i] = fe.Message
+	}
+
+	err := newUserError(400, "%s", strings.Join(msgs, ", "))
+	err.fields = fieldErrs
+	return err
+}
+
+// newFieldError returns 400 error of a violation of the rule by the field.
+func newFieldError(field, rule, param, msg string, args ...interface{}) *userError {
+	return newValidationError(FieldError{
+		Field:   field,
+		Rule:    rule,
+		Param:   param,
+		Message: fmt.Sprintf(msg, args...),
+	})
+}
+
+// bindJSON binds the request body to obj and validates it. name is a kind of
+// the request for a message of a malformed body. A request without body is
+// not an error if allowEmpty is true, and obj is not changed.
+func bindJSON(c *gin.Context, obj interface{}, name string, allowEmpty bool) error {
+	if allowEmpty && c.Request.ContentLength == 0 {
+		return nil
+	}
+
+	if err := c.ShouldBindJSON(obj); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			typeName := jsonTypeName(typeErr.Type)
+			return newFieldError(typeErr.Field, ruleType, typeName,
+				"%s should be %s, got %s", typeErr.Field, typeName, typeErr.Value).setCause(err)
+		}
+		if fieldErrs := toFieldErrors(err); fieldErrs != nil {
+			return newValidationError(fieldErrs...).setCause(err)
+		}
+		if errors.Is(err, io.EOF) {
+			return newUserError(400, "%s is required", name).setCause(err)
+		}
+		return newUserError(400, "Invalid %s: %s", name, err).setCause(err)
+	}
+
+	return nil
+}
+
+// validateStruct checks rules of tags of obj.
+func validateStruct(obj interface{}) error {
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		if fieldErrs := toFieldErrors(err); fieldErrs != nil {
+			return newValidationError(fieldErrs...).setCause(err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// toFieldErrors converts errors of validator. It returns nil for other errors.
+func toFieldErrors(err error) []FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+
+	fieldErrs := make([]FieldError, len(errs))
+	for i, e := range errs {
+		// Namespace starts with name of the struct.
+		field := e.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+
+		fieldErrs[i] = FieldError{
+			Field:   field,
+			Rule:    e.Tag(),
+			Param:   e.Param(),
+			Message: ruleMessage(field, e),
+		}
+	}
+
+	return fieldErrs
+}
+
+func ruleMessage(field string, e validator.FieldError) string {
+	var unit string
+	switch e.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Map:
+		unit = " items"
+	}
+
+	switch e.Tag() {
+	case "required":
+		return field + " is required"
+	case "max":
+		return field + " should be " + e.Param() + unit + " or less"
+	case "min":
+		return field + " should be at least " + e.Param() + unit
+	case "gt":
+		// gt without param is after now for time.
+		if e.Param() == "" {
+			return field + " should be in the future"
+		}
+		return field + " should be greater than " + e.Param()
+	case ruleDate:
+		return field + " should be a date like 2006-01-02"
+	case ruleWebhookURL:
+		return field + " should be http or https URL, got '" + toString(e.Value()) + "'"
+	case ruleScope:
+		return "Unknown scope: '" + toString(e.Value()) + "'"
+	case ruleWebhookEvent:
+		return "Unknown event: '" + toString(e.Value()) + "', \"*\" for all events"
+	default:
+		return "Invalid " + field + ": '" + toString(e.Value()) + "'"
+	}
+}
+
+func toString(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(string(raw), `"`)
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// putVersioned saves the item with its Version incremented, only if the
+// stored item still has the previous version. version points Version of the
+// item, and it is not changed if the save fails. It returns errVersionConflict
+// if the item has been changed by another request.
+func putVersioned(store Store, item interface{}, version *int) error {
+	prev := *version
+	*version = prev + 1
+
+	if err := store.PutVersion(item, prev); err != nil {
+		*version = prev
+		return err
+	}
+
+	return nil
+}
+
+// conflictError reads the stored item into current and returns a 409 error
+// with it. name is a kind of the item such as "Task".
+func conflictError(store Store, name, pk, sk string, current interface{}) error {
+	if err := store.Get(pk, sk, current); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return newUserError(409, "%s has been deleted by another request", name)
+		}
+		return errors.Wrapf(err, "Fail to get current %s: %s %s", name, pk, sk)
+	}
+
+	return newUserError(409, "%s has been updated by another request", name).setCurrent(current)
+}
+
+// versionETag is an entity tag of a version of an entity.
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
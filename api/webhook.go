@@ -0,0 +1,600 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Events sent to webhooks.
+const (
+	EventTaskSaved     = "task.saved"
+	EventTaskDeleted   = "task.deleted"
+	EventChoreSaved    = "chore.saved"
+	EventChoreDeleted  = "chore.deleted"
+	EventReportSaved   = "report.saved"
+	EventReportDeleted = "report.deleted"
+	// EventReportDone is sent in addition to EventReportSaved when a report
+	// becomes ReportDone.
+	EventReportDone        = "report.done"
+	EventPomodoroStarted   = "pomodoro.started"
+	EventPomodoroFinished  = "pomodoro.finished"
+	EventPomodoroAbandoned = "pomodoro.abandoned"
+	EventPomodoroDeleted   = "pomodoro.deleted"
+	// EventPing is sent only by PingWebhook.
+	EventPing = "ping"
+)
+
+var knownEvents = map[string]bool{
+	EventTaskSaved:         true,
+	EventTaskDeleted:       true,
+	EventChoreSaved:        true,
+	EventChoreDeleted:      true,
+	EventReportSaved:       true,
+	EventReportDeleted:     true,
+	EventReportDone:        true,
+	EventPomodoroStarted:   true,
+	EventPomodoroFinished:  true,
+	EventPomodoroAbandoned: true,
+	EventPomodoroDeleted:   true,
+}
+
+// Status of webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	webhookSecretPrefix = "whsec_"
+	// WebhookSignatureHeader has "sha256=" and hex encoded HMAC-SHA256 of the
+	// request body by the secret of the webhook.
+	WebhookSignatureHeader = "X-Kitchen-Signature"
+	WebhookEventHeader     = "X-Kitchen-Event"
+	WebhookDeliveryHeader  = "X-Kitchen-Delivery"
+
+	// A failed delivery is retried after webhookRetryBase, then twice as long
+	// as the previous one, until webhookMaxAttempts.
+	webhookMaxAttempts = 5
+	webhookRetryBase   = 30 * time.Second
+)
+
+// webhookClient connects only to addresses allowed by webhookAddrAllowed, so
+// that a host resolved to a private address after saving the webhook and a
+// redirect to it are also rejected.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: controlWebhookDial,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// webhookAddrAllowed decides if webhooks can be sent to the IP address. Tests
+// replace it to receive webhooks by local servers.
+var webhookAddrAllowed = isPublicIP
+
+// nonPublicNetworks are special purpose networks not covered by methods of
+// net.IP.
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// isPublicIP returns false for loopback, private (RFC 1918 and unique local),
+// link-local including cloud metadata endpoints, multicast and other special
+// purpose addresses.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func controlWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !webhookAddrAllowed(ip) {
+		return fmt.Errorf("Webhook to non-public address is not allowed: %s", host)
+	}
+	return nil
+}
+
+// validateWebhookHost resolves the host and rejects it if any address is not
+// allowed.
+func validateWebhookHost(host string) error {
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return newFieldError("url", ruleWebhookURL, "", "Fail to resolve host of webhook URL: '%s'", host).setCause(err)
+	}
+
+	for _, addr := range addrs {
+		if !webhookAddrAllowed(addr) {
+			return newFieldError("url", ruleWebhookURL, "", "Webhook URL should be a public address: '%s' is %s", host, addr)
+		}
+	}
+	return nil
+}
+
+// Webhook is a subscription of events of the user. Events has event names
+// such as "task.saved", "task.*" for all events of tasks or "*" for all.
+type Webhook struct {
+	PKey      string    `dynamo:"pk" json:"-"`
+	SKey      string    `dynamo:"sk" json:"-"`
+	UserID    string    `dynamo:"user_id" json:"user_id"`
+	WebhookID string    `dynamo:"webhook_id" json:"webhook_id"`
+	URL       string    `dynamo:"url" json:"url" binding:"required,max=2048,webhook_url"`
+	Events    []string  `dynamo:"events" json:"events" binding:"required,min=1,dive,webhook_event"`
+	Active    bool      `dynamo:"active" json:"active"`
+	Secret    string    `dynamo:"secret" json:"-"`
+	CreatedAt time.Time `dynamo:"created_at" json:"created_at"`
+
+	store Store
+}
+
+// Event is a change of data of a user. It is the request body of a webhook
+// delivery and data of an event stream.
+type Event struct {
+	EventID   string          `json:"event_id"`
+	Event     string          `json:"event"`
+	UserID    string          `json:"user_id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookDelivery is a log of sending an event to a webhook.
+type WebhookDelivery struct {
+	PKey       string `dynamo:"pk" json:"-"`
+	SKey       string `dynamo:"sk" json:"-"`
+	UserID     string `dynamo:"user_id" json:"user_id"`
+	WebhookID  string `dynamo:"webhook_id" json:"webhook_id"`
+	DeliveryID string `dynamo:"delivery_id" json:"delivery_id"`
+	Event      string `dynamo:"event" json:"event"`
+	Payload    string `dynamo:"payload" json:"payload"`
+
+	Status   string `dynamo:"status" json:"status"`
+	Attempts int    `dynamo:"attempts" json:"attempts"`
+	// ResponseCode and LastError are of the last attempt.
+	ResponseCode int       `dynamo:"response_code,omitempty" json:"response_code,omitempty"`
+	LastError    string    `dynamo:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt    time.Time `dynamo:"created_at" json:"created_at"`
+	// NextAttemptAt is zero unless the delivery is pending.
+	NextAttemptAt time.Time `dynamo:"next_attempt_at,omitempty" json:"next_attempt_at"`
+	LastAttemptAt time.Time `dynamo:"last_attempt_at,omitempty" json:"last_attempt_at"`
+}
+
+// pendingDelivery is an index item of a pending delivery sorted by time of the
+// next attempt, to find deliveries of all users.
+type pendingDelivery struct {
+	PKey       string `dynamo:"pk"`
+	SKey       string `dynamo:"sk"`
+	DeliveryPK string `dynamo:"delivery_pk"`
+	DeliverySK string `dynamo:"delivery_sk"`
+}
+
+const pendingDeliveryPartition = "webhook/pending"
+
+func toWebhookKey(userID, webhookID string) (string, string) {
+	pk := fmt.Sprintf("%s/webhook", userID)
+	sk := webhookID
+	return pk, sk
+}
+
+// toWebhookDeliveryKey makes sort key begin with time so that deliveries are
+// listed in order of creation.
+func toWebhookDeliveryKey(userID, webhookID string, createdAt time.Time, deliveryID string) (string, string) {
+	pk := fmt.Sprintf("%s/webhook_delivery/%s", userID, webhookID)
+	sk := fmt.Sprintf("%s/%s", createdAt.UTC().Format("20060102T150405.000000"), deliveryID)
+	return pk, sk
+}
+
+func toPendingDeliveryKey(next time.Time, deliveryID string) (string, string) {
+	pk := pendingDeliveryPartition
+	sk := fmt.Sprintf("%s/%s", next.UTC().Format("20060102T150405"), deliveryID)
+	return pk, sk
+}
+
+// WebhookSignature returns value of WebhookSignatureHeader for the body.
+// Receivers can verify requests with it.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// matchEvent returns true if the event is subscribed by the filter.
+func matchEvent(filters []string, event string) bool {
+	for _, f := range filters {
+		switch {
+		case f == "*", f == event:
+			return true
+		case strings.HasSuffix(f, ".*") && strings.HasPrefix(event, strings.TrimSuffix(f, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// validEventFilter returns true if the filter is "*", a known event or a
+// prefix of known events such as "task.*".
+func validEventFilter(f string) bool {
+	if f == "*" || knownEvents[f] {
+		return true
+	}
+
+	if strings.HasSuffix(f, ".*") {
+		prefix := strings.TrimSuffix(f, "*")
+		for event := range knownEvents {
+			if strings.HasPrefix(event, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validWebhookURL returns true if the URL is a http or https URL with host.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// NewWebhook creates a webhook with a new secret to sign payloads. The webhook
+// is not saved.
+func (x KitchenManager) NewWebhook(userID string) (*Webhook, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, errors.Wrap(err, "Fail to generate webhook secret")
+	}
+
+	hook := Webhook{
+		UserID:    userID,
+		WebhookID: strings.Replace(uuid.New().String(), "-", "", -1),
+		Active:    true,
+		Secret:    webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(raw),
+		CreatedAt: time.Now().UTC(),
+		store:     x.store,
+	}
+	hook.PKey, hook.SKey = toWebhookKey(hook.UserID, hook.WebhookID)
+
+	return &hook, nil
+}
+
+func (x KitchenManager) GetWebhook(userID, webhookID string) (*Webhook, error) {
+	var hook Webhook
+	pk, sk := toWebhookKey(userID, webhookID)
+
+	if err := x.store.Get(pk, sk, &hook); err != nil {
+		if errors.Is(err, errItemNotFound) {
+			return nil, newNotFoundError("Webhook not found: %s", webhookID)
+		}
+		return nil, errors.Wrapf(err, "Fail to get webhook: %s", webhookID)
+	}
+
+	hook.store = x.store
+	return &hook, nil
+}
+
+func (x KitchenManager) FetchWebhooks(userID string) ([]Webhook, error) {
+	return fetchWebhooks(x.store, userID)
+}
+
+func fetchWebhooks(store Store, userID string) ([]Webhook, error) {
+	var hooks []Webhook
+	pk, _ := toWebhookKey(userID, "")
+
+	if err := store.Query(pk, AnySortKey(), &hooks); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch webhooks: %s", pk)
+	}
+
+	for i := range hooks {
+		hooks[i].store = store
+	}
+	return hooks, nil
+}
+
+func (x *Webhook) Save() error {
+	if err := validateStruct(x); err != nil {
+		return err
+	}
+	u, err := url.Parse(x.URL)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to parse webhook URL: %s", x.URL)
+	}
+	if err := validateWebhookHost(u.Hostname()); err != nil {
+		return err
+	}
+
+	if err := x.store.Put(x); err != nil {
+		return errors.Wrapf(err, "Fail to save webhook: %s", x.PKey)
+	}
+
+	return nil
+}
+
+// Delete removes the webhook. Its pending deliveries fail at the next attempt.
+func (x *Webhook) Delete() error {
+	if err := x.store.Delete(x.PKey, x.SKey); err != nil {
+		return errors.Wrapf(err, "Fail to delete webhook: %s", x.PKey)
+	}
+
+	return nil
+}
+
+// FetchDeliveries returns delivery logs of the webhook from newer one.
+func (x *Webhook) FetchDeliveries() ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	pk, _ := toWebhookDeliveryKey(x.UserID, x.WebhookID, time.Time{}, "")
+
+	if err := x.store.Query(pk, AnySortKey(), &deliveries); err != nil {
+		return nil, errors.Wrapf(err, "Fail to fetch webhook deliveries: %s", pk)
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].SKey > deliveries[j].SKey
+	})
+	return deliveries, nil
+}
+
+// --------------------------------
+// Emit and deliver
+// --------------------------------
+
+// emitEvent publishes the event to the event stream of the day and queues
+// deliveries of it to active webhooks of the user that subscribe it. The data
+// is sent as JSON. Deliveries are sent by DeliverWebhooks. A failure is logged
+// and does not fail the change of data, which has been already saved.
+func emitEvent(store Store, broker Broker, userID string, date time.Time, event string, data interface{}) {
+	if err := queueEvent(store, broker, userID, date, event, data); err != nil {
+		Logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"event":   event,
+		}).Error("Fail to emit event")
+	}
+}
+
+func queueEvent(store Store, broker Broker, userID string, date time.Time, event string, data interface{}) error {
+	hooks, err := fetchWebhooks(store, userID)
+	if err != nil {
+		return err
+	}
+
+	var matched []Webhook
+	for _, hook := range hooks {
+		if hook.Active && matchEvent(hook.Events, event) {
+			matched = append(matched, hook)
+		}
+	}
+	if len(matched) == 0 && broker == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to marshal event data: %s", event)
+	}
+	now := time.Now().UTC()
+	ev := Event{
+		EventID:   strings.Replace(uuid.New().String(), "-", "", -1),
+		Event:     event,
+		UserID:    userID,
+		Timestamp: now,
+		Data:      raw,
+	}
+	publishEvent(broker, toStreamTopic(userID, date), ev)
+
+	if len(matched) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to marshal event: %s", event)
+	}
+
+	for _, hook := range matched {
+		delivery := newDelivery(&hook, event, payload, now)
+		if err := store.Put(delivery); err != nil {
+			return errors.Wrapf(err, "Fail to save webhook delivery: %s %s", delivery.PKey, delivery.SKey)
+		}
+		if err := scheduleDelivery(store, delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newDelivery(hook *Webhook, event string, payload []byte, now time.Time) *WebhookDelivery {
+	delivery := WebhookDelivery{
+		UserID:        hook.UserID,
+		WebhookID:     hook.WebhookID,
+		DeliveryID:    strings.Replace(uuid.New().String(), "-", "", -1),
+		Event:         event,
+		Payload:       string(payload),
+		Status:        DeliveryPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	delivery.PKey, delivery.SKey = toWebhookDeliveryKey(hook.UserID, hook.WebhookID, now, delivery.DeliveryID)
+
+	return &delivery
+}
+
+// PingWebhook sends EventPing to the webhook immediately and returns the
+// result. It is to check a receiver and is not retried.
+func (x KitchenManager) PingWebhook(hook *Webhook) (*WebhookDelivery, error) {
+	now := time.Now().UTC()
+	payload, err := json.Marshal(Event{
+		EventID:   strings.Replace(uuid.New().String(), "-", "", -1),
+		Event:     EventPing,
+		UserID:    hook.UserID,
+		Timestamp: now,
+		Data:      json.RawMessage(`{}`),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to marshal ping event")
+	}
+
+	delivery := newDelivery(hook, EventPing, payload, now)
+	if err := x.attemptDelivery(delivery, now, false); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+func scheduleDelivery(store Store, delivery *WebhookDelivery) error {
+	pending := pendingDelivery{DeliveryPK: delivery.PKey, DeliverySK: delivery.SKey}
+	pending.PKey, pending.SKey = toPendingDeliveryKey(delivery.NextAttemptAt, delivery.DeliveryID)
+	if err := store.Put(pending); err != nil {
+		return errors.Wrapf(err, "Fail to put pending delivery: %s %s", pending.PKey, pending.SKey)
+	}
+
+	return nil
+}
+
+// deliverWebhooks sends pending deliveries whose next attempt is not after
+// now. It returns number of attempts.
+func (x KitchenManager) deliverWebhooks(now time.Time) (int, error) {
+	var items []pendingDelivery
+	// "~" is greater than any character of delivery ID.
+	_, upper := toPendingDeliveryKey(now, "~")
+	if err := x.store.Query(pendingDeliveryPartition, SortKeyBetween("0", upper), &items); err != nil {
+		return 0, errors.Wrap(err, "Fail to fetch pending deliveries")
+	}
+
+	count := 0
+	for _, item := range items {
+		var delivery WebhookDelivery
+		if err := x.store.Get(item.DeliveryPK, item.DeliverySK, &delivery); err == nil {
+			if err := x.attemptDelivery(&delivery, now, true); err != nil {
+				return count, err
+			}
+			count++
+		} else if !errors.Is(err, errItemNotFound) {
+			return count, errors.Wrapf(err, "Fail to get webhook delivery: %s %s", item.DeliveryPK, item.DeliverySK)
+		}
+
+		if err := x.store.Delete(item.PKey, item.SKey); err != nil {
+			return count, errors.Wrapf(err, "Fail to delete pending delivery: %s %s", item.PKey, item.SKey)
+		}
+	}
+
+	return count, nil
+}
+
+// attemptDelivery sends the delivery once and saves the result. A failed
+// delivery is scheduled again with backoff if retry is true.
+func (x KitchenManager) attemptDelivery(delivery *WebhookDelivery, now time.Time, retry bool) error {
+	hook, err := x.GetWebhook(delivery.UserID, delivery.WebhookID)
+	if errors.Is(err, ErrNotFound) {
+		hook = nil
+	} else if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+	delivery.ResponseCode, delivery.LastError = 0, ""
+
+	if hook == nil {
+		delivery.LastError = "Webhook has been deleted"
+		delivery.Attempts = webhookMaxAttempts
+	} else {
+		delivery.ResponseCode, err = sendWebhook(hook, delivery)
+		if err != nil {
+			delivery.LastError = err.Error()
+		}
+	}
+
+	switch {
+	case delivery.LastError == "":
+		delivery.Status = DeliverySucceeded
+		delivery.NextAttemptAt = time.Time{}
+	case !retry || delivery.Attempts >= webhookMaxAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.NextAttemptAt = time.Time{}
+	default:
+		delivery.NextAttemptAt = now.Add(webhookRetryBase << uint(delivery.Attempts-1))
+		if err := scheduleDelivery(x.store, delivery); err != nil {
+			return err
+		}
+	}
+
+	if err := x.store.Put(delivery); err != nil {
+		return errors.Wrapf(err, "Fail to save webhook delivery: %s %s", delivery.PKey, delivery.SKey)
+	}
+
+	return nil
+}
+
+// sendWebhook posts the payload. Status code other than 2xx is an error.
+func sendWebhook(hook *Webhook, delivery *WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "task-kitchen-webhook")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.DeliveryID)
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(hook.Secret, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return resp.StatusCode, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
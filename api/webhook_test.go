@@ -0,0 +1,354 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/m-mizutani/task-kitchen/api"
+)
+
+type webhookReceiver struct {
+	mutex  sync.Mutex
+	events []main.Event
+	// fail is number of requests to respond 500.
+	fail int
+	t    *testing.T
+	// secret is to verify signatures. Not verified if empty.
+	secret string
+}
+
+func (x *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(x.t, err)
+	if x.secret != "" {
+		assert.Equal(x.t, main.WebhookSignature(x.secret, body), r.Header.Get(main.WebhookSignatureHeader))
+	}
+
+	if x.fail > 0 {
+		x.fail--
+		w.WriteHeader(500)
+		return
+	}
+
+	var event main.Event
+	require.NoError(x.t, json.Unmarshal(body, &event))
+	assert.Equal(x.t, event.Event, r.Header.Get(main.WebhookEventHeader))
+	x.events = append(x.events, event)
+}
+
+func (x *webhookReceiver) received() []string {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	var names []string
+	for _, e := range x.events {
+		names = append(names, e.Event)
+	}
+	return names
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	defer main.AllowLoopbackWebhooks()()
+
+	store := newTestStore()
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	receiver := &webhookReceiver{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook, err := mgr.NewWebhook(uid1)
+	require.NoError(t, err)
+	receiver.secret = hook.Secret
+	hook.URL = server.URL
+	hook.Events = []string{"unknown"}
+	assert.Error(t, hook.Save())
+	hook.Events = []string{"task.*", "report.done"}
+	hook.URL = "ftp://example.com"
+	assert.Error(t, hook.Save())
+	hook.URL = server.URL
+	require.NoError(t, hook.Save())
+
+	task, err := mgr.NewTask(uid1, day1)
+	require.NoError(t, err)
+	task.Title = "one"
+	require.NoError(t, task.Save())
+	chore, err := mgr.NewChore(uid1, day1)
+	require.NoError(t, err)
+	require.NoError(t, chore.Save())
+
+	report, err := mgr.NewReport(uid1, day1)
+	require.NoError(t, err)
+	report.Status = main.ReportDone
+	require.NoError(t, report.Save())
+	// Saving a done report again is not a change to done.
+	require.NoError(t, report.Save())
+	require.NoError(t, task.Delete())
+
+	// Events are not sent until DeliverWebhooks.
+	assert.Equal(t, 0, len(receiver.received()))
+
+	now := time.Now().UTC()
+	_, err = main.DeliverWebhooks(store, now)
+	require.NoError(t, err)
+	// NewTask saves the task once before the title is set.
+	assert.ElementsMatch(t, []string{"task.saved", "task.saved", "report.done", "task.deleted"}, receiver.received())
+
+	deliveries, err := hook.FetchDeliveries()
+	require.NoError(t, err)
+	require.Equal(t, 4, len(deliveries))
+	for _, d := range deliveries {
+		assert.Equal(t, main.DeliverySucceeded, d.Status)
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, 200, d.ResponseCode)
+	}
+
+	// Inactive webhook does not get events.
+	hook.Active = false
+	require.NoError(t, hook.Save())
+	require.NoError(t, task.Save())
+	_, err = main.DeliverWebhooks(store, now)
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(receiver.received()))
+}
+
+func TestWebhookRetry(t *testing.T) {
+	defer main.AllowLoopbackWebhooks()()
+
+	store := newTestStore()
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	receiver := &webhookReceiver{t: t, fail: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook, err := mgr.NewWebhook(uid1)
+	require.NoError(t, err)
+	hook.URL, hook.Events = server.URL, []string{"*"}
+	require.NoError(t, hook.Save())
+
+	chore, err := mgr.NewChore(uid1, day1)
+	require.NoError(t, err)
+	require.NoError(t, chore.Delete())
+
+	now := time.Now().UTC()
+	_, err = main.DeliverWebhooks(store, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(receiver.received()))
+
+	deliveries, err := hook.FetchDeliveries()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(deliveries))
+	for _, d := range deliveries {
+		assert.Equal(t, main.DeliveryPending, d.Status)
+		assert.Equal(t, 500, d.ResponseCode)
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, now.Add(30*time.Second), d.NextAttemptAt)
+	}
+
+	// Not retried before backoff.
+	n, err := main.DeliverWebhooks(store, now.Add(10*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = main.DeliverWebhooks(store, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"chore.saved", "chore.deleted"}, receiver.received())
+
+	deliveries, err = hook.FetchDeliveries()
+	require.NoError(t, err)
+	for _, d := range deliveries {
+		assert.Equal(t, main.DeliverySucceeded, d.Status)
+		assert.Equal(t, 2, d.Attempts)
+		assert.True(t, d.NextAttemptAt.IsZero())
+	}
+
+	// A delivery fails after max attempts.
+	receiver.fail = 10
+	require.NoError(t, chore.Save())
+	at := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		_, err = main.DeliverWebhooks(store, at)
+		require.NoError(t, err)
+		at = at.Add(time.Hour)
+	}
+	deliveries, err = hook.FetchDeliveries()
+	require.NoError(t, err)
+	assert.Equal(t, main.DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, 5, deliveries[0].Attempts)
+	n, err = main.DeliverWebhooks(store, at)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	mgr := main.NewKitchenManager(newTestStore())
+	uid1 := uuid.New().String()
+
+	receiver := &webhookReceiver{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook, err := mgr.NewWebhook(uid1)
+	require.NoError(t, err)
+	hook.Events = []string{"*"}
+	for _, url := range []string{
+		server.URL,
+		"http://localhost:8080/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fd00:ec2::254]/hook",
+	} {
+		hook.URL = url
+		err := hook.Save()
+		assert.True(t, errors.Is(err, main.ErrValidation), "%s: %v", url, err)
+	}
+	hook.URL = "http://93.184.216.34/hook"
+	assert.NoError(t, hook.Save())
+
+	// A host resolved to a private address after saving is rejected at
+	// connecting.
+	restore := main.AllowLoopbackWebhooks()
+	hook.URL = server.URL
+	require.NoError(t, hook.Save())
+	restore()
+
+	delivery, err := mgr.PingWebhook(hook)
+	require.NoError(t, err)
+	assert.Equal(t, main.DeliveryFailed, delivery.Status)
+	assert.Contains(t, delivery.LastError, "non-public address")
+	assert.Equal(t, 0, len(receiver.received()))
+}
+
+// deliveryFailStore fails to save webhook deliveries.
+type deliveryFailStore struct {
+	main.Store
+}
+
+func (x *deliveryFailStore) Put(item interface{}) error {
+	if _, ok := item.(*main.WebhookDelivery); ok {
+		return errors.New("delivery is not saved")
+	}
+	return x.Store.Put(item)
+}
+
+func TestEmitEventFailure(t *testing.T) {
+	defer main.AllowLoopbackWebhooks()()
+
+	store := &deliveryFailStore{Store: newTestStore()}
+	mgr := main.NewKitchenManager(store)
+	uid1 := uuid.New().String()
+	day1 := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	hook, err := mgr.NewWebhook(uid1)
+	require.NoError(t, err)
+	hook.URL, hook.Events = "http://127.0.0.1/hook", []string{"*"}
+	require.NoError(t, hook.Save())
+
+	// Changes of data are saved even if events are not.
+	task, err := mgr.NewTask(uid1, day1)
+	require.NoError(t, err)
+	task.Title = "one"
+	require.NoError(t, task.Save())
+	require.NoError(t, task.Delete())
+
+	report, err := mgr.NewReport(uid1, day1)
+	require.NoError(t, err)
+	report.Status = main.ReportDone
+	require.NoError(t, report.Save())
+	saved, err := mgr.GetReport(uid1, day1)
+	require.NoError(t, err)
+	require.NotNil(t, saved)
+	assert.Equal(t, main.ReportStatus(main.ReportDone), saved.Status)
+}
+
+func TestWebhookAPI(t *testing.T) {
+	defer main.AllowLoopbackWebhooks()()
+
+	type Created struct {
+		Results struct {
+			Webhook main.Webhook `json:"webhook"`
+			Secret  string       `json:"secret"`
+		} `json:"results,omitempty"`
+	}
+	type Delivery struct {
+		Results main.WebhookDelivery `json:"results,omitempty"`
+	}
+	type Deliveries struct {
+		Results []main.WebhookDelivery `json:"results,omitempty"`
+	}
+	var (
+		code       int
+		err        error
+		created    Created
+		delivery   Delivery
+		deliveries Deliveries
+	)
+	uid := uuid.New().String()
+
+	receiver := &webhookReceiver{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	code, err = httpRequest("POST", uid+"/webhooks", map[string]interface{}{"url": server.URL, "events": []string{"task.nothing"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, code)
+
+	code, err = httpRequest("POST", uid+"/webhooks", map[string]interface{}{"url": server.URL, "events": []string{"pomodoro.*"}}, &created)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.True(t, created.Results.Webhook.Active)
+	require.NotEmpty(t, created.Results.Secret)
+	receiver.secret = created.Results.Secret
+	path := uid + "/webhooks/" + created.Results.Webhook.WebhookID
+
+	code, err = httpRequest("POST", path+"/ping", nil, &delivery)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, main.DeliverySucceeded, delivery.Results.Status)
+	assert.Equal(t, []string{"ping"}, receiver.received())
+
+	code, err = httpRequest("GET", path+"/deliveries", nil, &deliveries)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.Equal(t, 1, len(deliveries.Results))
+	assert.Equal(t, "ping", deliveries.Results[0].Event)
+
+	code, err = httpRequest("PUT", path, map[string]interface{}{"url": server.URL, "events": []string{"*"}, "active": false}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	var hook struct {
+		Results map[string]interface{} `json:"results,omitempty"`
+	}
+	code, err = httpRequest("GET", path, nil, &hook)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	assert.Equal(t, false, hook.Results["active"])
+	assert.NotContains(t, hook.Results, "secret")
+
+	code, err = httpRequest("DELETE", path, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	code, err = httpRequest("GET", path, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 404, code)
+}
@@ -0,0 +1,37 @@
+// Command backfill sets secondary index attributes to tasks, chores and
+// pomodoros saved before the indexes were introduced. It reads the same config
+// as server and should be run once after the indexes are added to the table.
+package main
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-mizutani/task-kitchen/api"
+	"github.com/m-mizutani/task-kitchen/config"
+)
+
+var logger = logrus.New()
+
+func main() {
+	api.Logger = logger
+
+	cfg := config.Default()
+	if err := cfg.Load(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Fail to load config")
+	}
+	cfg.SetupLogger(logger)
+
+	store, err := cfg.NewStore()
+	if err != nil {
+		logger.WithError(err).Fatal("Fail to setup store")
+	}
+
+	n, err := api.BackfillIndex(store)
+	if err != nil {
+		logger.WithError(err).WithField("updated", n).Fatal("Fail to backfill index")
+	}
+
+	logger.WithField("updated", n).Info("Backfilled index")
+}
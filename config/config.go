@@ -0,0 +1,513 @@
+// Package config loads settings of server and lambda entrypoints from a
+// config file, environment variables and command line flags. Later sources
+// override earlier ones in that order.
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-mizutani/task-kitchen/api"
+)
+
+// Storage backend types.
+const (
+	StoreDynamoDB = "dynamodb"
+	StoreBolt     = "bolt"
+	StoreMemory   = "memory"
+)
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// EnvConfigPath is an environment variable to specify config file path. The
+// -config flag takes precedence.
+const EnvConfigPath = "TASK_KITCHEN_CONFIG"
+
+const envPrefix = "TASK_KITCHEN_"
+
+// Config is settings of task-kitchen binaries.
+type Config struct {
+	// ListenAddr is used only by server.
+	ListenAddr  string          `json:"listen_addr"`
+	BasePath    string          `json:"base_path"`
+	LogLevel    string          `json:"log_level"`
+	LogFormat   string          `json:"log_format"`
+	CORSOrigins []string        `json:"cors_origins"`
+	Store       StoreConfig     `json:"store"`
+	Auth        AuthConfig      `json:"auth"`
+	Features    map[string]bool `json:"features"`
+	// CarryOver is copy or move to carry unfinished tasks over to the next
+	// day when a report becomes done. Empty disables it.
+	CarryOver string `json:"carry_over"`
+	// PomodoroSweepInterval is interval of finishing overdue pomodoros in
+	// server, such as "1m". "0" disables it. Lambda uses a scheduled event.
+	PomodoroSweepInterval string `json:"pomodoro_sweep_interval"`
+	// ReportGenerateInterval is interval of rendering auto-generated report
+	// templates of done reports in server. "0" disables it. Lambda uses a
+	// scheduled event.
+	ReportGenerateInterval string `json:"report_generate_interval"`
+	// WebhookDeliverInterval is interval of sending webhook deliveries in
+	// server. "0" disables it. Lambda uses a scheduled event.
+	WebhookDeliverInterval string `json:"webhook_deliver_interval"`
+}
+
+// StoreConfig is settings of storage backend.
+type StoreConfig struct {
+	Type     string           `json:"type"`
+	BoltPath string           `json:"bolt_path"`
+	DynamoDB api.DynamoConfig `json:"dynamodb"`
+}
+
+// AuthConfig is settings of authentication.
+type AuthConfig struct {
+	Enabled bool           `json:"enabled"`
+	JWT     JWTConfig      `json:"jwt"`
+	APIKeys []APIKeyConfig `json:"api_keys"`
+}
+
+// JWTConfig is settings of bearer token verification.
+type JWTConfig struct {
+	// Algorithm is HS256 or RS256.
+	Algorithm string `json:"algorithm"`
+	// Secret is HMAC key for HS256.
+	Secret string `json:"secret"`
+	// PublicKeyFile is a PEM file of RSA public key for RS256.
+	PublicKeyFile string `json:"public_key_file"`
+	// Issuer is checked with iss claim if not empty.
+	Issuer string `json:"issuer"`
+}
+
+// APIKeyConfig is a static API key for X-API-Key header.
+type APIKeyConfig struct {
+	// SHA256 is hex encoded SHA-256 digest of the key, see api.HashAPIKey.
+	SHA256 string   `json:"sha256"`
+	UserID string   `json:"user_id"`
+	Grants []string `json:"grants"`
+}
+
+// Default returns settings for local server.
+func Default() Config {
+	return Config{
+		ListenAddr: "127.0.0.1:9080",
+		BasePath:   "/api/v1",
+		LogLevel:   "debug",
+		LogFormat:  LogFormatText,
+		Store: StoreConfig{
+			Type:     StoreDynamoDB,
+			BoltPath: "task-kitchen.db",
+		},
+		Auth: AuthConfig{
+			JWT: JWTConfig{Algorithm: "HS256"},
+		},
+		PomodoroSweepInterval:  "1m",
+		ReportGenerateInterval: "1m",
+		WebhookDeliverInterval: "5s",
+	}
+}
+
+// Load overwrites settings by a config file, environment variables and args
+// (command line flags), then validates them. Positional args [region]
+// [table_name] are also accepted for DynamoDB.
+func (x *Config) Load(args []string) error {
+	configPath := os.Getenv(EnvConfigPath)
+
+	// First pass only finds config file path. Flags are applied again after
+	// loading the file and environment variables to take precedence.
+	pre := *x
+	pre.Features = nil
+	fs := newFlagSet(&pre, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if configPath != "" {
+		if err := x.loadFile(configPath); err != nil {
+			return err
+		}
+	}
+
+	if err := x.loadEnv(); err != nil {
+		return err
+	}
+
+	fs = newFlagSet(x, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch fs.NArg() {
+	case 0:
+	case 2:
+		x.Store.DynamoDB.Region, x.Store.DynamoDB.TableName = fs.Arg(0), fs.Arg(1)
+	default:
+		return errors.New("syntax error) [options] [region table_name]")
+	}
+
+	return x.Validate()
+}
+
+func (x *Config) loadFile(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to read config file: %s", path)
+	}
+
+	if err := json.Unmarshal(raw, x); err != nil {
+		return errors.Wrapf(err, "Fail to parse config file: %s", path)
+	}
+
+	return nil
+}
+
+func (x *Config) loadEnv() error {
+	setString := func(key string, dst *string) {
+		if v := os.Getenv(key); v != "" {
+			*dst = v
+		}
+	}
+
+	setString(envPrefix+"LISTEN_ADDR", &x.ListenAddr)
+	setString(envPrefix+"BASE_PATH", &x.BasePath)
+	setString(envPrefix+"LOG_LEVEL", &x.LogLevel)
+	setString(envPrefix+"LOG_FORMAT", &x.LogFormat)
+	setString(envPrefix+"STORE", &x.Store.Type)
+	setString(envPrefix+"BOLT_PATH", &x.Store.BoltPath)
+	setString(envPrefix+"JWT_ALGORITHM", &x.Auth.JWT.Algorithm)
+	setString(envPrefix+"JWT_SECRET", &x.Auth.JWT.Secret)
+	setString(envPrefix+"JWT_PUBLIC_KEY_FILE", &x.Auth.JWT.PublicKeyFile)
+	setString(envPrefix+"JWT_ISSUER", &x.Auth.JWT.Issuer)
+	setString(envPrefix+"CARRY_OVER", &x.CarryOver)
+	setString(envPrefix+"POMODORO_SWEEP_INTERVAL", &x.PomodoroSweepInterval)
+	setString(envPrefix+"REPORT_GENERATE_INTERVAL", &x.ReportGenerateInterval)
+	setString(envPrefix+"WEBHOOK_DELIVER_INTERVAL", &x.WebhookDeliverInterval)
+
+	if v := os.Getenv(envPrefix + "CORS_ORIGINS"); v != "" {
+		x.CORSOrigins = splitList(v)
+	}
+
+	if v := os.Getenv(envPrefix + "AUTH_ENABLED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid %sAUTH_ENABLED: '%s'", envPrefix, v)
+		}
+		x.Auth.Enabled = b
+	}
+
+	if v := os.Getenv(envPrefix + "FEATURES"); v != "" {
+		x.setFeatures(v)
+	}
+
+	// Variables given by CloudFormation template of Lambda function.
+	setString("AWS_REGION", &x.Store.DynamoDB.Region)
+	setString("TABLE_NAME", &x.Store.DynamoDB.TableName)
+
+	return x.Store.DynamoDB.LoadEnv()
+}
+
+// setFeatures parses comma separated feature toggles, such as
+// "chore,-pomodoro". A name with "-" prefix turns off the feature.
+func (x *Config) setFeatures(s string) {
+	if x.Features == nil {
+		x.Features = make(map[string]bool)
+	}
+
+	for _, name := range splitList(s) {
+		enabled := !strings.HasPrefix(name, "-")
+		x.Features[strings.TrimPrefix(name, "-")] = enabled
+	}
+}
+
+type featureFlag struct{ cfg *Config }
+
+func (x featureFlag) String() string { return "" }
+func (x featureFlag) Set(s string) error {
+	x.cfg.setFeatures(s)
+	return nil
+}
+
+type listFlag struct{ dst *[]string }
+
+func (x listFlag) String() string {
+	if x.dst == nil {
+		return ""
+	}
+	return strings.Join(*x.dst, ",")
+}
+func (x listFlag) Set(s string) error {
+	*x.dst = splitList(s)
+	return nil
+}
+
+func newFlagSet(cfg *Config, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	fs.StringVar(configPath, "config", *configPath, "Config file (JSON)")
+	fs.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "Listen address of server")
+	fs.StringVar(&cfg.BasePath, "base-path", cfg.BasePath, "Base path of API")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Log level: trace, debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log format: text or json")
+	fs.Var(listFlag{&cfg.CORSOrigins}, "cors-origins", "Comma separated allowed origins of CORS, '*' allows any")
+
+	fs.StringVar(&cfg.Store.Type, "store", cfg.Store.Type, "Storage backend: dynamodb, bolt or memory")
+	fs.StringVar(&cfg.Store.BoltPath, "db", cfg.Store.BoltPath, "Database file for bolt storage backend")
+	fs.StringVar(&cfg.Store.DynamoDB.Region, "region", cfg.Store.DynamoDB.Region, "AWS region of DynamoDB")
+	fs.StringVar(&cfg.Store.DynamoDB.TableName, "table", cfg.Store.DynamoDB.TableName, "DynamoDB table name")
+	fs.StringVar(&cfg.Store.DynamoDB.Endpoint, "endpoint", cfg.Store.DynamoDB.Endpoint, "DynamoDB endpoint URL, e.g. http://localhost:8000")
+	fs.BoolVar(&cfg.Store.DynamoDB.CreateTable, "create-table", cfg.Store.DynamoDB.CreateTable, "Create DynamoDB table if it does not exist")
+
+	fs.BoolVar(&cfg.Auth.Enabled, "auth", cfg.Auth.Enabled, "Enable authentication")
+	fs.StringVar(&cfg.Auth.JWT.Algorithm, "jwt-algorithm", cfg.Auth.JWT.Algorithm, "JWT signing algorithm: HS256 or RS256")
+	fs.StringVar(&cfg.Auth.JWT.PublicKeyFile, "jwt-public-key", cfg.Auth.JWT.PublicKeyFile, "PEM file of RSA public key for RS256")
+	fs.StringVar(&cfg.Auth.JWT.Issuer, "jwt-issuer", cfg.Auth.JWT.Issuer, "Expected iss claim of JWT")
+
+	fs.StringVar(&cfg.CarryOver, "carry-over", cfg.CarryOver, "Carry unfinished tasks over to the next day when a report is done: copy or move")
+	fs.StringVar(&cfg.PomodoroSweepInterval, "pomodoro-sweep-interval", cfg.PomodoroSweepInterval, "Interval to finish overdue pomodoros, 0 disables")
+	fs.StringVar(&cfg.ReportGenerateInterval, "report-generate-interval", cfg.ReportGenerateInterval, "Interval to render report templates of done reports, 0 disables")
+	fs.StringVar(&cfg.WebhookDeliverInterval, "webhook-deliver-interval", cfg.WebhookDeliverInterval, "Interval to send webhook deliveries, 0 disables")
+	fs.Var(featureFlag{cfg}, "features", "Comma separated feature toggles, '-' prefix disables, e.g. -features=-chore")
+
+	return fs
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ValidationError has all invalid settings found by Validate.
+type ValidationError struct {
+	Problems []string
+}
+
+func (x *ValidationError) Error() string {
+	return "Invalid config: " + strings.Join(x.Problems, "; ")
+}
+
+func (x *ValidationError) add(field, msg string, args ...interface{}) {
+	x.Problems = append(x.Problems, field+": "+fmt.Sprintf(msg, args...))
+}
+
+// Validate checks settings and returns *ValidationError if invalid.
+func (x *Config) Validate() error {
+	verr := &ValidationError{}
+
+	if x.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(x.ListenAddr); err != nil {
+			verr.add("listen_addr", "should be host:port, got '%s'", x.ListenAddr)
+		}
+	}
+
+	if !strings.HasPrefix(x.BasePath, "/") {
+		verr.add("base_path", "should start with '/', got '%s'", x.BasePath)
+	}
+
+	if _, err := logrus.ParseLevel(x.LogLevel); err != nil {
+		verr.add("log_level", "unknown level '%s'", x.LogLevel)
+	}
+
+	if x.LogFormat != LogFormatText && x.LogFormat != LogFormatJSON {
+		verr.add("log_format", "should be text or json, got '%s'", x.LogFormat)
+	}
+
+	for _, origin := range x.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			verr.add("cors_origins", "should be '*' or like https://example.com, got '%s'", origin)
+		}
+	}
+
+	switch x.Store.Type {
+	case StoreDynamoDB:
+		if x.Store.DynamoDB.Region == "" {
+			verr.add("store.dynamodb.region", "required for dynamodb store")
+		}
+		if x.Store.DynamoDB.TableName == "" {
+			verr.add("store.dynamodb.table_name", "required for dynamodb store")
+		}
+		if (x.Store.DynamoDB.AccessKeyID == "") != (x.Store.DynamoDB.SecretAccessKey == "") {
+			verr.add("store.dynamodb", "access_key_id and secret_access_key should be set together")
+		}
+	case StoreBolt:
+		if x.Store.BoltPath == "" {
+			verr.add("store.bolt_path", "required for bolt store")
+		}
+	case StoreMemory:
+	default:
+		verr.add("store.type", "should be dynamodb, bolt or memory, got '%s'", x.Store.Type)
+	}
+
+	if x.Auth.Enabled {
+		switch x.Auth.JWT.Algorithm {
+		case "HS256":
+			if x.Auth.JWT.Secret == "" {
+				verr.add("auth.jwt.secret", "required for HS256")
+			}
+		case "RS256":
+			if x.Auth.JWT.PublicKeyFile == "" {
+				verr.add("auth.jwt.public_key_file", "required for RS256")
+			}
+		default:
+			verr.add("auth.jwt.algorithm", "should be HS256 or RS256, got '%s'", x.Auth.JWT.Algorithm)
+		}
+
+		for i, key := range x.Auth.APIKeys {
+			if digest, err := hex.DecodeString(key.SHA256); err != nil || len(digest) != sha256.Size {
+				verr.add(fmt.Sprintf("auth.api_keys[%d].sha256", i), "should be hex encoded SHA-256 digest")
+			}
+			if key.UserID == "" {
+				verr.add(fmt.Sprintf("auth.api_keys[%d].user_id", i), "required")
+			}
+		}
+	}
+
+	for name := range x.Features {
+		if !api.IsKnownFeature(name) {
+			verr.add("features", "unknown feature '%s'", name)
+		}
+	}
+
+	if x.CarryOver != "" && !api.IsValidCarryOverMode(x.CarryOver) {
+		verr.add("carry_over", "should be copy or move, got '%s'", x.CarryOver)
+	}
+
+	if d, err := time.ParseDuration(x.PomodoroSweepInterval); err != nil || d < 0 {
+		verr.add("pomodoro_sweep_interval", "should be duration like 1m, got '%s'", x.PomodoroSweepInterval)
+	}
+	if d, err := time.ParseDuration(x.ReportGenerateInterval); err != nil || d < 0 {
+		verr.add("report_generate_interval", "should be duration like 1m, got '%s'", x.ReportGenerateInterval)
+	}
+	if d, err := time.ParseDuration(x.WebhookDeliverInterval); err != nil || d < 0 {
+		verr.add("webhook_deliver_interval", "should be duration like 1m, got '%s'", x.WebhookDeliverInterval)
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// SetupLogger applies log level and format. Config must be validated.
+func (x *Config) SetupLogger(logger *logrus.Logger) {
+	if level, err := logrus.ParseLevel(x.LogLevel); err == nil {
+		logger.SetLevel(level)
+	}
+
+	if x.LogFormat == LogFormatJSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{})
+	}
+}
+
+// NewStore creates a storage backend by the settings.
+func (x *Config) NewStore() (api.Store, error) {
+	switch x.Store.Type {
+	case StoreDynamoDB:
+		return api.NewDynamoStore(x.Store.DynamoDB)
+	case StoreBolt:
+		return api.NewBoltStore(x.Store.BoltPath)
+	case StoreMemory:
+		return api.NewMemoryStore(), nil
+	default:
+		return nil, errors.Errorf("Invalid store type: '%s'", x.Store.Type)
+	}
+}
+
+// SweepInterval returns interval of pomodoro sweeper, or 0 if disabled. Config
+// must be validated.
+func (x *Config) SweepInterval() time.Duration {
+	d, _ := time.ParseDuration(x.PomodoroSweepInterval)
+	return d
+}
+
+// GenerateInterval returns interval of report generator, or 0 if disabled.
+// Config must be validated.
+func (x *Config) GenerateInterval() time.Duration {
+	d, _ := time.ParseDuration(x.ReportGenerateInterval)
+	return d
+}
+
+// DeliverInterval returns interval of webhook dispatcher, or 0 if disabled.
+// Config must be validated.
+func (x *Config) DeliverInterval() time.Duration {
+	d, _ := time.ParseDuration(x.WebhookDeliverInterval)
+	return d
+}
+
+// Options converts settings to options of api.SetupRouter.
+func (x *Config) Options() (api.Options, error) {
+	features := make(api.Features)
+	for name, enabled := range x.Features {
+		features[api.Feature(name)] = enabled
+	}
+
+	authenticators, err := x.authenticators()
+	if err != nil {
+		return api.Options{}, err
+	}
+
+	return api.Options{
+		Features:       features,
+		Authenticators: authenticators,
+		CarryOver:      api.CarryOverMode(x.CarryOver),
+	}, nil
+}
+
+func (x *Config) authenticators() ([]api.Authenticator, error) {
+	if !x.Auth.Enabled {
+		return nil, nil
+	}
+
+	var authenticators []api.Authenticator
+
+	switch x.Auth.JWT.Algorithm {
+	case "HS256":
+		authenticators = append(authenticators, api.NewHMACAuthenticator([]byte(x.Auth.JWT.Secret), x.Auth.JWT.Issuer))
+
+	case "RS256":
+		raw, err := ioutil.ReadFile(x.Auth.JWT.PublicKeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Fail to read public key file: %s", x.Auth.JWT.PublicKeyFile)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Fail to parse public key file: %s", x.Auth.JWT.PublicKeyFile)
+		}
+		authenticators = append(authenticators, api.NewRSAAuthenticator(key, x.Auth.JWT.Issuer))
+	}
+
+	if len(x.Auth.APIKeys) > 0 {
+		keys := make(map[string]api.Principal)
+		for _, key := range x.Auth.APIKeys {
+			keys[strings.ToLower(key.SHA256)] = api.Principal{UserID: key.UserID, Grants: key.Grants}
+		}
+		authenticators = append(authenticators, api.NewAPIKeyAuthenticator(keys))
+	}
+
+	return authenticators, nil
+}
@@ -0,0 +1,76 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/m-mizutani/task-kitchen/api"
+	"github.com/m-mizutani/task-kitchen/config"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "task-kitchen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(`{
+		"listen_addr": "0.0.0.0:8000",
+		"log_level": "warn",
+		"store": {"type": "bolt", "bolt_path": "/tmp/from-file.db"},
+		"features": {"chore": false}
+	}`), 0644))
+
+	os.Setenv("TASK_KITCHEN_LOG_LEVEL", "info")
+	defer os.Unsetenv("TASK_KITCHEN_LOG_LEVEL")
+
+	cfg := config.Default()
+	require.NoError(t, cfg.Load([]string{"-config", confPath, "-db", "/tmp/from-flag.db", "-cors-origins", "http://localhost:8080"}))
+
+	assert.Equal(t, "0.0.0.0:8000", cfg.ListenAddr)          // file
+	assert.Equal(t, "info", cfg.LogLevel)                    // env overrides file
+	assert.Equal(t, "/tmp/from-flag.db", cfg.Store.BoltPath) // flag overrides file
+	assert.Equal(t, "/api/v1", cfg.BasePath)                 // default
+	assert.Equal(t, []string{"http://localhost:8080"}, cfg.CORSOrigins)
+	opts, err := cfg.Options()
+	require.NoError(t, err)
+	assert.False(t, opts.Features.Enabled(api.FeatureChore))
+	assert.True(t, opts.Features.Enabled(api.FeaturePomodoro))
+	assert.Equal(t, 0, len(opts.Authenticators))
+}
+
+func TestLoadPositionalArgs(t *testing.T) {
+	cfg := config.Default()
+	require.NoError(t, cfg.Load([]string{"-features", "-pomodoro", "ap-northeast-1", "my-table"}))
+	assert.Equal(t, "ap-northeast-1", cfg.Store.DynamoDB.Region)
+	assert.Equal(t, "my-table", cfg.Store.DynamoDB.TableName)
+	opts, err := cfg.Options()
+	require.NoError(t, err)
+	assert.False(t, opts.Features.Enabled(api.FeaturePomodoro))
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.Default()
+	err := cfg.Load([]string{"-store", "dynamodb", "-region=", "-table=", "-log-format", "xml", "-base-path", "v1", "-features", "unknown", "-auth", "-carry-over", "keep", "-pomodoro-sweep-interval", "often", "-report-generate-interval", "-1m", "-webhook-deliver-interval", "soon"})
+	require.Error(t, err)
+
+	verr, ok := err.(*config.ValidationError)
+	require.True(t, ok)
+	assert.Contains(t, verr.Problems, "store.dynamodb.region: required for dynamodb store")
+	assert.Contains(t, verr.Problems, "log_format: should be text or json, got 'xml'")
+	assert.Contains(t, verr.Problems, "base_path: should start with '/', got 'v1'")
+	assert.Contains(t, verr.Problems, "features: unknown feature 'unknown'")
+	assert.Contains(t, verr.Problems, "auth.jwt.secret: required for HS256")
+	assert.Contains(t, verr.Problems, "carry_over: should be copy or move, got 'keep'")
+	assert.Contains(t, verr.Problems, "pomodoro_sweep_interval: should be duration like 1m, got 'often'")
+	assert.Contains(t, verr.Problems, "report_generate_interval: should be duration like 1m, got '-1m'")
+	assert.Contains(t, verr.Problems, "webhook_deliver_interval: should be duration like 1m, got 'soon'")
+
+	cfg = config.Default()
+	assert.NoError(t, cfg.Load([]string{"-store", "memory"}))
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -9,23 +11,76 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/m-mizutani/task-kitchen/api"
+	"github.com/m-mizutani/task-kitchen/config"
 	"github.com/sirupsen/logrus"
 )
 
 var logger = logrus.New()
 
 func main() {
-	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetLevel(logrus.InfoLevel)
 	api.Logger = logger
 
+	cfg := config.Default()
+	cfg.ListenAddr = ""
+	cfg.BasePath = "/v1"
+	cfg.LogLevel = "info"
+	cfg.LogFormat = config.LogFormatJSON
+	if err := cfg.Load(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Fail to load config")
+	}
+	cfg.SetupLogger(logger)
+
+	store, err := cfg.NewStore()
+	if err != nil {
+		logger.WithError(err).Fatal("Fail to setup store")
+	}
+	opts, err := cfg.Options()
+	if err != nil {
+		logger.WithError(err).Fatal("Fail to setup API options")
+	}
+	// No Broker is attached because an in-process Hub does not reach clients
+	// connected to other instances. Event streams respond 501 until a Broker
+	// across instances is given as opts.Broker.
+
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
-	v1 := r.Group("/v1")
-	api.SetupRouter(v1, os.Getenv("AWS_REGION"), os.Getenv("TABLE_NAME"))
+	if len(cfg.CORSOrigins) > 0 {
+		r.Use(api.CORS(cfg.CORSOrigins))
+	}
+	v1 := r.Group(cfg.BasePath)
+	api.SetupRouter(v1, store, opts)
 	ginLambda := ginadapter.New(r)
 
-	lambda.Start(func(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	lambda.Start(func(raw json.RawMessage) (interface{}, error) {
+		// Scheduled event finishes overdue pomodoros, generates reports of
+		// done days and sends webhook deliveries.
+		var event events.CloudWatchEvent
+		if err := json.Unmarshal(raw, &event); err == nil && event.DetailType == "Scheduled Event" {
+			n, err := api.SweepPomodoros(store, time.Now().UTC())
+			if err != nil {
+				return nil, err
+			}
+			logger.WithField("count", n).Info("Finished overdue pomodoros")
+
+			n, err = api.GenerateReports(store)
+			if err != nil {
+				return nil, err
+			}
+			logger.WithField("count", n).Info("Generated reports")
+
+			// Deliveries emitted above are also sent.
+			n, err = api.DeliverWebhooks(store, time.Now().UTC())
+			if err != nil {
+				return nil, err
+			}
+			logger.WithField("count", n).Info("Delivered webhooks")
+			return nil, nil
+		}
+
+		var req events.APIGatewayProxyRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return nil, err
+		}
 		return ginLambda.Proxy(req)
 	})
 }
@@ -1,27 +1,57 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/m-mizutani/task-kitchen/api"
+	"github.com/m-mizutani/task-kitchen/config"
 	"github.com/sirupsen/logrus"
 )
 
 var logger = logrus.New()
 
 func main() {
-	logger.SetLevel(logrus.DebugLevel)
 	api.Logger = logger
 
-	if len(os.Args) != 3 {
-		logger.Fatal("syntax error) server [region] [table_name]")
+	cfg := config.Default()
+	if err := cfg.Load(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Fail to load config")
+	}
+	cfg.SetupLogger(logger)
+
+	store, err := cfg.NewStore()
+	if err != nil {
+		logger.WithError(err).Fatal("Fail to setup store")
+	}
+	opts, err := cfg.Options()
+	if err != nil {
+		logger.WithError(err).Fatal("Fail to setup API options")
+	}
+	// Changes by requests and background jobs are streamed to clients of
+	// this process.
+	opts.Broker = api.NewHub()
+
+	if interval := cfg.SweepInterval(); interval > 0 && opts.Features.Enabled(api.FeaturePomodoro) {
+		go api.RunPomodoroSweeper(context.Background(), store, opts.Broker, interval)
+	}
+	if interval := cfg.GenerateInterval(); interval > 0 {
+		go api.RunReportGenerator(context.Background(), store, interval)
+	}
+	if interval := cfg.DeliverInterval(); interval > 0 {
+		go api.RunWebhookDispatcher(context.Background(), store, interval)
 	}
 
 	r := gin.Default()
-	v1 := r.Group("/api/v1")
-	api.SetupRouter(v1, os.Args[1], os.Args[2])
+	if len(cfg.CORSOrigins) > 0 {
+		r.Use(api.CORS(cfg.CORSOrigins))
+	}
+	v1 := r.Group(cfg.BasePath)
+	api.SetupRouter(v1, store, opts)
 
-	r.Run("127.0.0.1:9080")
+	if err := r.Run(cfg.ListenAddr); err != nil {
+		logger.WithError(err).Fatal("Server stopped")
+	}
 }